@@ -0,0 +1,191 @@
+package xserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const secretMask = "******"
+
+// ConfigEndpointOptions configures the effective configuration endpoint
+type ConfigEndpointOptions struct {
+	// EnvPrefix is the envconfig prefix the Config was loaded with
+	EnvPrefix string
+	// InFile reports whether a mapstructure key was read from a config file, viper.InConfig fits
+	InFile func(key string) bool
+	// Token is required as a bearer token, the endpoint answers 401 without it
+	Token string
+}
+
+// EffectiveField is a Config field with its current value and where it comes from
+type EffectiveField struct {
+	ConfigField
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// EffectiveConfig returns the Config fields with their values, secrets are masked.
+// Source is env, file, default or code for values set programmatically.
+func EffectiveConfig(cfg Config, opts ConfigEndpointOptions) []EffectiveField {
+	v := reflect.ValueOf(cfg)
+	fields := ConfigFields(opts.EnvPrefix)
+	res := make([]EffectiveField, 0, len(fields))
+	for _, f := range fields {
+		fv := v.FieldByName(f.Name)
+		value := fmt.Sprint(fv.Interface())
+		e := EffectiveField{ConfigField: f, Value: value}
+		switch {
+		case os.Getenv(f.Env) != "":
+			e.Source = "env"
+		case opts.InFile != nil && opts.InFile(f.Key):
+			e.Source = "file"
+		case isDefault(fv, value, f.Default):
+			e.Source = "default"
+		default:
+			e.Source = "code"
+		}
+		if f.Secret && value != "" {
+			e.Value = secretMask
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// isDefault reports whether a field holds its default, durations are compared
+// by value as "0" and "0s" are the same default
+func isDefault(v reflect.Value, value, def string) bool {
+	if value == def || (def == "" && v.IsZero()) {
+		return true
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		parsed, err := time.ParseDuration(def)
+		return err == nil && parsed == d
+	}
+	return false
+}
+
+type routeInfo struct {
+	Method      string   `json:"method"`
+	Pattern     string   `json:"pattern"`
+	Middlewares []string `json:"middlewares,omitempty"`
+	// Timeout is the request timeout of the route, none for the routes WithoutTimeout
+	Timeout string `json:"timeout"`
+	// RateLimit is the requests in flight counting the route, none for the routes WithoutRateLimit
+	RateLimit  string   `json:"rate_limit"`
+	Buckets    string   `json:"buckets,omitempty"`
+	EarlyHints []string `json:"early_hints,omitempty"`
+}
+
+type buildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+type configDump struct {
+	Config      []EffectiveField `json:"config"`
+	Middlewares []string         `json:"middlewares"`
+	Routes      []routeInfo      `json:"routes"`
+	Build       buildInfo        `json:"build"`
+}
+
+func funcName(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	return f.Name()
+}
+
+func readBuildInfo() buildInfo {
+	b := buildInfo{GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Path = info.Main.Path
+	b.Version = info.Main.Version
+	b.Settings = make(map[string]string)
+	for _, s := range info.Settings {
+		if strings.HasPrefix(s.Key, "vcs.") || s.Key == "GOOS" || s.Key == "GOARCH" || s.Key == "CGO_ENABLED" {
+			b.Settings[s.Key] = s.Value
+		}
+	}
+	return b
+}
+
+// ConfigHandler dumps the effective Config, the middlewares and routes of the router with
+// their options and the build info. It requires opts.Token as a bearer token.
+func ConfigHandler(cfg Config, router Muxer, opts ConfigEndpointOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if opts.Token == "" || len(auth) <= 7 || !strings.EqualFold(auth[:7], "bearer ") ||
+			subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(opts.Token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		dump := configDump{
+			Config: EffectiveConfig(cfg, opts),
+			Build:  readBuildInfo(),
+		}
+		for _, mw := range router.Mux().Middlewares() {
+			dump.Middlewares = append(dump.Middlewares, funcName(mw))
+		}
+		_ = chi.Walk(router.Mux(), func(method, pattern string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			ri := routeInfo{Method: method, Pattern: pattern, Timeout: requestTimeout(cfg).String(), RateLimit: "none"}
+			if cfg.RateLimit > 0 {
+				ri.RateLimit = strconv.FormatInt(int64(cfg.RateLimit), 10)
+			}
+			for _, mw := range middlewares {
+				ri.Middlewares = append(ri.Middlewares, funcName(mw))
+			}
+			if rt, ok := handler.(*route); ok {
+				if rt.opts.noTimeout {
+					ri.Timeout = "none"
+				}
+				if rt.opts.noRateLimit {
+					ri.RateLimit = "none"
+				}
+				if rt.opts.buckets != nil {
+					ri.Buckets = rt.opts.buckets.Name
+				}
+				ri.EarlyHints = rt.opts.hints
+			}
+			dump.Routes = append(dump.Routes, ri)
+			return nil
+		})
+
+		d, err := json.MarshalIndent(dump, "", "  ")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(d)
+	}
+}
+
+// ConfigEndpoint registers the effective configuration dump on the router,
+// it refuses to expose the dump without a token
+func ConfigEndpoint(router Router, cfg Config, path string, opts ConfigEndpointOptions) error {
+	if opts.Token == "" {
+		return errors.New("xserver: the config endpoint requires a token")
+	}
+	router.Get(path, ConfigHandler(cfg, router, opts))
+	return nil
+}
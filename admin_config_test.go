@@ -0,0 +1,80 @@
+package xserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfigEndpoint(t *testing.T) {
+	cfg := Config{Timeout: 3 * time.Second, RateLimit: 10, MetricsToken: "metrics"}
+	r := NewRouter(cfg)
+	if err := ConfigEndpoint(r, cfg, "/_config", ConfigEndpointOptions{}); err == nil {
+		t.Fatal("the config endpoint was registered without a token")
+	}
+	if err := ConfigEndpoint(r, cfg, "/_config", ConfigEndpointOptions{Token: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	noop := func(http.ResponseWriter, *http.Request) {}
+	r.Get("/plain", noop)
+	r.Get("/poll", noop, WithoutTimeout(), WithoutRateLimit())
+	r.Get("/report", noop, WithBuckets(SlowBuckets), WithEarlyHints("</app.css>; rel=preload; as=style"))
+
+	for _, auth := range []string{"", "Bearer wrong", "Basic secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/_config", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		r.Mux().ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", auth, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/_config", nil)
+	req.Header.Set("Authorization", "bearer secret")
+	rec := httptest.NewRecorder()
+	r.Mux().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var dump configDump
+	if err := json.Unmarshal(rec.Body.Bytes(), &dump); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]routeInfo{}
+	for _, ri := range dump.Routes {
+		routes[ri.Pattern] = ri
+	}
+	if ri := routes["/plain"]; ri.Timeout != "3s" || ri.RateLimit != "10" || ri.Buckets != "" {
+		t.Errorf("/plain = %+v", ri)
+	}
+	if ri := routes["/poll"]; ri.Timeout != "none" || ri.RateLimit != "none" {
+		t.Errorf("/poll = %+v", ri)
+	}
+	if ri := routes["/report"]; ri.Buckets != "slow" || len(ri.EarlyHints) != 1 {
+		t.Errorf("/report = %+v", ri)
+	}
+
+	fields := map[string]EffectiveField{}
+	for _, f := range dump.Config {
+		fields[f.Name] = f
+	}
+	tests := []struct {
+		name   string
+		value  string
+		source string
+	}{
+		{"Timeout", "3s", "code"},
+		{"MetricsTimeout", "0s", "default"},
+		{"MetricsToken", secretMask, "code"},
+		{"MetricsPassword", "", "default"},
+	}
+	for _, tt := range tests {
+		if f := fields[tt.name]; f.Value != tt.value || f.Source != tt.source {
+			t.Errorf("%s = %q from %s, want %q from %s", tt.name, f.Value, f.Source, tt.value, tt.source)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package apikey
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/l00p8/xserver"
+)
+
+// RegisterAdmin mounts the key administration endpoints under prefix:
+//
+//	POST   {prefix}/keys        issue a key, body {"name": "...", "quota": 1000}
+//	GET    {prefix}/keys        list keys
+//	DELETE {prefix}/keys/{id}   revoke a key
+//	GET    {prefix}/usage       usage of ?period=YYYY-MM (current by default), ?format=csv for billing
+//
+// The endpoints require the admin token as a bearer token.
+func (m *Manager) RegisterAdmin(r xserver.Router, prefix string) error {
+	if m.cfg.AdminToken == "" {
+		return errors.New("apikey: admin endpoints require an admin token")
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+	r.Post(prefix+"/keys", m.admin(m.issueHandler))
+	r.Get(prefix+"/keys", m.admin(m.listHandler))
+	r.Delete(prefix+"/keys/{id}", m.admin(m.revokeHandler))
+	r.Get(prefix+"/usage", m.admin(m.usageHandler))
+	return nil
+}
+
+func (m *Manager) admin(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.isAdmin(r) {
+			writeError(w, http.StatusUnauthorized, "admin token is required")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+type issueRequest struct {
+	Name  string `json:"name"`
+	Quota int64  `json:"quota"`
+}
+
+type issueResponse struct {
+	Key
+	Plaintext string `json:"key"`
+}
+
+func (m *Manager) issueHandler(w http.ResponseWriter, r *http.Request) {
+	var req issueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "a json body with a name is required")
+		return
+	}
+	k, plaintext, err := m.Issue(r.Context(), req.Name, req.Quota)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	k.Hash = ""
+	writeJSON(w, http.StatusCreated, issueResponse{Key: k, Plaintext: plaintext})
+}
+
+func (m *Manager) listHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := m.store.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (m *Manager) revokeHandler(w http.ResponseWriter, r *http.Request) {
+	err := m.Revoke(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Manager) usageHandler(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = Period(m.now())
+	}
+	usage, err := m.store.Usage(r.Context(), period)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if r.URL.Query().Get("format") != "csv" {
+		writeJSON(w, http.StatusOK, usage)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage-`+period+`.csv"`)
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"key_id", "name", "period", "used", "quota"})
+	for _, u := range usage {
+		k, err := m.store.Get(r.Context(), u.KeyID)
+		if err != nil {
+			k = Key{ID: u.KeyID}
+		}
+		_ = cw.Write([]string{u.KeyID, k.Name, u.Period, strconv.FormatInt(u.Used, 10), strconv.FormatInt(k.Quota, 10)})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,92 @@
+// Package apikey issues API keys and enforces their monthly usage quotas.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("apikey: key not found")
+	ErrExists   = errors.New("apikey: key already exists")
+)
+
+// Key describes an issued API key, only the hash of the secret is stored
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash,omitempty"`
+	Quota     int64      `json:"quota"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Revoked reports whether the key was revoked
+func (k Key) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+// Usage is the consumption of a key during a billing period
+type Usage struct {
+	KeyID  string `json:"key_id"`
+	Period string `json:"period"`
+	Used   int64  `json:"used"`
+}
+
+// Store persists keys and usage counters
+type Store interface {
+	Create(ctx context.Context, key Key) error
+	Get(ctx context.Context, id string) (Key, error)
+	GetByHash(ctx context.Context, hash string) (Key, error)
+	List(ctx context.Context) ([]Key, error)
+	Revoke(ctx context.Context, id string, at time.Time) error
+	// Consume atomically adds cost to the usage of the period unless it would exceed
+	// the limit, a zero limit is unlimited. It returns the usage after the call.
+	Consume(ctx context.Context, id, period string, cost, limit int64) (used int64, ok bool, err error)
+	Usage(ctx context.Context, period string) ([]Usage, error)
+}
+
+const keyPrefix = "xk_"
+
+// Hash returns the stored representation of a plaintext key
+func Hash(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// generate returns a new key id and plaintext secret
+func generate() (id, plaintext string, err error) {
+	b := make([]byte, 8+24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(b[:8])
+	secret := base64.RawURLEncoding.EncodeToString(b[8:])
+	return id, keyPrefix + id + "_" + secret, nil
+}
+
+// idOf extracts the id from a plaintext key
+func idOf(plaintext string) string {
+	rest := strings.TrimPrefix(plaintext, keyPrefix)
+	if i := strings.IndexByte(rest, '_'); i > 0 && rest != plaintext {
+		return rest[:i]
+	}
+	return ""
+}
+
+// Period returns the monthly billing period of t
+func Period(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// periodEnd returns the start of the month following t
+func periodEnd(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
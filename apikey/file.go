@@ -0,0 +1,235 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileStore keeps keys and usage counters in a json file. Key changes are written
+// immediately, usage counters every flush interval, when a key reaches its quota and
+// on Shutdown, so a restart loses at most a flush interval of usage below the quota.
+type FileStore struct {
+	path string
+
+	mu     sync.Mutex
+	data   fileData
+	byHash map[string]string
+	dirty  bool
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type fileData struct {
+	Keys  map[string]Key              `json:"keys"`
+	Usage map[string]map[string]int64 `json:"usage"`
+}
+
+// NewFileStore loads the store from path, the file is created on the first write
+func NewFileStore(path string, flushInterval time.Duration) (*FileStore, error) {
+	s := &FileStore{
+		path:   path,
+		data:   fileData{Keys: map[string]Key{}, Usage: map[string]map[string]int64{}},
+		byHash: map[string]string{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	d, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(d, &s.data); err != nil {
+			return nil, err
+		}
+		if s.data.Keys == nil {
+			s.data.Keys = map[string]Key{}
+		}
+		if s.data.Usage == nil {
+			s.data.Usage = map[string]map[string]int64{}
+		}
+	}
+	for id, k := range s.data.Keys {
+		s.byHash[k.Hash] = id
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	go s.flushLoop(flushInterval)
+	return s, nil
+}
+
+func (s *FileStore) flushLoop(interval time.Duration) {
+	defer close(s.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.mu.Lock()
+			if s.dirty {
+				_ = s.writeLocked()
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Start satisfies xserver.Lifecycle, the store flushes from its creation
+func (s *FileStore) Start() error {
+	return nil
+}
+
+// Shutdown stops the background flush and writes pending usage
+func (s *FileStore) Shutdown(ctx context.Context) error {
+	return s.Close()
+}
+
+// Close stops the background flush and writes pending usage
+func (s *FileStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.writeLocked()
+}
+
+// writeLocked replaces the file atomically
+func (s *FileStore) writeLocked() error {
+	d, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(d); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *FileStore) Create(ctx context.Context, key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Keys[key.ID]; ok {
+		return ErrExists
+	}
+	s.data.Keys[key.ID] = key
+	s.byHash[key.Hash] = key.ID
+	if err := s.writeLocked(); err != nil {
+		delete(s.data.Keys, key.ID)
+		delete(s.byHash, key.Hash)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.data.Keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *FileStore) GetByHash(ctx context.Context, hash string) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.byHash[hash]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	return s.data.Keys[id], nil
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]Key, 0, len(s.data.Keys))
+	for _, k := range s.data.Keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *FileStore) Revoke(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.data.Keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if k.RevokedAt != nil {
+		return nil
+	}
+	revoked := k
+	revoked.RevokedAt = &at
+	s.data.Keys[id] = revoked
+	if err := s.writeLocked(); err != nil {
+		s.data.Keys[id] = k
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Consume(ctx context.Context, id, period string, cost, limit int64) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage, ok := s.data.Usage[period]
+	if !ok {
+		usage = map[string]int64{}
+		s.data.Usage[period] = usage
+	}
+	used := usage[id]
+	if limit > 0 && used+cost > limit {
+		return used, false, nil
+	}
+	usage[id] = used + cost
+	s.dirty = true
+	if limit > 0 && used+cost >= limit {
+		// an exhausted quota must survive a restart, a failed write is retried by the flush
+		_ = s.writeLocked()
+	}
+	return used + cost, true, nil
+}
+
+func (s *FileStore) Usage(ctx context.Context, period string) ([]Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]Usage, 0, len(s.data.Usage[period]))
+	for id, used := range s.data.Usage[period] {
+		res = append(res, Usage{KeyID: id, Period: period, Used: used})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].KeyID < res[j].KeyID })
+	return res, nil
+}
@@ -0,0 +1,110 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+	s, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestFileStoreCreateRollsBack(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, filepath.Join(t.TempDir(), "missing", "keys.json"))
+
+	k := Key{ID: "k1", Hash: Hash("secret")}
+	if err := s.Create(ctx, k); err == nil {
+		t.Fatal("Create succeeded without a writable file")
+	}
+	if _, err := s.Get(ctx, "k1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after a failed Create = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetByHash(ctx, k.Hash); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByHash after a failed Create = %v, want ErrNotFound", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, k); err != nil {
+		t.Errorf("Create after the failure = %v", err)
+	}
+}
+
+func TestFileStoreRevokeRollsBack(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestStore(t, filepath.Join(dir, "keys.json"))
+	if err := s.Create(ctx, Key{ID: "k1", Hash: Hash("secret")}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.path = filepath.Join(dir, "missing", "keys.json")
+	if err := s.Revoke(ctx, "k1", time.Now()); err == nil {
+		t.Fatal("Revoke succeeded without a writable file")
+	}
+	if k, _ := s.Get(ctx, "k1"); k.Revoked() {
+		t.Error("the key is revoked after a failed Revoke")
+	}
+}
+
+func TestFileStoreUsage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	s := newTestStore(t, path)
+	if err := s.Create(ctx, Key{ID: "k1", Hash: Hash("secret"), Quota: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cost int64
+		used int64
+		ok   bool
+	}{
+		{1, 1, true},
+		{1, 2, true},
+		{2, 2, false},
+		{1, 3, true},
+		{1, 3, false},
+	}
+	for i, tt := range tests {
+		used, ok, err := s.Consume(ctx, "k1", "2024-01", tt.cost, 3)
+		if err != nil || used != tt.used || ok != tt.ok {
+			t.Errorf("consume %d = %d, %v, %v, want %d, %v", i, used, ok, err, tt.used, tt.ok)
+		}
+	}
+
+	// the exhausted quota is written without waiting for the flush
+	reloaded := newTestStore(t, path)
+	if usage, _ := reloaded.Usage(ctx, "2024-01"); len(usage) != 1 || usage[0].Used != 3 {
+		t.Errorf("usage after reaching the quota = %+v, want 3", usage)
+	}
+}
+
+func TestFileStoreShutdownFlushes(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	s := newTestStore(t, path)
+	if _, _, err := s.Consume(ctx, "k1", "2024-01", 5, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newTestStore(t, path)
+	if usage, _ := reloaded.Usage(ctx, "2024-01"); len(usage) != 1 || usage[0].Used != 5 {
+		t.Errorf("usage after Shutdown = %+v, want 5", usage)
+	}
+}
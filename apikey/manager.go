@@ -0,0 +1,165 @@
+package apikey
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes the key checks
+type Config struct {
+	// Header carrying the key, keys are also accepted as "Authorization: Bearer <key>"
+	Header string `envconfig:"header" mapstructure:"header" default:"X-API-Key"`
+	// DefaultQuota is the monthly quota of keys issued without one, zero is unlimited
+	DefaultQuota int64 `envconfig:"default_quota" mapstructure:"default_quota" default:"0"`
+	// AdminToken protects the admin endpoints, they are disabled when it is empty
+	AdminToken string `envconfig:"admin_token" mapstructure:"admin_token" default:"" secret:"true"`
+}
+
+// Manager issues keys and enforces their quotas
+type Manager struct {
+	cfg   Config
+	store Store
+	now   func() time.Time
+}
+
+func NewManager(cfg Config, store Store) *Manager {
+	if cfg.Header == "" {
+		cfg.Header = "X-API-Key"
+	}
+	return &Manager{cfg: cfg, store: store, now: time.Now}
+}
+
+// Issue creates a key and returns it with its plaintext, which is not stored
+func (m *Manager) Issue(ctx context.Context, name string, quota int64) (Key, string, error) {
+	id, plaintext, err := generate()
+	if err != nil {
+		return Key{}, "", err
+	}
+	if quota == 0 {
+		quota = m.cfg.DefaultQuota
+	}
+	k := Key{ID: id, Name: name, Hash: Hash(plaintext), Quota: quota, CreatedAt: m.now().UTC()}
+	if err := m.store.Create(ctx, k); err != nil {
+		return Key{}, "", err
+	}
+	return k, plaintext, nil
+}
+
+// Revoke disables a key
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	return m.store.Revoke(ctx, id, m.now().UTC())
+}
+
+// Lookup returns the active key matching a plaintext
+func (m *Manager) Lookup(ctx context.Context, plaintext string) (Key, error) {
+	k, err := m.store.GetByHash(ctx, Hash(plaintext))
+	if err != nil {
+		return Key{}, err
+	}
+	if k.Revoked() || k.ID != idOf(plaintext) {
+		return Key{}, ErrNotFound
+	}
+	return k, nil
+}
+
+type ctxKey struct{}
+
+// FromContext returns the key of an authenticated request
+func FromContext(ctx context.Context) (Key, bool) {
+	k, ok := ctx.Value(ctxKey{}).(Key)
+	return k, ok
+}
+
+func (m *Manager) plaintext(r *http.Request) string {
+	if v := r.Header.Get(m.cfg.Header); v != "" {
+		return v
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// Middleware authenticates requests by key and charges cost to the key quota,
+// the remaining quota is reported in the X-Quota-Remaining header
+func (m *Manager) Middleware(cost int64) func(http.Handler) http.Handler {
+	if cost <= 0 {
+		cost = 1
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			plaintext := m.plaintext(r)
+			if plaintext == "" {
+				writeError(w, http.StatusUnauthorized, "api key is required")
+				return
+			}
+			k, err := m.Lookup(r.Context(), plaintext)
+			if errors.Is(err, ErrNotFound) {
+				writeError(w, http.StatusUnauthorized, "invalid api key")
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+
+			now := m.now()
+			used, ok, err := m.store.Consume(r.Context(), k.ID, Period(now), cost, k.Quota)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+				return
+			}
+			if k.Quota > 0 {
+				reset := periodEnd(now)
+				w.Header().Set("X-Quota-Limit", strconv.FormatInt(k.Quota, 10))
+				w.Header().Set("X-Quota-Remaining", strconv.FormatInt(k.Quota-used, 10))
+				w.Header().Set("X-Quota-Reset", strconv.FormatInt(reset.Unix(), 10))
+				if !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+					writeError(w, http.StatusTooManyRequests, "quota exceeded")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, k)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Handler protects a single handler, cost is the weight of the route in the quota
+func (m *Manager) Handler(cost int64, fn http.HandlerFunc) http.HandlerFunc {
+	return m.Middleware(cost)(fn).ServeHTTP
+}
+
+func (m *Manager) isAdmin(r *http.Request) bool {
+	if m.cfg.AdminToken == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(m.cfg.AdminToken)) == 1
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
@@ -0,0 +1,63 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(Config{}, newTestStore(t, filepath.Join(t.TempDir(), "keys.json")))
+	m.now = func() time.Time { return time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC) }
+
+	_, plaintext, err := m.Issue(ctx, "billing", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedPlaintext, err := m.Issue(ctx, "old", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Revoke(ctx, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	h := m.Middleware(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := FromContext(r.Context()); !ok {
+			t.Error("the key is not in the request context")
+		}
+	}))
+
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		status    int
+		remaining string
+	}{
+		{"no key", "", "", http.StatusUnauthorized, ""},
+		{"unknown key", "X-API-Key", keyPrefix + "0000_secret", http.StatusUnauthorized, ""},
+		{"revoked key", "X-API-Key", revokedPlaintext, http.StatusUnauthorized, ""},
+		{"header", "X-API-Key", plaintext, http.StatusOK, "1"},
+		{"bearer", "Authorization", "Bearer " + plaintext, http.StatusOK, "0"},
+		{"quota exceeded", "X-API-Key", plaintext, http.StatusTooManyRequests, "0"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("X-Quota-Remaining"); got != tt.remaining {
+			t.Errorf("%s: remaining = %q, want %q", tt.name, got, tt.remaining)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package auth authenticates the callers of xserver routes: bearer tokens checked by
+// introspection (RFC 7662) or as JWTs, and browser sessions opened by an OIDC login.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnauthenticated is returned by authenticators when the request carries no valid credentials
+var ErrUnauthenticated = errors.New("auth: unauthenticated")
+
+// Principal is an authenticated caller
+type Principal struct {
+	Subject  string                 `json:"sub"`
+	ClientID string                 `json:"client_id,omitempty"`
+	Scopes   []string               `json:"scopes,omitempty"`
+	Expiry   time.Time              `json:"exp,omitempty"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+}
+
+// HasScope reports whether the principal was granted the scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator identifies the caller of a request, it returns ErrUnauthenticated
+// when the credentials are missing or invalid and another error when it could not tell
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to Authenticator
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// Any tries the authenticators in turn until one recognizes the caller
+func Any(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(r)
+			if errors.Is(err, ErrUnauthenticated) {
+				continue
+			}
+			return p, err
+		}
+		return nil, ErrUnauthenticated
+	})
+}
+
+type ctxKey struct{}
+
+// WithPrincipal returns a context carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(*Principal)
+	return p, ok
+}
+
+// BearerToken returns the token of the Authorization header
+func BearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// Middleware rejects the requests the authenticator does not recognize with 401
+// and passes the principal of the others in their context
+func Middleware(a Authenticator, scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.Authenticate(r)
+			if errors.Is(err, ErrUnauthenticated) {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				writeError(w, http.StatusUnauthorized, "authentication is required")
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusServiceUnavailable, "authentication is unavailable")
+				return
+			}
+			for _, scope := range scopes {
+				if !p.HasScope(scope) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+					writeError(w, http.StatusForbidden, "insufficient scope")
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Handler protects a single handler
+func Handler(a Authenticator, fn http.HandlerFunc, scopes ...string) http.HandlerFunc {
+	return Middleware(a, scopes...)(fn).ServeHTTP
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
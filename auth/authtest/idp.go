@@ -0,0 +1,336 @@
+// Package authtest runs a mock OpenID Connect provider for the tests of code using the auth package.
+// The provider approves every authorization request as the configured user.
+package authtest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyID = "authtest"
+
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	scope       string
+}
+
+type accessToken struct {
+	subject  string
+	clientID string
+	scope    string
+	expiry   time.Time
+}
+
+// IdP is a mock provider serving discovery, JWKS, authorization, token, introspection and logout endpoints
+type IdP struct {
+	*httptest.Server
+
+	// TTL of the issued tokens
+	TTL time.Duration
+
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	clients map[string]string
+	subject string
+	claims  map[string]interface{}
+	codes   map[string]authorization
+	tokens  map[string]accessToken
+	logouts int
+}
+
+// NewIdP starts a provider, its issuer is its URL. Close it with Close.
+func NewIdP() *IdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	idp := &IdP{
+		TTL:     time.Hour,
+		key:     key,
+		clients: make(map[string]string),
+		subject: "user",
+		codes:   make(map[string]authorization),
+		tokens:  make(map[string]accessToken),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/introspect", idp.introspect)
+	mux.HandleFunc("/logout", idp.logout)
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// Issuer returns the issuer identifier of the provider
+func (idp *IdP) Issuer() string {
+	return idp.URL
+}
+
+// IntrospectionURL returns the URL of the introspection endpoint
+func (idp *IdP) IntrospectionURL() string {
+	return idp.URL + "/introspect"
+}
+
+// JWKSURL returns the URL of the signing keys
+func (idp *IdP) JWKSURL() string {
+	return idp.URL + "/jwks"
+}
+
+// AddClient registers a client, public clients have an empty secret
+func (idp *IdP) AddClient(id, secret string) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.clients[id] = secret
+}
+
+// SetUser sets the subject and the extra claims of the user logging in
+func (idp *IdP) SetUser(subject string, claims map[string]interface{}) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.subject = subject
+	idp.claims = claims
+}
+
+// IssueToken returns an opaque access token known to the introspection endpoint
+func (idp *IdP) IssueToken(subject, clientID string, scopes []string, ttl time.Duration) string {
+	token := randomHex()
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.tokens[token] = accessToken{subject: subject, clientID: clientID, scope: strings.Join(scopes, " "), expiry: time.Now().Add(ttl)}
+	return token
+}
+
+// RevokeToken makes an access token inactive
+func (idp *IdP) RevokeToken(token string) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	delete(idp.tokens, token)
+}
+
+// SignJWT signs the claims with the provider key, iss and exp are set when missing
+func (idp *IdP) SignJWT(claims map[string]interface{}) string {
+	c := map[string]interface{}{"iss": idp.Issuer(), "iat": time.Now().Unix(), "exp": time.Now().Add(idp.TTL).Unix()}
+	for k, v := range claims {
+		c[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(c)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Logouts returns the number of end session requests received
+func (idp *IdP) Logouts() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.logouts
+}
+
+func randomHex() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func (idp *IdP) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                idp.Issuer(),
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"jwks_uri":                              idp.JWKSURL(),
+		"introspection_endpoint":                idp.IntrospectionURL(),
+		"end_session_endpoint":                  idp.URL + "/logout",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *IdP) jwks(w http.ResponseWriter, _ *http.Request) {
+	pub := idp.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *IdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	idp.mu.Lock()
+	_, known := idp.clients[q.Get("client_id")]
+	idp.mu.Unlock()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if !known || err != nil || q.Get("redirect_uri") == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	back := redirect.Query()
+	back.Set("state", q.Get("state"))
+	switch {
+	case q.Get("response_type") != "code":
+		back.Set("error", "unsupported_response_type")
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		back.Set("error", "invalid_request")
+	default:
+		code := randomHex()
+		idp.mu.Lock()
+		idp.codes[code] = authorization{
+			clientID:    q.Get("client_id"),
+			redirectURI: q.Get("redirect_uri"),
+			challenge:   q.Get("code_challenge"),
+			nonce:       q.Get("nonce"),
+			scope:       q.Get("scope"),
+		}
+		idp.mu.Unlock()
+		back.Set("code", code)
+	}
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// client authenticates the client of a token or introspection request
+func (idp *IdP) client(r *http.Request) (string, bool) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	expected, ok := idp.clients[id]
+	return id, ok && expected == secret
+}
+
+func (idp *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	clientID, ok := idp.client(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		idp.mu.Lock()
+		a, found := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		subject, claims := idp.subject, idp.claims
+		idp.mu.Unlock()
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !found || a.clientID != clientID || a.redirectURI != r.PostForm.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != a.challenge {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		idClaims := map[string]interface{}{}
+		for k, v := range claims {
+			idClaims[k] = v
+		}
+		idClaims["sub"] = subject
+		idClaims["aud"] = clientID
+		if a.nonce != "" {
+			idClaims["nonce"] = a.nonce
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": idp.IssueToken(subject, clientID, strings.Fields(a.scope), idp.TTL),
+			"token_type":   "Bearer",
+			"expires_in":   int64(idp.TTL / time.Second),
+			"id_token":     idp.SignJWT(idClaims),
+		})
+	case "client_credentials":
+		scope := r.PostForm.Get("scope")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": idp.IssueToken(clientID, clientID, strings.Fields(scope), idp.TTL),
+			"token_type":   "Bearer",
+			"expires_in":   int64(idp.TTL / time.Second),
+		})
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+	}
+}
+
+func (idp *IdP) introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if _, ok := idp.client(r); !ok {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	idp.mu.Lock()
+	t, ok := idp.tokens[r.PostForm.Get("token")]
+	idp.mu.Unlock()
+	if !ok || time.Now().After(t.expiry) {
+		writeJSON(w, http.StatusOK, map[string]bool{"active": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active":     true,
+		"sub":        t.subject,
+		"client_id":  t.clientID,
+		"scope":      t.scope,
+		"exp":        t.expiry.Unix(),
+		"iss":        idp.Issuer(),
+		"token_type": "Bearer",
+	})
+}
+
+func (idp *IdP) logout(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	idp.logouts++
+	idp.mu.Unlock()
+	if back := r.URL.Query().Get("post_logout_redirect_uri"); back != "" {
+		http.Redirect(w, r, back, http.StatusFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
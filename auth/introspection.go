@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IntrospectionConfig describes an RFC 7662 introspection endpoint and the caching of its answers
+type IntrospectionConfig struct {
+	URL          string `envconfig:"introspection_url" mapstructure:"introspection_url" default:""`
+	ClientID     string `envconfig:"introspection_client_id" mapstructure:"introspection_client_id" default:""`
+	ClientSecret string `envconfig:"introspection_client_secret" mapstructure:"introspection_client_secret" default:"" secret:"true"`
+	// CacheTTL bounds how long an active token is trusted without asking again, never past its expiry
+	CacheTTL time.Duration `envconfig:"introspection_cache_ttl" mapstructure:"introspection_cache_ttl" default:"1m"`
+	// NegativeCacheTTL is how long an inactive token is remembered
+	NegativeCacheTTL time.Duration `envconfig:"introspection_negative_cache_ttl" mapstructure:"introspection_negative_cache_ttl" default:"10s"`
+	// MaxEntries of the cache
+	MaxEntries int           `envconfig:"introspection_max_entries" mapstructure:"introspection_max_entries" default:"10000"`
+	Timeout    time.Duration `envconfig:"introspection_timeout" mapstructure:"introspection_timeout" default:"5s"`
+	// HTTPClient calls the endpoint, http.DefaultClient when nil
+	HTTPClient *http.Client `ignored:"true"`
+}
+
+type introspected struct {
+	principal *Principal
+	expiry    time.Time
+}
+
+// Introspection authenticates opaque bearer tokens by asking the authorization server about them
+type Introspection struct {
+	cfg IntrospectionConfig
+	now func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]introspected
+}
+
+// NewIntrospection creates an authenticator using the introspection endpoint
+func NewIntrospection(cfg IntrospectionConfig) *Introspection {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = time.Minute
+	}
+	if cfg.NegativeCacheTTL <= 0 {
+		cfg.NegativeCacheTTL = 10 * time.Second
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 10000
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return &Introspection{cfg: cfg, now: time.Now, cache: make(map[[sha256.Size]byte]introspected)}
+}
+
+func (in *Introspection) Authenticate(r *http.Request) (*Principal, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	return in.Introspect(r.Context(), token)
+}
+
+// Introspect returns the principal of an active token, cached answers are used when possible
+func (in *Introspection) Introspect(ctx context.Context, token string) (*Principal, error) {
+	// tokens are kept hashed so a memory dump does not leak them
+	key := sha256.Sum256([]byte(token))
+	now := in.now()
+	in.mu.Lock()
+	cached, ok := in.cache[key]
+	in.mu.Unlock()
+	if ok && now.Before(cached.expiry) {
+		if cached.principal == nil {
+			return nil, ErrUnauthenticated
+		}
+		return cached.principal, nil
+	}
+
+	p, err := in.call(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	expiry := now.Add(in.cfg.NegativeCacheTTL)
+	if p != nil {
+		expiry = now.Add(in.cfg.CacheTTL)
+		if !p.Expiry.IsZero() && p.Expiry.Before(expiry) {
+			expiry = p.Expiry
+		}
+	}
+	in.store(key, introspected{principal: p, expiry: expiry}, now)
+	if p == nil {
+		return nil, ErrUnauthenticated
+	}
+	return p, nil
+}
+
+func (in *Introspection) store(key [sha256.Size]byte, v introspected, now time.Time) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.cache) >= in.cfg.MaxEntries {
+		for k, e := range in.cache {
+			if !now.Before(e.expiry) {
+				delete(in.cache, k)
+			}
+		}
+		if len(in.cache) >= in.cfg.MaxEntries {
+			in.cache = make(map[[sha256.Size]byte]introspected)
+		}
+	}
+	in.cache[key] = v
+}
+
+type introspectionResponse struct {
+	Active   bool        `json:"active"`
+	Scope    string      `json:"scope"`
+	ClientID string      `json:"client_id"`
+	Subject  string      `json:"sub"`
+	Exp      json.Number `json:"exp"`
+}
+
+// call asks the endpoint about the token, it returns a nil principal for inactive tokens
+func (in *Introspection) call(ctx context.Context, token string) (*Principal, error) {
+	ctx, cancel := context.WithTimeout(ctx, in.cfg.Timeout)
+	defer cancel()
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if in.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(in.cfg.ClientID), url.QueryEscape(in.cfg.ClientSecret))
+	}
+
+	resp, err := in.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: introspection endpoint answered %d", resp.StatusCode)
+	}
+
+	var res introspectionResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("auth: invalid introspection response: %w", err)
+	}
+	if !res.Active {
+		return nil, nil
+	}
+	var claims map[string]interface{}
+	_ = json.Unmarshal(body, &claims)
+	p := &Principal{
+		Subject:  res.Subject,
+		ClientID: res.ClientID,
+		Scopes:   strings.Fields(res.Scope),
+		Claims:   claims,
+	}
+	if exp, err := res.Exp.Int64(); err == nil && exp > 0 {
+		p.Expiry = time.Unix(exp, 0)
+	}
+	return p, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/l00p8/xserver/auth/authtest"
+)
+
+// countingClient counts the requests sent to the introspection endpoint
+func countingClient(n *atomic.Int64) *http.Client {
+	return &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		n.Add(1)
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestIntrospectionCaching(t *testing.T) {
+	idp := authtest.NewIdP()
+	defer idp.Close()
+	idp.AddClient("api", "secret")
+
+	var calls atomic.Int64
+	in := NewIntrospection(IntrospectionConfig{
+		URL:              idp.IntrospectionURL(),
+		ClientID:         "api",
+		ClientSecret:     "secret",
+		CacheTTL:         time.Minute,
+		NegativeCacheTTL: 10 * time.Second,
+		HTTPClient:       countingClient(&calls),
+	})
+	now := time.Now()
+	in.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	token := idp.IssueToken("alice", "web", []string{"read", "write"}, time.Hour)
+	p, err := in.Introspect(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Subject != "alice" || p.ClientID != "web" || !p.HasScope("write") {
+		t.Fatalf("principal = %+v", p)
+	}
+
+	// revoked tokens stay trusted until the cache ttl
+	idp.RevokeToken(token)
+	if _, err := in.Introspect(ctx, token); err != nil {
+		t.Fatalf("cached token: %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("introspection calls = %d, want 1", n)
+	}
+	now = now.Add(time.Minute + time.Second)
+	if _, err := in.Introspect(ctx, token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("revoked token after the cache ttl: %v", err)
+	}
+
+	// inactive answers are cached for the negative ttl
+	if _, err := in.Introspect(ctx, token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("introspection calls = %d, want 2", n)
+	}
+	now = now.Add(11 * time.Second)
+	_, _ = in.Introspect(ctx, token)
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("introspection calls = %d, want 3", n)
+	}
+
+	// the cache never outlives the token
+	short := idp.IssueToken("bob", "web", nil, 5*time.Second)
+	now = time.Now()
+	if _, err := in.Introspect(ctx, short); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(6 * time.Second)
+	_, _ = in.Introspect(ctx, short)
+	if n := calls.Load(); n != 5 {
+		t.Errorf("introspection calls = %d, want 5", n)
+	}
+}
+
+func TestIntrospectionErrors(t *testing.T) {
+	idp := authtest.NewIdP()
+	defer idp.Close()
+	idp.AddClient("api", "secret")
+
+	in := NewIntrospection(IntrospectionConfig{URL: idp.IntrospectionURL(), ClientID: "api", ClientSecret: "wrong"})
+	_, err := in.Introspect(context.Background(), idp.IssueToken("alice", "web", nil, time.Hour))
+	if err == nil || errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("rejected client: %v, want an error other than ErrUnauthenticated", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if _, err := in.Authenticate(req); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("no token: %v", err)
+	}
+}
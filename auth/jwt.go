@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig describes how JWT bearer tokens are validated
+type JWTConfig struct {
+	Issuer string `envconfig:"jwt_issuer" mapstructure:"jwt_issuer" default:""`
+	// Audience the tokens must be issued for, not checked when empty
+	Audience string `envconfig:"jwt_audience" mapstructure:"jwt_audience" default:""`
+	// JWKSURL serves the signing keys of the issuer
+	JWKSURL string `envconfig:"jwt_jwks_url" mapstructure:"jwt_jwks_url" default:""`
+	// Leeway tolerated on the time claims
+	Leeway time.Duration `envconfig:"jwt_leeway" mapstructure:"jwt_leeway" default:"1m"`
+	// HTTPClient fetches the keys, http.DefaultClient when nil
+	HTTPClient *http.Client `ignored:"true"`
+}
+
+// JWT authenticates bearer tokens signed with RS256 or ES256 by keys of the issuer JWKS
+type JWT struct {
+	cfg  JWTConfig
+	keys *jwks
+	now  func() time.Time
+}
+
+// NewJWT creates a JWT authenticator, the keys are fetched on first use and when an unknown key id shows up
+func NewJWT(cfg JWTConfig) *JWT {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &JWT{cfg: cfg, keys: newJWKS(cfg.JWKSURL, cfg.HTTPClient), now: time.Now}
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrUnauthenticated
+	}
+	claims, err := j.Verify(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	return principalOf(claims), nil
+}
+
+// Verify checks the signature, issuer, audience and validity period of a token and returns its claims.
+// Invalid tokens give an error wrapping ErrUnauthenticated.
+func (j *JWT) Verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	var claims map[string]interface{}
+	if decodeSegment(parts[0], &header) != nil || decodeSegment(parts[1], &claims) != nil {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrUnauthenticated)
+	}
+
+	key, err := j.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+	}
+
+	now := j.now()
+	if iss, _ := claims["iss"].(string); j.cfg.Issuer != "" && iss != j.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrUnauthenticated, iss)
+	}
+	if j.cfg.Audience != "" && !hasAudience(claims["aud"], j.cfg.Audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrUnauthenticated)
+	}
+	if exp, ok := numericClaim(claims, "exp"); !ok || now.Add(-j.cfg.Leeway).After(exp) {
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(j.cfg.Leeway).Before(nbf) {
+		return nil, fmt.Errorf("%w: token not valid yet", ErrUnauthenticated)
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		return alg == "ES256" && len(sig) == 64 &&
+			ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	}
+	return false
+}
+
+func hasAudience(aud interface{}, expected string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == expected
+	case []interface{}:
+		for _, a := range v {
+			if a == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// principalOf maps the registered and the common OAuth2 claims to a principal
+func principalOf(claims map[string]interface{}) *Principal {
+	p := &Principal{Claims: claims}
+	p.Subject, _ = claims["sub"].(string)
+	if p.ClientID, _ = claims["client_id"].(string); p.ClientID == "" {
+		p.ClientID, _ = claims["azp"].(string)
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	} else if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				p.Scopes = append(p.Scopes, s)
+			}
+		}
+	}
+	if exp, ok := numericClaim(claims, "exp"); ok {
+		p.Expiry = exp
+	}
+	return p
+}
+
+// jwksMinRefresh limits the refetches of the keys triggered by unknown key ids
+const jwksMinRefresh = 10 * time.Second
+
+// jwks caches the keys of a JSON Web Key Set
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newJWKS(url string, client *http.Client) *jwks {
+	return &jwks{url: url, client: client}
+}
+
+func (s *jwks) get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	if time.Since(s.fetched) < jwksMinRefresh {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrUnauthenticated, kid)
+	}
+	if err := s.fetch(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrUnauthenticated, kid)
+}
+
+// lookup finds the key by id, a token without kid uses the key when the set has only one
+func (s *jwks) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (s *jwks) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: jwks endpoint answered %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("auth: invalid jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	s.keys = keys
+	s.fetched = time.Now()
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("auth: unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("auth: unsupported key type %s", k.Kty)
+}
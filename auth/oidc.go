@@ -0,0 +1,409 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/l00p8/xserver"
+)
+
+// OIDCConfig describes the relying party of an OpenID Connect provider
+type OIDCConfig struct {
+	// Issuer of the provider, its metadata is discovered at /.well-known/openid-configuration
+	Issuer       string `envconfig:"oidc_issuer" mapstructure:"oidc_issuer" default:""`
+	ClientID     string `envconfig:"oidc_client_id" mapstructure:"oidc_client_id" default:""`
+	ClientSecret string `envconfig:"oidc_client_secret" mapstructure:"oidc_client_secret" default:"" secret:"true"`
+	// RedirectURL is the absolute URL of the callback route
+	RedirectURL string   `envconfig:"oidc_redirect_url" mapstructure:"oidc_redirect_url" default:""`
+	Scopes      []string `envconfig:"oidc_scopes" mapstructure:"oidc_scopes" default:"openid,profile,email"`
+	// PostLoginURL is where users land after login when the login had no return_to
+	PostLoginURL string `envconfig:"oidc_post_login_url" mapstructure:"oidc_post_login_url" default:"/"`
+	// PostLogoutURL is where the provider sends users back after logout
+	PostLogoutURL string `envconfig:"oidc_post_logout_url" mapstructure:"oidc_post_logout_url" default:"/"`
+	CookieName    string `envconfig:"oidc_cookie_name" mapstructure:"oidc_cookie_name" default:"xsession"`
+	// CookieKey encrypts the cookie keeping the login in progress, it must be shared by the
+	// replicas. It is derived from the ClientSecret when empty, public clients get a random key.
+	CookieKey string `envconfig:"oidc_cookie_key" mapstructure:"oidc_cookie_key" default:"" secret:"true"`
+	// CookieInsecure drops the Secure attribute of the cookies, for local http setups
+	CookieInsecure bool          `envconfig:"oidc_cookie_insecure" mapstructure:"oidc_cookie_insecure" default:"false"`
+	SessionTTL     time.Duration `envconfig:"oidc_session_ttl" mapstructure:"oidc_session_ttl" default:"8h"`
+	// HTTPClient calls the provider, http.DefaultClient when nil
+	HTTPClient *http.Client `ignored:"true"`
+	// Sessions keeps the sessions, in memory when nil
+	Sessions SessionStore `ignored:"true"`
+}
+
+// loginTTL bounds the time between the redirection to the provider and the callback
+const loginTTL = 10 * time.Minute
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// pendingLogin is kept encrypted in the state cookie between the redirection to the provider and the callback
+type pendingLogin struct {
+	State    string `json:"s"`
+	Verifier string `json:"v"`
+	Nonce    string `json:"n"`
+	ReturnTo string `json:"r"`
+	Expiry   int64  `json:"e"`
+}
+
+// OIDC logs browser users in with the authorization code flow and PKCE,
+// it authenticates the requests carrying its session cookie
+type OIDC struct {
+	cfg      OIDCConfig
+	metadata providerMetadata
+	idTokens *JWT
+	aead     cipher.AEAD
+}
+
+// NewOIDC discovers the provider metadata
+func NewOIDC(ctx context.Context, cfg OIDCConfig) (*OIDC, error) {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.Sessions == nil {
+		cfg.Sessions = NewMemorySessions()
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	if cfg.PostLoginURL == "" {
+		cfg.PostLoginURL = "/"
+	}
+	if cfg.PostLogoutURL == "" {
+		cfg.PostLogoutURL = "/"
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "xsession"
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 8 * time.Hour
+	}
+
+	issuer := strings.TrimSuffix(cfg.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: oidc discovery answered %d", resp.StatusCode)
+	}
+	var md providerMetadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&md); err != nil {
+		return nil, fmt.Errorf("auth: invalid oidc metadata: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("auth: oidc metadata issuer %q does not match %q", md.Issuer, cfg.Issuer)
+	}
+
+	aead, err := cookieCipher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDC{
+		cfg:      cfg,
+		metadata: md,
+		idTokens: NewJWT(JWTConfig{Issuer: md.Issuer, Audience: cfg.ClientID, JWKSURL: md.JWKSURI, Leeway: time.Minute, HTTPClient: cfg.HTTPClient}),
+		aead:     aead,
+	}, nil
+}
+
+func cookieCipher(cfg OIDCConfig) (cipher.AEAD, error) {
+	secret := cfg.CookieKey
+	if secret == "" && cfg.ClientSecret != "" {
+		secret = "xserver oidc cookie " + cfg.ClientSecret
+	}
+	if secret == "" {
+		secret = randomString()
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Register mounts the login, callback and logout routes under the prefix,
+// RedirectURL must point to the callback route. Logout only answers POST so
+// other sites cannot log users out with a link.
+func (o *OIDC) Register(r xserver.Router, prefix string) {
+	r.Get(prefix+"/login", o.Login)
+	r.Get(prefix+"/callback", o.Callback)
+	r.Post(prefix+"/logout", o.Logout)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localPath keeps return_to from redirecting users to other sites
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
+}
+
+func (o *OIDC) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   !o.cfg.CookieInsecure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge > 0 {
+		c.MaxAge = int(maxAge / time.Second)
+	} else {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+func (o *OIDC) stateCookie() string {
+	return o.cfg.CookieName + "_state"
+}
+
+func (o *OIDC) seal(login pendingLogin) (string, error) {
+	data, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, o.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(o.aead.Seal(nonce, nonce, data, []byte(o.stateCookie()))), nil
+}
+
+func (o *OIDC) open(value string) (pendingLogin, error) {
+	var login pendingLogin
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < o.aead.NonceSize() {
+		return login, errors.New("auth: malformed login state")
+	}
+	n := o.aead.NonceSize()
+	plain, err := o.aead.Open(nil, data[:n], data[n:], []byte(o.stateCookie()))
+	if err != nil {
+		return login, err
+	}
+	err = json.Unmarshal(plain, &login)
+	return login, err
+}
+
+// Login redirects to the provider, the return_to query parameter is where users land afterwards
+func (o *OIDC) Login(w http.ResponseWriter, r *http.Request) {
+	state, nonce, verifier := randomString(), randomString(), randomString()
+	returnTo := r.URL.Query().Get("return_to")
+	if !localPath(returnTo) {
+		returnTo = o.cfg.PostLoginURL
+	}
+
+	login := pendingLogin{State: state, Verifier: verifier, Nonce: nonce, ReturnTo: returnTo, Expiry: time.Now().Add(loginTTL).Unix()}
+	sealed, err := o.seal(login)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "login unavailable")
+		return
+	}
+	o.setCookie(w, o.stateCookie(), sealed, loginTTL)
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.cfg.RedirectURL},
+		"scope":                 {strings.Join(o.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, o.metadata.AuthorizationEndpoint+"?"+q.Encode(), http.StatusFound)
+}
+
+// Callback exchanges the authorization code, verifies the ID token and opens the session
+func (o *OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		writeError(w, http.StatusUnauthorized, "login failed: "+e)
+		return
+	}
+	state := q.Get("state")
+	cookie, err := r.Cookie(o.stateCookie())
+	if err != nil || state == "" {
+		writeError(w, http.StatusBadRequest, "invalid login state")
+		return
+	}
+	login, err := o.open(cookie.Value)
+	if err != nil || login.State != state {
+		writeError(w, http.StatusBadRequest, "invalid login state")
+		return
+	}
+	o.setCookie(w, o.stateCookie(), "", 0)
+	if time.Now().Unix() > login.Expiry {
+		writeError(w, http.StatusBadRequest, "login expired")
+		return
+	}
+
+	idToken, err := o.exchange(r.Context(), q.Get("code"), login.Verifier)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "code exchange failed")
+		return
+	}
+	claims, err := o.idTokens.Verify(r.Context(), idToken)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid id token")
+		return
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != login.Nonce {
+		writeError(w, http.StatusUnauthorized, "invalid id token nonce")
+		return
+	}
+
+	s := &Session{
+		ID:        randomString(),
+		Principal: principalOf(claims),
+		IDToken:   idToken,
+		Expiry:    time.Now().Add(o.cfg.SessionTTL),
+	}
+	// the session outlives the id token, it is bound to the session ttl
+	s.Principal.Expiry = s.Expiry
+	o.cfg.Sessions.Set(s)
+	o.setCookie(w, o.cfg.CookieName, s.ID, o.cfg.SessionTTL)
+	http.Redirect(w, r, login.ReturnTo, http.StatusFound)
+}
+
+// exchange redeems the authorization code for the ID token
+func (o *OIDC) exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if o.cfg.ClientSecret == "" {
+		form.Set("client_id", o.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	}
+
+	resp, err := o.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("auth: token endpoint answered %d", resp.StatusCode)
+	}
+	var res struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&res); err != nil {
+		return "", err
+	}
+	if res.IDToken == "" {
+		return "", fmt.Errorf("auth: token response without id_token")
+	}
+	return res.IDToken, nil
+}
+
+func (o *OIDC) session(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(o.cfg.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	s, ok := o.cfg.Sessions.Get(cookie.Value)
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(s.Expiry) {
+		o.cfg.Sessions.Delete(s.ID)
+		return nil, false
+	}
+	return s, true
+}
+
+// Logout closes the session and ends it at the provider when it supports RP-initiated logout,
+// it only answers POST
+func (o *OIDC) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "logout requires POST")
+		return
+	}
+	s, ok := o.session(r)
+	if ok {
+		o.cfg.Sessions.Delete(s.ID)
+	}
+	o.setCookie(w, o.cfg.CookieName, "", 0)
+
+	if o.metadata.EndSessionEndpoint == "" {
+		http.Redirect(w, r, o.cfg.PostLogoutURL, http.StatusSeeOther)
+		return
+	}
+	q := url.Values{
+		"client_id":                {o.cfg.ClientID},
+		"post_logout_redirect_uri": {o.cfg.PostLogoutURL},
+	}
+	if ok {
+		q.Set("id_token_hint", s.IDToken)
+	}
+	http.Redirect(w, r, o.metadata.EndSessionEndpoint+"?"+q.Encode(), http.StatusSeeOther)
+}
+
+// Authenticate returns the principal of the session cookie
+func (o *OIDC) Authenticate(r *http.Request) (*Principal, error) {
+	s, ok := o.session(r)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return s.Principal, nil
+}
+
+// RequireLogin redirects the browsers without session to the login route, coming back to the page afterwards
+func (o *OIDC) RequireLogin(loginPath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			s, ok := o.session(r)
+			if !ok {
+				http.Redirect(w, r, loginPath+"?"+url.Values{"return_to": {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), s.Principal)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,230 @@
+package auth_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/l00p8/xserver/auth"
+	"github.com/l00p8/xserver/auth/authtest"
+)
+
+// newApp serves the login routes under /auth and a page requiring a login at /,
+// the callback is the one of the app when publicURL is empty
+func newApp(t *testing.T, idp *authtest.IdP, cookieKey, publicURL string) *httptest.Server {
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+	t.Cleanup(app.Close)
+	if publicURL == "" {
+		publicURL = app.URL
+	}
+
+	o, err := auth.NewOIDC(context.Background(), auth.OIDCConfig{
+		Issuer:         idp.Issuer(),
+		ClientID:       "app",
+		ClientSecret:   "secret",
+		RedirectURL:    publicURL + "/auth/callback",
+		CookieInsecure: true,
+		CookieKey:      cookieKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.HandleFunc("/auth/login", o.Login)
+	mux.HandleFunc("/auth/callback", o.Callback)
+	mux.HandleFunc("/auth/logout", o.Logout)
+	mux.Handle("/", o.RequireLogin("/auth/login")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.FromContext(r.Context())
+		_, _ = io.WriteString(w, p.Subject+" "+r.URL.Path)
+	})))
+	return app
+}
+
+func newIdP(t *testing.T) *authtest.IdP {
+	idp := authtest.NewIdP()
+	t.Cleanup(idp.Close)
+	idp.AddClient("app", "secret")
+	idp.SetUser("alice", map[string]interface{}{"email": "alice@example.com"})
+	return idp
+}
+
+func browser() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar}
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	idp := newIdP(t)
+	app := newApp(t, idp, "", "")
+	c := browser()
+
+	resp, err := c.Get(app.URL + "/reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "alice /reports" {
+		t.Fatalf("after login: %d %q", resp.StatusCode, body)
+	}
+
+	// the session cookie authenticates the next requests without going to the provider
+	c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err = c.Get(app.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("with the session: %d", resp.StatusCode)
+	}
+
+	resp, err = c.Get(app.URL + "/auth/logout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET logout: %d, want 405", resp.StatusCode)
+	}
+	resp, err = c.Post(app.URL+"/auth/logout", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther || !strings.HasPrefix(resp.Header.Get("Location"), idp.URL+"/logout?") {
+		t.Errorf("POST logout: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, err = c.Get(app.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("after logout: %d, want a redirection to the login", resp.StatusCode)
+	}
+}
+
+// login starts a login and returns the state cookie and the redirection to the provider
+func login(t *testing.T, app *httptest.Server) (*http.Cookie, *url.URL) {
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := c.Get(app.URL + "/auth/login?return_to=/back")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "xsession_state" {
+			return cookie, loc
+		}
+	}
+	t.Fatal("no state cookie")
+	return nil, nil
+}
+
+// authorize follows the redirection to the provider and returns the callback URL
+func authorize(t *testing.T, loc *url.URL) string {
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := c.Get(loc.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Location")
+}
+
+func callback(t *testing.T, callbackURL string, state *http.Cookie) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, callbackURL, nil)
+	if state != nil {
+		req.AddCookie(state)
+	}
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestOIDCCallback(t *testing.T) {
+	idp := newIdP(t)
+	app := newApp(t, idp, "shared key", "")
+
+	t.Run("pkce", func(t *testing.T) {
+		state, loc := login(t, app)
+		q := loc.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+			t.Fatalf("authorization request without PKCE: %s", loc)
+		}
+		if strings.Contains(state.Value, q.Get("state")) {
+			t.Error("the state cookie is not encrypted")
+		}
+		resp := callback(t, authorize(t, loc), state)
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/back" {
+			t.Errorf("callback: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	})
+
+	t.Run("another replica", func(t *testing.T) {
+		replica := newApp(t, idp, "shared key", app.URL)
+		state, loc := login(t, app)
+		callbackURL := strings.Replace(authorize(t, loc), app.URL, replica.URL, 1)
+		// the replica has no record of the login, the cookie carries it
+		resp := callback(t, callbackURL, state)
+		if resp.StatusCode != http.StatusFound {
+			t.Errorf("callback on a replica: %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("missing cookie", func(t *testing.T) {
+		_, loc := login(t, app)
+		if resp := callback(t, authorize(t, loc), nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("callback: %d, want 400", resp.StatusCode)
+		}
+	})
+
+	t.Run("state of another login", func(t *testing.T) {
+		state, _ := login(t, app)
+		_, loc := login(t, app)
+		if resp := callback(t, authorize(t, loc), state); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("callback: %d, want 400", resp.StatusCode)
+		}
+	})
+
+	t.Run("tampered cookie", func(t *testing.T) {
+		state, loc := login(t, app)
+		state.Value = state.Value[:len(state.Value)-2] + "AA"
+		if resp := callback(t, authorize(t, loc), state); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("callback: %d, want 400", resp.StatusCode)
+		}
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		other := newApp(t, idp, "other key", app.URL)
+		state, loc := login(t, app)
+		callbackURL := strings.Replace(authorize(t, loc), app.URL, other.URL, 1)
+		if resp := callback(t, callbackURL, state); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("callback: %d, want 400", resp.StatusCode)
+		}
+	})
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		state, loc := login(t, app)
+		q := loc.Query()
+		q.Set("code_challenge", "bm90IHRoZSBjaGFsbGVuZ2U")
+		loc.RawQuery = q.Encode()
+		if resp := callback(t, authorize(t, loc), state); resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("callback: %d, want 502", resp.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Session is a browser session opened by an OIDC login
+type Session struct {
+	ID        string
+	Principal *Principal
+	// IDToken is sent back to the provider on logout
+	IDToken string
+	Expiry  time.Time
+}
+
+// SessionStore keeps the sessions by id, implementations must be safe for concurrent use
+type SessionStore interface {
+	Get(id string) (*Session, bool)
+	Set(s *Session)
+	Delete(id string)
+}
+
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessions keeps the sessions in memory, they are lost on restart
+func NewMemorySessions() SessionStore {
+	return &memorySessions{sessions: make(map[string]*Session)}
+}
+
+func (m *memorySessions) Get(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	return s, ok
+}
+
+func (m *memorySessions) Set(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, old := range m.sessions {
+		if now.After(old.Expiry) {
+			delete(m.sessions, id)
+		}
+	}
+	m.sessions[s.ID] = s
+}
+
+func (m *memorySessions) Delete(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Balancer picks the endpoint serving a request among the available ones, endpoints is never empty
+type Balancer interface {
+	Pick(r *http.Request, endpoints []*Endpoint) *Endpoint
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin cycles through the endpoints
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(_ *http.Request, endpoints []*Endpoint) *Endpoint {
+	return endpoints[(b.next.Add(1)-1)%uint64(len(endpoints))]
+}
+
+type p2c struct{}
+
+// P2C picks the least loaded of two random endpoints, the load being the requests in flight
+func P2C() Balancer {
+	return p2c{}
+}
+
+func (p2c) Pick(_ *http.Request, endpoints []*Endpoint) *Endpoint {
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+	i := rand.Intn(len(endpoints))
+	j := rand.Intn(len(endpoints) - 1)
+	if j >= i {
+		j++
+	}
+	if endpoints[j].InFlight() < endpoints[i].InFlight() {
+		return endpoints[j]
+	}
+	return endpoints[i]
+}
+
+type ringPoint struct {
+	hash uint32
+	addr string
+}
+
+type ringHash struct {
+	key      func(r *http.Request) string
+	replicas int
+
+	mu   sync.Mutex
+	set  string
+	ring []ringPoint
+}
+
+// RingHash sends the requests with the same key to the same endpoint through consistent hashing,
+// only the keys of an endpoint move when it leaves. A nil key hashes the URL path.
+func RingHash(key func(r *http.Request) string, replicas int) Balancer {
+	if key == nil {
+		key = func(r *http.Request) string {
+			return r.URL.Path
+		}
+	}
+	if replicas <= 0 {
+		replicas = 100
+	}
+	return &ringHash{key: key, replicas: replicas}
+}
+
+// hash32 is FNV-1a with the murmur3 finalizer, FNV alone spreads the
+// similar point names of an endpoint over a narrow part of the ring
+func hash32(s string) uint32 {
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(s))
+	h := f.Sum32()
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func (b *ringHash) Pick(r *http.Request, endpoints []*Endpoint) *Endpoint {
+	byAddr := make(map[string]*Endpoint, len(endpoints))
+	addrs := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		byAddr[e.Addr] = e
+		addrs[i] = e.Addr
+	}
+	sort.Strings(addrs)
+	ring := b.ringFor(addrs)
+
+	h := hash32(b.key(r))
+	i := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= h
+	})
+	if i == len(ring) {
+		i = 0
+	}
+	return byAddr[ring[i].addr]
+}
+
+// ringFor returns the ring of the addresses, rebuilt when they change
+func (b *ringHash) ringFor(addrs []string) []ringPoint {
+	set := strings.Join(addrs, ",")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if set == b.set {
+		return b.ring
+	}
+
+	ring := make([]ringPoint, 0, len(addrs)*b.replicas)
+	for _, addr := range addrs {
+		for i := 0; i < b.replicas; i++ {
+			ring = append(ring, ringPoint{hash: hash32(addr + "#" + strconv.Itoa(i)), addr: addr})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	b.set, b.ring = set, ring
+	return ring
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func endpointsOf(addrs ...string) []*Endpoint {
+	res := make([]*Endpoint, len(addrs))
+	for i, addr := range addrs {
+		res[i] = &Endpoint{Addr: addr}
+	}
+	return res
+}
+
+func TestRoundRobin(t *testing.T) {
+	b := RoundRobin()
+	endpoints := endpointsOf("a", "b", "c")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	var got string
+	for i := 0; i < 6; i++ {
+		got += b.Pick(req, endpoints).Addr
+	}
+	if got != "abcabc" {
+		t.Errorf("picks = %q, want abcabc", got)
+	}
+}
+
+func TestP2C(t *testing.T) {
+	b := P2C()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if e := b.Pick(req, endpointsOf("only")); e.Addr != "only" {
+		t.Errorf("single endpoint = %s", e.Addr)
+	}
+
+	// of two endpoints both are always compared, the idle one wins
+	endpoints := endpointsOf("busy", "idle")
+	endpoints[0].inflight.Store(5)
+	for i := 0; i < 100; i++ {
+		if e := b.Pick(req, endpoints); e.Addr != "idle" {
+			t.Fatalf("picked %s over the idle endpoint", e.Addr)
+		}
+	}
+
+	// the two candidates are distinct, so the busiest endpoint is never picked
+	endpoints = endpointsOf("a", "b", "busiest")
+	endpoints[0].inflight.Store(1)
+	endpoints[1].inflight.Store(1)
+	endpoints[2].inflight.Store(9)
+	picked := map[string]int{}
+	for i := 0; i < 300; i++ {
+		picked[b.Pick(req, endpoints).Addr]++
+	}
+	if picked["busiest"] != 0 || picked["a"] == 0 || picked["b"] == 0 {
+		t.Errorf("picks = %v", picked)
+	}
+}
+
+func TestRingHash(t *testing.T) {
+	b := RingHash(func(r *http.Request) string { return r.Header.Get("X-User") }, 0)
+	request := func(user string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", user)
+		return req
+	}
+
+	all := endpointsOf("a:1", "b:1", "c:1", "d:1")
+	before := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		user := "user" + strconv.Itoa(i)
+		addr := b.Pick(request(user), all).Addr
+		before[user] = addr
+		counts[addr]++
+		// the same key goes to the same endpoint, whatever the order of the endpoints
+		reversed := []*Endpoint{all[3], all[2], all[1], all[0]}
+		if again := b.Pick(request(user), reversed).Addr; again != addr {
+			t.Fatalf("%s moved from %s to %s", user, addr, again)
+		}
+	}
+	for _, e := range all {
+		if counts[e.Addr] < 100 {
+			t.Errorf("%s got %d of 1000 keys", e.Addr, counts[e.Addr])
+		}
+	}
+
+	// only the keys of the endpoint leaving move
+	remaining := []*Endpoint{all[0], all[1], all[3]}
+	for user, addr := range before {
+		now := b.Pick(request(user), remaining).Addr
+		if addr != "c:1" && now != addr {
+			t.Errorf("%s moved from %s to %s", user, addr, now)
+		}
+		if now == "c:1" {
+			t.Errorf("%s still goes to the removed endpoint", user)
+		}
+	}
+
+	// the URL path is the default key
+	b = RingHash(nil, 10)
+	first := b.Pick(httptest.NewRequest(http.MethodGet, "/items/1", nil), all).Addr
+	for i := 0; i < 10; i++ {
+		if addr := b.Pick(httptest.NewRequest(http.MethodGet, "/items/1?page="+strconv.Itoa(i), nil), all).Addr; addr != first {
+			t.Fatalf("the same path went to %s and %s", first, addr)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+// Package cassette records the outbound interactions of a client to a file and replays them in tests.
+// Cassettes are JSONL recordings in the mock package format, so they can be served by the mock command too.
+// Binary bodies are stored base64 encoded.
+package cassette
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/l00p8/xserver/mock"
+)
+
+// Mode tells whether a cassette replays or records interactions
+type Mode int
+
+const (
+	// Replay answers from the cassette and fails requests matching no interaction
+	Replay Mode = iota
+	// Record sends the requests and records them, Save writes the cassette
+	Record
+	// ReplayOrRecord replays an existing cassette and records a missing one
+	ReplayOrRecord
+)
+
+// Redacted replaces redacted values
+const Redacted = "REDACTED"
+
+// Matcher tells whether a request, as an interaction redacted like the recorded ones, matches a recorded interaction
+type Matcher func(req, recorded mock.Interaction) bool
+
+// MatchMethod compares the methods
+func MatchMethod(req, recorded mock.Interaction) bool {
+	return req.Method == recorded.Method
+}
+
+// MatchURL compares the URLs, query included
+func MatchURL(req, recorded mock.Interaction) bool {
+	return req.URL == recorded.URL
+}
+
+// MatchBody compares the request bodies, semantically when both are JSON
+func MatchBody(req, recorded mock.Interaction) bool {
+	if req.RequestBody == recorded.RequestBody {
+		return true
+	}
+	var a, b interface{}
+	if json.Unmarshal([]byte(req.RequestBody), &a) != nil || json.Unmarshal([]byte(recorded.RequestBody), &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Options configures a cassette
+type Options struct {
+	Mode Mode
+	// Matchers must all match, method and URL when empty
+	Matchers []Matcher
+	// RedactHeaders are replaced in the recorded requests and responses,
+	// Authorization, Cookie and Set-Cookie when nil
+	RedactHeaders []string
+	// RedactQuery parameters are replaced in the recorded URLs
+	RedactQuery []string
+	// Redact edits the interactions before they are recorded or matched, to hide secrets in bodies
+	Redact func(in *mock.Interaction)
+	// AllowRepeat replays the last matching interaction again once all of them were used,
+	// by default each recorded interaction answers a single request
+	AllowRepeat bool
+}
+
+// UnmatchedError is returned in replay when no recorded interaction matches a request
+type UnmatchedError struct {
+	Method string
+	URL    string
+}
+
+func (e *UnmatchedError) Error() string {
+	return fmt.Sprintf("cassette: no recorded interaction matches %s %s", e.Method, e.URL)
+}
+
+// Transport replays or records the interactions of a cassette file
+type Transport struct {
+	path string
+	opts Options
+	mode Mode
+	base http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []mock.Interaction
+	used         []bool
+}
+
+// New opens the cassette at path, a nil base stands for http.DefaultTransport
+func New(path string, opts Options, base http.RoundTripper) (*Transport, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if len(opts.Matchers) == 0 {
+		opts.Matchers = []Matcher{MatchMethod, MatchURL}
+	}
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+	}
+	t := &Transport{path: path, opts: opts, mode: opts.Mode, base: base}
+
+	if t.mode == Record {
+		return t, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && t.mode == ReplayOrRecord {
+		t.mode = Record
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t.interactions, err = mock.ReadRecording(f)
+	if err != nil {
+		return nil, err
+	}
+	t.mode = Replay
+	t.used = make([]bool, len(t.interactions))
+	return t, nil
+}
+
+// Recording reports whether the cassette records rather than replays
+func (t *Transport) Recording() bool {
+	return t.mode == Record
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out, in, err := t.request(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.mode == Replay {
+		t.redact(&in)
+		return t.replay(req, in)
+	}
+	return t.record(out, in)
+}
+
+// request captures the request as an interaction, the returned clone of the request
+// sends the body read from the original one
+func (t *Transport) request(req *http.Request) (*http.Request, mock.Interaction, error) {
+	in := mock.Interaction{Method: req.Method, URL: req.URL.String(), RequestHeader: req.Header.Clone()}
+	out := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, in, err
+		}
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		in.RequestBody = string(body)
+	}
+	return out, in, nil
+}
+
+// redact edits the interactions once, before they are matched or recorded
+func (t *Transport) redact(in *mock.Interaction) {
+	for _, h := range t.opts.RedactHeaders {
+		for _, header := range []http.Header{in.RequestHeader, in.Header} {
+			if _, ok := header[http.CanonicalHeaderKey(h)]; ok {
+				header.Set(h, Redacted)
+			}
+		}
+	}
+	if len(t.opts.RedactQuery) > 0 {
+		if i := strings.IndexByte(in.URL, '?'); i >= 0 {
+			u, q := in.URL[:i], in.URL[i+1:]
+			params := strings.Split(q, "&")
+			for j, p := range params {
+				name := p
+				if k := strings.IndexByte(p, '='); k >= 0 {
+					name = p[:k]
+				}
+				for _, r := range t.opts.RedactQuery {
+					if name == r {
+						params[j] = name + "=" + Redacted
+					}
+				}
+			}
+			in.URL = u + "?" + strings.Join(params, "&")
+		}
+	}
+	if t.opts.Redact != nil {
+		t.opts.Redact(in)
+	}
+}
+
+func (t *Transport) matches(req, recorded mock.Interaction) bool {
+	for _, m := range t.opts.Matchers {
+		if !m(req, recorded) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *Transport) replay(req *http.Request, in mock.Interaction) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last := -1
+	for i, recorded := range t.interactions {
+		if !t.matches(in, recorded) {
+			continue
+		}
+		last = i
+		if !t.used[i] {
+			t.used[i] = true
+			return response(req, recorded), nil
+		}
+	}
+	if last >= 0 && t.opts.AllowRepeat {
+		return response(req, t.interactions[last]), nil
+	}
+	return nil, &UnmatchedError{Method: req.Method, URL: in.URL}
+}
+
+func response(req *http.Request, in mock.Interaction) *http.Response {
+	header := in.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(in.Body)),
+		ContentLength: int64(len(in.Body)),
+		Request:       req,
+	}
+}
+
+func (t *Transport) record(req *http.Request, in mock.Interaction) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	in.Status = resp.StatusCode
+	in.Header = resp.Header.Clone()
+	in.Header.Del("Content-Length")
+	in.Body = string(body)
+	t.redact(&in)
+
+	t.mu.Lock()
+	t.interactions = append(t.interactions, in)
+	t.mu.Unlock()
+	return resp, nil
+}
+
+// Save writes the recorded interactions to the cassette file, it does nothing in replay
+func (t *Transport) Save() error {
+	if t.mode != Record {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := mock.WriteRecording(w, t.interactions); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// Unused returns the recorded interactions no request matched, to assert a replay is complete
+func (t *Transport) Unused() []mock.Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var res []mock.Interaction
+	for i, used := range t.used {
+		if !used {
+			res = append(res, t.interactions[i])
+		}
+	}
+	return res
+}
@@ -0,0 +1,133 @@
+package cassette
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/l00p8/xserver/mock"
+)
+
+func TestRecordReplayBinary(t *testing.T) {
+	payload := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00, 0xfe}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.Equal(body, payload) {
+			t.Errorf("server got body %v", body)
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(payload)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "binary.jsonl")
+	redactions := 0
+	opts := Options{
+		Mode:     Record,
+		Matchers: []Matcher{MatchMethod, MatchURL, MatchBody},
+		Redact: func(in *mock.Interaction) {
+			redactions++
+			in.RequestHeader.Set("X-Redacted", strings.Repeat("x", redactions))
+		},
+	}
+	rec, err := New(path, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/upload", bytes.NewReader(payload))
+	body := req.Body
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("recorded response body %v", got)
+	}
+	if req.Body != body {
+		t.Error("the body of the caller request was replaced")
+	}
+	if redactions != 1 {
+		t.Errorf("Redact called %d times, want 1", redactions)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"body_encoding":"base64"`) || !strings.Contains(string(data), `"request_body_encoding":"base64"`) {
+		t.Fatalf("binary bodies not base64 encoded: %s", data)
+	}
+	if !strings.Contains(string(data), `"X-Redacted":["x"]`) {
+		t.Errorf("redacted header not recorded: %s", data)
+	}
+
+	opts.Mode = Replay
+	redactions = 0
+	play, err := New(path, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest(http.MethodPost, srv.URL+"/upload", bytes.NewReader(payload))
+	resp, err = play.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = io.ReadAll(resp.Body)
+	if !bytes.Equal(got, payload) || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("replayed %v %v", resp.Header, got)
+	}
+	if len(play.Unused()) != 0 {
+		t.Error("the interaction was not used")
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, srv.URL+"/upload", strings.NewReader("other"))
+	if _, err := play.RoundTrip(req); err == nil {
+		t.Error("expected an unmatched error for another body")
+	}
+}
+
+func TestRedactHeadersAndQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("the server got a redacted request: %v", r.Header)
+		}
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "redact.jsonl")
+	rec, err := New(path, Options{Mode: Record, RedactQuery: []string{"key"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/items?key=secret&page=2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("secret recorded: %s", data)
+	}
+
+	play, err := New(path, Options{Mode: Replay, RedactQuery: []string{"key"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/items?key=other&page=2", nil)
+	if _, err := play.RoundTrip(req); err != nil {
+		t.Errorf("the redacted request did not match: %v", err)
+	}
+}
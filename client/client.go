@@ -0,0 +1,23 @@
+// Package client sends outbound HTTP requests to replicated services without a central load balancer,
+// endpoints come from a Resolver and are picked by a Balancer in the Transport.
+package client
+
+import (
+	"net/http"
+	"time"
+)
+
+// Middleware decorates a transport the way server middlewares decorate handlers
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// New returns a client sending requests through the transport decorated by the middlewares,
+// the first middleware is the outermost one. A nil transport stands for http.DefaultTransport.
+func New(timeout time.Duration, transport http.RoundTripper, middlewares ...Middleware) *http.Client {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var errNoRecords = errors.New("client: no DNS records")
+
+// dnsConfig is the part of resolv.conf needed to query the records with their TTL
+type dnsConfig struct {
+	servers []string
+	search  []string
+	ndots   int
+}
+
+func readDNSConfig(path string) (dnsConfig, error) {
+	cfg := dnsConfig{ndots: 1}
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			cfg.servers = append(cfg.servers, net.JoinHostPort(fields[1], "53"))
+		case "search", "domain":
+			cfg.search = fields[1:]
+		case "options":
+			for _, opt := range fields[1:] {
+				if v, ok := strings.CutPrefix(opt, "ndots:"); ok {
+					if n, err := strconv.Atoi(v); err == nil {
+						cfg.ndots = n
+					}
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return cfg, err
+	}
+	if len(cfg.servers) == 0 {
+		return cfg, errors.New("client: no nameserver in " + path)
+	}
+	return cfg, nil
+}
+
+// names returns the fully qualified names to query for host, in the order of the system resolver
+func (c dnsConfig) names(host string) []string {
+	if strings.HasSuffix(host, ".") {
+		return []string{host}
+	}
+	var names []string
+	for _, s := range c.search {
+		names = append(names, host+"."+strings.TrimSuffix(s, ".")+".")
+	}
+	if strings.Count(host, ".") >= c.ndots {
+		return append([]string{host + "."}, names...)
+	}
+	return append(names, host+".")
+}
+
+// lookup returns the addresses of host with the smallest TTL of their records
+func (c dnsConfig) lookup(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, 0, nil
+	}
+	return c.resolve(ctx, host, dnsmessage.TypeA, dnsmessage.TypeAAAA)
+}
+
+// lookupSRV returns the target:port of the SRV records of _service._proto.name with their
+// smallest TTL, name is looked up directly when service and proto are empty like net.LookupSRV
+func (c dnsConfig) lookupSRV(ctx context.Context, service, proto, name string) ([]string, time.Duration, error) {
+	if service != "" || proto != "" {
+		name = "_" + service + "._" + proto + "." + name
+	}
+	return c.resolve(ctx, name, dnsmessage.TypeSRV)
+}
+
+// resolve queries the records of host for each name of the search list until one has some
+func (c dnsConfig) resolve(ctx context.Context, host string, qtypes ...dnsmessage.Type) ([]string, time.Duration, error) {
+	if len(c.servers) == 0 {
+		return nil, 0, errNoRecords
+	}
+
+	var lastErr error = errNoRecords
+	for _, name := range c.names(host) {
+		qname, err := dnsmessage.NewName(name)
+		if err != nil {
+			return nil, 0, err
+		}
+		var addrs []string
+		ttl := uint32(math.MaxUint32)
+		for _, qtype := range qtypes {
+			res, resTTL, err := c.query(ctx, qname, qtype)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if len(res) > 0 {
+				addrs = append(addrs, res...)
+				ttl = min(ttl, resTTL)
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, time.Duration(ttl) * time.Second, nil
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// query asks the nameservers in turn until one of them answers
+func (c dnsConfig) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]string, uint32, error) {
+	var err error
+	for _, server := range c.servers {
+		var ips []string
+		var ttl uint32
+		if ips, ttl, err = exchange(ctx, server, name, qtype); err == nil {
+			return ips, ttl, nil
+		}
+	}
+	return nil, 0, err
+}
+
+// exchange sends a query over UDP, the TTL is the smallest one of the answer
+// including the CNAME records leading to the records
+func exchange(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) ([]string, uint32, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	id := uint16(rand.Uint32())
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	b, err := q.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := conn.Write(b); err != nil {
+		return nil, 0, err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.ID != id || !h.Response {
+			// not the answer to this query
+			continue
+		}
+		if h.Truncated {
+			return nil, 0, errors.New("client: truncated DNS answer")
+		}
+		if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+			return nil, 0, errors.New("client: DNS answer " + h.RCode.String())
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return nil, 0, err
+		}
+		return answers(&p)
+	}
+}
+
+func answers(p *dnsmessage.Parser) ([]string, uint32, error) {
+	var ips []string
+	ttl := uint32(math.MaxUint32)
+	for {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return ips, ttl, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		switch h.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(r.A[:]).String())
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.IP(r.AAAA[:]).String())
+		case dnsmessage.TypeSRV:
+			r, err := p.SRVResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ips = append(ips, net.JoinHostPort(strings.TrimSuffix(r.Target.String(), "."), strconv.Itoa(int(r.Port))))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			if h.Type != dnsmessage.TypeCNAME {
+				continue
+			}
+		}
+		ttl = min(ttl, h.TTL)
+	}
+}
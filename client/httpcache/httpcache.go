@@ -0,0 +1,582 @@
+// Package httpcache is a client transport caching responses the way RFC 9111 describes,
+// with revalidation through ETag and Last-Modified and stale-if-error (RFC 5861).
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/l00p8/xserver/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Cache results counted by the metrics and reported in the Cache-Status response header
+const (
+	Hit         = "hit"
+	Miss        = "miss"
+	Revalidated = "revalidated"
+	Stale       = "stale"
+	Bypass      = "bypass"
+)
+
+var cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "http_client_cache_requests_total",
+	Help: "Number of outbound requests by cache result.",
+}, []string{"host", "result"})
+
+// Options configures the cache
+type Options struct {
+	// Shared makes the cache behave as a shared cache: private responses are not stored
+	// and s-maxage applies. A cache used on behalf of a single service is private.
+	Shared bool
+	// StaleIfError serves stale responses for that long past their freshness when the origin fails,
+	// responses and requests carrying the stale-if-error directive override it
+	StaleIfError time.Duration
+	// MaxEntryBytes is the largest body stored, 10MB when zero
+	MaxEntryBytes int64
+	// MaxVariants bounds the responses stored per URL for the values of their Vary headers,
+	// the oldest one is dropped past it, 16 when zero
+	MaxVariants int
+	// MaxHosts bounds the hosts used as metric labels, the hosts seen past it
+	// are counted as "other", 100 when zero
+	MaxHosts int
+}
+
+// Transport answers from the store when it can and stores the cacheable responses,
+// only GET requests are cached and unsafe requests invalidate their URL
+type Transport struct {
+	store Store
+	opts  Options
+	base  http.RoundTripper
+
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+// New creates a caching transport, a nil base stands for http.DefaultTransport
+func New(store Store, opts Options, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if opts.MaxEntryBytes <= 0 {
+		opts.MaxEntryBytes = 10 << 20
+	}
+	if opts.MaxVariants <= 0 {
+		opts.MaxVariants = 16
+	}
+	if opts.MaxHosts <= 0 {
+		opts.MaxHosts = 100
+	}
+	return &Transport{store: store, opts: opts, base: base, hosts: make(map[string]bool)}
+}
+
+// Middleware adds the cache to a client
+func Middleware(store Store, opts Options) client.Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return New(store, opts, next)
+	}
+}
+
+// entry is a stored response
+type entry struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	RequestTime  time.Time   `json:"request_time"`
+	ResponseTime time.Time   `json:"response_time"`
+}
+
+// variants is stored under the key of a URL, it lists the Vary headers of its
+// responses and the keys they are stored under, oldest first
+type variants struct {
+	Vary []string `json:"vary"`
+	Keys []string `json:"keys"`
+}
+
+func cacheKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+
+// variantKey is the key of the response to req selected by the vary headers
+func variantKey(key string, vary []string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteString("\n" + name + ":")
+		for i, v := range req.Header.Values(name) {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(strings.TrimSpace(v))
+		}
+	}
+	return b.String()
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		if isUnsafe(req.Method) {
+			return t.invalidate(req)
+		}
+		return t.forward(req, Bypass)
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return t.forward(req, Bypass)
+	}
+
+	key := cacheKey(req)
+	e, variant := t.lookup(key, req)
+	if e == nil {
+		if _, ok := reqCC["only-if-cached"]; ok {
+			return t.respond(req, &entry{Status: http.StatusGatewayTimeout, Header: http.Header{}}, Miss), nil
+		}
+		return t.fetch(req, key, Miss)
+	}
+
+	now := time.Now()
+	age := e.age(now)
+	lifetime := e.lifetime(t.opts.Shared)
+	resCC := parseCacheControl(e.Header)
+	if t.usable(reqCC, resCC, age, lifetime) {
+		return t.respond(req, e, Hit), nil
+	}
+	if _, ok := reqCC["only-if-cached"]; ok {
+		return t.respond(req, &entry{Status: http.StatusGatewayTimeout, Header: http.Header{}}, Miss), nil
+	}
+	return t.revalidate(req, key, variant, e, reqCC, resCC, age-lifetime)
+}
+
+// usable reports whether a stored response can be served without contacting the origin
+func (t *Transport) usable(reqCC, resCC cacheControl, age, lifetime time.Duration) bool {
+	if _, ok := resCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+	if maxAge, ok := reqCC.duration("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.duration("min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	if mustRevalidate(resCC, t.opts.Shared) {
+		return false
+	}
+	if v, ok := reqCC["max-stale"]; ok {
+		if v == "" {
+			return true
+		}
+		maxStale, _ := reqCC.duration("max-stale")
+		return age-lifetime <= maxStale
+	}
+	return false
+}
+
+func mustRevalidate(cc cacheControl, shared bool) bool {
+	if _, ok := cc["must-revalidate"]; ok {
+		return true
+	}
+	_, ok := cc["proxy-revalidate"]
+	return ok && shared
+}
+
+func (t *Transport) variants(key string) *variants {
+	data, ok := t.store.Get(key)
+	if !ok {
+		return nil
+	}
+	var v variants
+	if json.Unmarshal(data, &v) != nil {
+		t.store.Delete(key)
+		return nil
+	}
+	return &v
+}
+
+// lookup returns the stored response selected by the request headers and its key
+func (t *Transport) lookup(key string, req *http.Request) (*entry, string) {
+	v := t.variants(key)
+	if v == nil {
+		return nil, ""
+	}
+	variant := variantKey(key, v.Vary, req)
+	data, ok := t.store.Get(variant)
+	if !ok {
+		return nil, ""
+	}
+	var e entry
+	if json.Unmarshal(data, &e) != nil {
+		t.store.Delete(variant)
+		return nil, ""
+	}
+	return &e, variant
+}
+
+func (t *Transport) revalidate(req *http.Request, key, variant string, e *entry, reqCC, resCC cacheControl, staleness time.Duration) (*http.Response, error) {
+	cond := req.Clone(req.Context())
+	if etag := e.Header.Get("ETag"); etag != "" {
+		cond.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		cond.Header.Set("If-Modified-Since", lm)
+	}
+
+	requestTime := time.Now()
+	resp, err := t.base.RoundTrip(cond)
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		if t.staleIfError(reqCC, resCC, staleness) {
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+			return t.respond(req, e, Stale), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		return t.keep(req, key, resp, requestTime, Miss)
+	}
+
+	// 304: the stored response is fresh again with the updated headers
+	_ = resp.Body.Close()
+	for k, v := range resp.Header {
+		if k == "Content-Length" {
+			continue
+		}
+		e.Header[k] = v
+	}
+	e.RequestTime, e.ResponseTime = requestTime, time.Now()
+	t.save(variant, e)
+	return t.respond(req, e, Revalidated), nil
+}
+
+func (t *Transport) staleIfError(reqCC, resCC cacheControl, staleness time.Duration) bool {
+	if mustRevalidate(resCC, t.opts.Shared) {
+		return false
+	}
+	window := t.opts.StaleIfError
+	if d, ok := resCC.duration("stale-if-error"); ok {
+		window = d
+	}
+	if d, ok := reqCC.duration("stale-if-error"); ok {
+		window = d
+	}
+	return staleness <= window
+}
+
+func (t *Transport) fetch(req *http.Request, key, result string) (*http.Response, error) {
+	requestTime := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	return t.keep(req, key, resp, requestTime, result)
+}
+
+// keep stores the response when it is cacheable and returns it to the caller
+func (t *Transport) keep(req *http.Request, key string, resp *http.Response, requestTime time.Time, result string) (*http.Response, error) {
+	t.count(req, result)
+	resp.Header.Set("Cache-Status", "xserver; "+cacheStatus(result))
+	if !t.cacheable(req, resp) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, t.opts.MaxEntryBytes+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > t.opts.MaxEntryBytes {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := resp.Header.Clone()
+	header.Del("Cache-Status")
+	e := &entry{
+		Status:       resp.StatusCode,
+		Header:       header,
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: time.Now(),
+	}
+	t.saveVariant(key, varyHeaders(resp.Header), req, e)
+	return resp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (t *Transport) save(key string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	t.store.Set(key, data)
+}
+
+// saveVariant stores the response under the key selected by its vary headers and
+// records that key under the key of the URL. The stored responses of the URL are
+// dropped when its vary headers change.
+func (t *Transport) saveVariant(key string, vary []string, req *http.Request, e *entry) {
+	sort.Strings(vary)
+	v := t.variants(key)
+	if v == nil || strings.Join(v.Vary, ",") != strings.Join(vary, ",") {
+		if v != nil {
+			for _, k := range v.Keys {
+				t.store.Delete(k)
+			}
+		}
+		v = &variants{Vary: vary}
+	}
+
+	variant := variantKey(key, vary, req)
+	for i, k := range v.Keys {
+		if k == variant {
+			v.Keys = append(v.Keys[:i], v.Keys[i+1:]...)
+			break
+		}
+	}
+	v.Keys = append(v.Keys, variant)
+	for len(v.Keys) > t.opts.MaxVariants {
+		t.store.Delete(v.Keys[0])
+		v.Keys = v.Keys[1:]
+	}
+	t.save(variant, e)
+	t.save(key, v)
+}
+
+// heuristicStatuses may be cached without explicit freshness
+var heuristicStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+func (t *Transport) cacheable(req *http.Request, resp *http.Response) bool {
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["no-store"]; ok {
+		return false
+	}
+	_, public := cc["public"]
+	if _, ok := cc["private"]; ok && t.opts.Shared {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" && t.opts.Shared {
+		_, sMaxAge := cc["s-maxage"]
+		if !public && !sMaxAge && !mustRevalidate(cc, true) {
+			return false
+		}
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	_, maxAge := cc["max-age"]
+	_, sMaxAge := cc["s-maxage"]
+	explicit := maxAge || (sMaxAge && t.opts.Shared) || resp.Header.Get("Expires") != "" || public
+	validator := resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+	return (heuristicStatuses[resp.StatusCode] && (explicit || validator)) || (explicit && resp.StatusCode < 400)
+}
+
+// invalidate forwards an unsafe request and drops the stored response of its URL when it succeeds
+func (t *Transport) invalidate(req *http.Request) (*http.Response, error) {
+	resp, err := t.forward(req, Bypass)
+	if err == nil && resp.StatusCode < 400 {
+		get := req.Clone(req.Context())
+		get.Method = http.MethodGet
+		key := cacheKey(get)
+		if v := t.variants(key); v != nil {
+			for _, k := range v.Keys {
+				t.store.Delete(k)
+			}
+		}
+		t.store.Delete(key)
+	}
+	return resp, err
+}
+
+func (t *Transport) forward(req *http.Request, result string) (*http.Response, error) {
+	t.count(req, result)
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		resp.Header.Set("Cache-Status", "xserver; "+cacheStatus(result))
+	}
+	return resp, err
+}
+
+// respond builds the response of a stored entry
+func (t *Transport) respond(req *http.Request, e *entry, result string) *http.Response {
+	if result != Miss {
+		t.count(req, result)
+	}
+	header := e.Header.Clone()
+	if !e.ResponseTime.IsZero() {
+		header.Set("Age", strconv.FormatInt(int64(e.age(time.Now())/time.Second), 10))
+	}
+	header.Set("Cache-Status", "xserver; "+cacheStatus(result))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// cacheStatus renders a result as RFC 9211 Cache-Status parameters
+func cacheStatus(result string) string {
+	switch result {
+	case Hit:
+		return "hit"
+	case Stale:
+		return "hit; fwd=stale; detail=stale-if-error"
+	case Revalidated:
+		return "fwd=stale; fwd-status=304"
+	case Bypass:
+		return "fwd=bypass"
+	default:
+		return "fwd=miss"
+	}
+}
+
+func (t *Transport) count(req *http.Request, result string) {
+	cacheRequests.WithLabelValues(t.label(req.URL.Host), result).Inc()
+}
+
+// label bounds the hosts used as metric labels
+func (t *Transport) label(host string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hosts[host] {
+		return host
+	}
+	if len(t.hosts) >= t.opts.MaxHosts {
+		return "other"
+	}
+	t.hosts[host] = true
+	return host
+}
+
+// age is the current age of the entry (RFC 9111 section 4.2.3)
+func (e *entry) age(now time.Time) time.Duration {
+	date := e.ResponseTime
+	if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		date = d
+	}
+	apparent := e.ResponseTime.Sub(date)
+	if apparent < 0 {
+		apparent = 0
+	}
+	ageValue, _ := strconv.ParseInt(e.Header.Get("Age"), 10, 64)
+	corrected := time.Duration(ageValue)*time.Second + e.ResponseTime.Sub(e.RequestTime)
+	if corrected > apparent {
+		apparent = corrected
+	}
+	return apparent + now.Sub(e.ResponseTime)
+}
+
+// lifetime is the freshness lifetime of the entry (RFC 9111 section 4.2.1)
+func (e *entry) lifetime(shared bool) time.Duration {
+	cc := parseCacheControl(e.Header)
+	if shared {
+		if d, ok := cc.duration("s-maxage"); ok {
+			return d
+		}
+	}
+	if d, ok := cc.duration("max-age"); ok {
+		return d
+	}
+	date := e.ResponseTime
+	if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		date = d
+	}
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicStatuses[e.Status] && date.After(lm) {
+		return date.Sub(lm) / 10
+	}
+	return 0
+}
+
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+func varyHeaders(h http.Header) []string {
+	var res []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				res = append(res, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return res
+}
+
+// cacheControl maps the directives of Cache-Control headers to their values
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(d, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	if _, ok := cc["no-cache"]; !ok && strings.EqualFold(h.Get("Pragma"), "no-cache") && len(h.Values("Cache-Control")) == 0 {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func (cc cacheControl) duration(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || secs < 0 {
+		return 0, true
+	}
+	return time.Duration(secs) * time.Second, true
+}
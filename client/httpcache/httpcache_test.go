@@ -0,0 +1,126 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func get(t *testing.T, c *http.Client, url string, header http.Header) (string, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), resp.Header.Get("Cache-Status")
+}
+
+func TestVaryVariants(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = io.WriteString(w, "lang="+r.Header.Get("Accept-Language"))
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: New(NewMemoryStore(1<<20), Options{}, nil)}
+	en := http.Header{"Accept-Language": {"en"}}
+	fr := http.Header{"Accept-Language": {"fr"}}
+
+	steps := []struct {
+		header http.Header
+		body   string
+		status string
+	}{
+		{en, "lang=en", "xserver; fwd=miss"},
+		{fr, "lang=fr", "xserver; fwd=miss"},
+		// both variants are kept side by side
+		{en, "lang=en", "xserver; hit"},
+		{fr, "lang=fr", "xserver; hit"},
+		{nil, "lang=", "xserver; fwd=miss"},
+	}
+	for i, step := range steps {
+		body, status := get(t, c, srv.URL, step.header)
+		if body != step.body || status != step.status {
+			t.Errorf("step %d: %q %q, want %q %q", i, body, status, step.body, step.status)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("origin requests = %d, want 3", n)
+	}
+}
+
+func TestMaxVariants(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "X-Tenant")
+		_, _ = io.WriteString(w, r.Header.Get("X-Tenant"))
+	}))
+	defer srv.Close()
+
+	c := &http.Client{Transport: New(NewMemoryStore(1<<20), Options{MaxVariants: 2}, nil)}
+	for _, tenant := range []string{"a", "b", "c", "c", "b", "a"} {
+		get(t, c, srv.URL, http.Header{"X-Tenant": {tenant}})
+	}
+	// a was dropped when c was stored, the others stayed
+	if n := requests.Load(); n != 4 {
+		t.Errorf("origin requests = %d, want 4", n)
+	}
+}
+
+func TestInvalidateVariants(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "X-Tenant")
+	}))
+	defer srv.Close()
+
+	store := NewMemoryStore(1 << 20)
+	c := &http.Client{Transport: New(store, Options{}, nil)}
+	get(t, c, srv.URL, http.Header{"X-Tenant": {"a"}})
+	get(t, c, srv.URL, http.Header{"X-Tenant": {"b"}})
+	resp, err := c.Post(srv.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := store.lru.Len(); n != 0 {
+		t.Errorf("stored entries after invalidation = %d, want 0", n)
+	}
+}
+
+func TestHostLabelBounded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tr := New(NewMemoryStore(1<<20), Options{MaxHosts: 2}, nil)
+	for i := 0; i < 5; i++ {
+		if got := tr.label("host" + strconv.Itoa(i)); i >= 2 && got != "other" {
+			t.Errorf("label of host%d = %q, want other", i, got)
+		}
+	}
+	if got := tr.label("host0"); got != "host0" {
+		t.Errorf("label of a known host = %q", got)
+	}
+
+	before := testutil.ToFloat64(cacheRequests.WithLabelValues("other", Miss))
+	get(t, &http.Client{Transport: tr}, srv.URL, nil)
+	if got := testutil.ToFloat64(cacheRequests.WithLabelValues("other", Miss)); got != before+1 {
+		t.Errorf("other misses = %v, want %v", got, before+1)
+	}
+}
@@ -0,0 +1,131 @@
+package httpcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps the serialized cache entries by key
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+type memoryEntry struct {
+	key   string
+	value []byte
+}
+
+// MemoryStore is an in-memory store evicting the least recently used entries past its size
+type MemoryStore struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryStore creates a store holding up to maxBytes of entries
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*memoryEntry).value, true
+}
+
+func (s *MemoryStore) Set(key string, value []byte) {
+	if int64(len(value)) > s.maxBytes {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	s.entries[key] = s.lru.PushFront(&memoryEntry{key: key, value: value})
+	s.size += int64(len(value))
+	for s.size > s.maxBytes {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*memoryEntry)
+	delete(s.entries, e.key)
+	s.size -= int64(len(e.value))
+}
+
+// DiskStore keeps an entry per file in a directory, it survives restarts
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore creates the directory of the store when missing
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *DiskStore) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func (s *DiskStore) Set(key string, value []byte) {
+	path := s.path(key)
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return
+	}
+	if os.Rename(f.Name(), path) != nil {
+		_ = os.Remove(f.Name())
+	}
+}
+
+func (s *DiskStore) Delete(key string) {
+	_ = os.Remove(s.path(key))
+}
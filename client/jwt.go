@@ -0,0 +1,103 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// JWTConfig describes the assertions of the JWT bearer grant
+type JWTConfig struct {
+	// Issuer of the assertion, usually the client id
+	Issuer string
+	// Subject the token is requested for, the Issuer when empty
+	Subject string
+	// Audience of the assertion, the token URL when empty
+	Audience string
+	// Key signs the assertion, an RSA key signs with RS256 and a P-256 key with ES256
+	Key crypto.Signer
+	// KeyID is sent as the kid header when set
+	KeyID string
+	// Lifetime of the assertion
+	Lifetime time.Duration
+	// Claims are added to the assertion
+	Claims map[string]interface{}
+}
+
+func (c JWTConfig) assertion() (string, error) {
+	alg := ""
+	switch k := c.Key.Public().(type) {
+	case *rsa.PublicKey:
+		alg = "RS256"
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().BitSize != 256 {
+			return "", errors.New("jwt: only P-256 ecdsa keys are supported")
+		}
+		alg = "ES256"
+	default:
+		return "", errors.New("jwt: unsupported key type")
+	}
+
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if c.KeyID != "" {
+		header["kid"] = c.KeyID
+	}
+	lifetime := c.Lifetime
+	if lifetime <= 0 {
+		lifetime = 5 * time.Minute
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := map[string]interface{}{}
+	for k, v := range c.Claims {
+		claims[k] = v
+	}
+	claims["iss"] = c.Issuer
+	claims["sub"] = c.Subject
+	if c.Subject == "" {
+		claims["sub"] = c.Issuer
+	}
+	claims["aud"] = c.Audience
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(lifetime).Unix()
+	claims["jti"] = hex.EncodeToString(jti)
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := c.Key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if alg == "ES256" {
+		// JWS wants r and s concatenated rather than ASN.1
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return "", err
+		}
+		sig = make([]byte, 64)
+		rs.R.FillBytes(sig[:32])
+		rs.S.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is an access token issued by a token endpoint
+type Token struct {
+	AccessToken string
+	TokenType   string
+	// Expiry is zero when the endpoint did not tell
+	Expiry time.Time
+}
+
+// TokenSource provides the tokens of outbound requests
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// OAuth2Config describes a client of a token endpoint
+type OAuth2Config struct {
+	TokenURL     string   `envconfig:"oauth2_token_url" mapstructure:"oauth2_token_url" default:""`
+	ClientID     string   `envconfig:"oauth2_client_id" mapstructure:"oauth2_client_id" default:""`
+	ClientSecret string   `envconfig:"oauth2_client_secret" mapstructure:"oauth2_client_secret" default:"" secret:"true"`
+	Scopes       []string `envconfig:"oauth2_scopes" mapstructure:"oauth2_scopes" default:""`
+	// Audience is sent as the audience parameter when set
+	Audience string `envconfig:"oauth2_audience" mapstructure:"oauth2_audience" default:""`
+	// AuthInBody sends the client credentials as form parameters instead of basic auth
+	AuthInBody bool `envconfig:"oauth2_auth_in_body" mapstructure:"oauth2_auth_in_body" default:"false"`
+	// RefreshBefore is how long before expiry a token is refreshed in the background
+	RefreshBefore time.Duration `envconfig:"oauth2_refresh_before" mapstructure:"oauth2_refresh_before" default:"1m"`
+	// Timeout of a token request
+	Timeout time.Duration `envconfig:"oauth2_timeout" mapstructure:"oauth2_timeout" default:"10s"`
+	// HTTPClient sends the token requests, http.DefaultClient when nil
+	HTTPClient *http.Client `ignored:"true"`
+}
+
+// TokenError is an error answered by the token endpoint
+type TokenError struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth2: %s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("oauth2: token endpoint answered %d %s", e.Status, e.Code)
+}
+
+// ClientCredentials returns a cached token source using the client credentials grant
+func ClientCredentials(cfg OAuth2Config) TokenSource {
+	return newCachedSource(cfg, func(context.Context) (url.Values, error) {
+		return url.Values{"grant_type": {"client_credentials"}}, nil
+	})
+}
+
+// JWTBearer returns a cached token source using the JWT bearer grant (RFC 7523),
+// the assertion is signed by the JWTConfig key on every token request
+func JWTBearer(cfg OAuth2Config, jwt JWTConfig) TokenSource {
+	if jwt.Audience == "" {
+		jwt.Audience = cfg.TokenURL
+	}
+	return newCachedSource(cfg, func(context.Context) (url.Values, error) {
+		assertion, err := jwt.assertion()
+		if err != nil {
+			return nil, err
+		}
+		return url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}, nil
+	})
+}
+
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// cachedSource keeps the token until it expires, refreshes it proactively
+// and shares a single token request between concurrent callers
+type cachedSource struct {
+	cfg   OAuth2Config
+	grant func(ctx context.Context) (url.Values, error)
+
+	mu    sync.Mutex
+	token *Token
+	call  *tokenCall
+}
+
+func newCachedSource(cfg OAuth2Config, grant func(ctx context.Context) (url.Values, error)) *cachedSource {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.RefreshBefore <= 0 {
+		cfg.RefreshBefore = time.Minute
+	}
+	return &cachedSource{cfg: cfg, grant: grant}
+}
+
+func (s *cachedSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	now := time.Now()
+	if tok := s.token; tok != nil && (tok.Expiry.IsZero() || now.Before(tok.Expiry)) {
+		if !tok.Expiry.IsZero() && now.Add(s.cfg.RefreshBefore).After(tok.Expiry) && s.call == nil {
+			s.refresh()
+		}
+		s.mu.Unlock()
+		return tok, nil
+	}
+	c := s.call
+	if c == nil {
+		c = s.refresh()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.token, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh starts a token request, detached from the callers so one giving up does not fail the others.
+// The lock must be held.
+func (s *cachedSource) refresh() *tokenCall {
+	c := &tokenCall{done: make(chan struct{})}
+	s.call = c
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+		defer cancel()
+		c.token, c.err = s.fetch(ctx)
+
+		s.mu.Lock()
+		if c.err == nil {
+			s.token = c.token
+		}
+		s.call = nil
+		s.mu.Unlock()
+		close(c.done)
+	}()
+	return c
+}
+
+// Invalidate drops the cached token, the next call requests a new one
+func (s *cachedSource) Invalidate() {
+	s.mu.Lock()
+	s.token = nil
+	s.mu.Unlock()
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (s *cachedSource) fetch(ctx context.Context) (*Token, error) {
+	form, err := s.grant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+	if s.cfg.Audience != "" {
+		form.Set("audience", s.cfg.Audience)
+	}
+	if s.cfg.AuthInBody {
+		form.Set("client_id", s.cfg.ClientID)
+		if s.cfg.ClientSecret != "" {
+			form.Set("client_secret", s.cfg.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !s.cfg.AuthInBody && s.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	}
+
+	resp, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var res tokenResponse
+	jsonErr := json.Unmarshal(body, &res)
+	if resp.StatusCode != http.StatusOK || res.Error != "" {
+		return nil, &TokenError{Status: resp.StatusCode, Code: res.Error, Description: res.ErrorDescription}
+	}
+	if jsonErr != nil {
+		return nil, fmt.Errorf("oauth2: invalid token response: %w", jsonErr)
+	}
+	if res.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2: token response without access_token")
+	}
+
+	tok := &Token{AccessToken: res.AccessToken, TokenType: res.TokenType}
+	if tok.TokenType == "" {
+		tok.TokenType = "Bearer"
+	}
+	if res.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// Bearer authorizes the requests with the tokens of the source,
+// a 401 answer drops the cached token so the next request gets a fresh one
+func Bearer(src TokenSource) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			tok, err := src.Token(req.Context())
+			if err != nil {
+				return nil, err
+			}
+			out := req.Clone(req.Context())
+			out.Header.Set("Authorization", tok.TokenType+" "+tok.AccessToken)
+			resp, err := next.RoundTrip(out)
+			if err == nil && resp.StatusCode == http.StatusUnauthorized {
+				if inv, ok := src.(interface{ Invalidate() }); ok {
+					inv.Invalidate()
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
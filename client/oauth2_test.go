@@ -0,0 +1,240 @@
+package client_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/l00p8/xserver/client"
+	"github.com/l00p8/xserver/client/oauth2test"
+)
+
+func newTokenServer(t *testing.T) *oauth2test.Server {
+	srv := oauth2test.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddClient("svc", "s3cr:t")
+	return srv
+}
+
+func TestClientCredentialsCaching(t *testing.T) {
+	srv := newTokenServer(t)
+	src := client.ClientCredentials(client.OAuth2Config{TokenURL: srv.TokenURL(), ClientID: "svc", ClientSecret: "s3cr:t"})
+
+	first, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !srv.Valid(first.AccessToken) || first.TokenType != "Bearer" {
+		t.Fatalf("unexpected token %+v", first)
+	}
+	second, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.AccessToken != first.AccessToken {
+		t.Error("the cached token was not reused")
+	}
+	if n := srv.Requests(); n != 1 {
+		t.Errorf("token requests = %d, want 1", n)
+	}
+}
+
+func TestClientCredentialsProactiveRefresh(t *testing.T) {
+	srv := newTokenServer(t)
+	srv.TTL = 2 * time.Second
+	src := client.ClientCredentials(client.OAuth2Config{
+		TokenURL:      srv.TokenURL(),
+		ClientID:      "svc",
+		ClientSecret:  "s3cr:t",
+		AuthInBody:    true,
+		RefreshBefore: 1900 * time.Millisecond,
+	})
+
+	first, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// within RefreshBefore of the expiry the cached token is returned and a new one requested
+	cached, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.AccessToken != first.AccessToken {
+		t.Error("the token was not returned while being refreshed")
+	}
+	deadline := time.Now().Add(time.Second)
+	for srv.Requests() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := srv.Requests(); n != 2 {
+		t.Fatalf("token requests = %d, want 2", n)
+	}
+	var refreshed *client.Token
+	for time.Now().Before(deadline) {
+		if refreshed, err = src.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if refreshed.AccessToken != first.AccessToken {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if refreshed.AccessToken == first.AccessToken || !srv.Valid(refreshed.AccessToken) {
+		t.Error("the refreshed token is not used")
+	}
+}
+
+func TestClientCredentialsSingleFlight(t *testing.T) {
+	srv := newTokenServer(t)
+	srv.Delay = 100 * time.Millisecond
+	src := client.ClientCredentials(client.OAuth2Config{TokenURL: srv.TokenURL(), ClientID: "svc", ClientSecret: "s3cr:t"})
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	errs := make([]error, len(tokens))
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tok, err := src.Token(context.Background())
+			if err == nil {
+				tokens[i] = tok.AccessToken
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range tokens {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if tokens[i] != tokens[0] {
+			t.Fatal("the callers got different tokens")
+		}
+	}
+	if n := srv.Requests(); n != 1 {
+		t.Errorf("token requests = %d, want 1", n)
+	}
+}
+
+func TestClientCredentialsCallerGivingUp(t *testing.T) {
+	srv := newTokenServer(t)
+	srv.Delay = 100 * time.Millisecond
+	src := client.ClientCredentials(client.OAuth2Config{TokenURL: srv.TokenURL(), ClientID: "svc", ClientSecret: "s3cr:t"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := src.Token(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the deadline of the caller", err)
+	}
+	// the request started for the first caller is shared with the next one
+	if _, err := src.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := srv.Requests(); n != 1 {
+		t.Errorf("token requests = %d, want 1", n)
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	srv := newTokenServer(t)
+
+	tests := []struct {
+		name   string
+		cfg    client.OAuth2Config
+		fail   int
+		status int
+		code   string
+	}{
+		{"wrong secret", client.OAuth2Config{ClientID: "svc", ClientSecret: "wrong"}, 0, http.StatusUnauthorized, "invalid_client"},
+		{"unknown client", client.OAuth2Config{ClientID: "other", AuthInBody: true}, 0, http.StatusUnauthorized, "invalid_client"},
+		{"server error", client.OAuth2Config{ClientID: "svc", ClientSecret: "s3cr:t"}, 1, http.StatusInternalServerError, "server_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.FailNext(tt.fail)
+			tt.cfg.TokenURL = srv.TokenURL()
+			_, err := client.ClientCredentials(tt.cfg).Token(context.Background())
+			var tokErr *client.TokenError
+			if !errors.As(err, &tokErr) {
+				t.Fatalf("err = %v, want a TokenError", err)
+			}
+			if tokErr.Status != tt.status || tokErr.Code != tt.code {
+				t.Errorf("error = %d %s, want %d %s", tokErr.Status, tokErr.Code, tt.status, tt.code)
+			}
+		})
+	}
+
+	// a failed request is not cached
+	src := client.ClientCredentials(client.OAuth2Config{TokenURL: srv.TokenURL(), ClientID: "svc", ClientSecret: "s3cr:t"})
+	srv.FailNext(1)
+	if _, err := src.Token(context.Background()); err == nil {
+		t.Fatal("expected the requested failure")
+	}
+	if _, err := src.Token(context.Background()); err != nil {
+		t.Fatalf("the token was not requested again: %v", err)
+	}
+}
+
+func TestJWTBearer(t *testing.T) {
+	srv := newTokenServer(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.AddIssuer("svc", &key.PublicKey)
+
+	tok, err := client.JWTBearer(client.OAuth2Config{TokenURL: srv.TokenURL()}, client.JWTConfig{Issuer: "svc", Key: key}).Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !srv.Valid(tok.AccessToken) {
+		t.Error("the token was not issued by the server")
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err = client.JWTBearer(client.OAuth2Config{TokenURL: srv.TokenURL()}, client.JWTConfig{Issuer: "svc", Key: other}).Token(context.Background())
+	var tokErr *client.TokenError
+	if !errors.As(err, &tokErr) || tokErr.Code != "invalid_grant" {
+		t.Errorf("err = %v, want invalid_grant", err)
+	}
+}
+
+func TestBearerInvalidatesOnUnauthorized(t *testing.T) {
+	srv := newTokenServer(t)
+	src := client.ClientCredentials(client.OAuth2Config{TokenURL: srv.TokenURL(), ClientID: "svc", ClientSecret: "s3cr:t"})
+
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		if len(seen) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	c := &http.Client{Transport: client.Bearer(src)(http.DefaultTransport)}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(api.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(seen) != 2 || seen[0] == seen[1] {
+		t.Errorf("authorizations = %v, want a new token after the 401", seen)
+	}
+	if n := srv.Requests(); n != 2 {
+		t.Errorf("token requests = %d, want 2", n)
+	}
+}
@@ -0,0 +1,234 @@
+// Package oauth2test runs a fake OAuth2 token endpoint for the tests of code using client token sources.
+package oauth2test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server is a token endpoint issuing opaque tokens for the client credentials
+// and JWT bearer grants, served at /token
+type Server struct {
+	*httptest.Server
+
+	// TTL of the issued tokens
+	TTL time.Duration
+	// Delay before answering, to exercise concurrent refreshes
+	Delay time.Duration
+
+	requests atomic.Int64
+
+	mu      sync.Mutex
+	clients map[string]string
+	issuers map[string]crypto.PublicKey
+	tokens  map[string]time.Time
+	fail    int
+}
+
+// NewServer starts a token endpoint, close it with Close
+func NewServer() *Server {
+	s := &Server{
+		TTL:     time.Hour,
+		clients: make(map[string]string),
+		issuers: make(map[string]crypto.PublicKey),
+		tokens:  make(map[string]time.Time),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// TokenURL returns the URL of the token endpoint
+func (s *Server) TokenURL() string {
+	return s.URL + "/token"
+}
+
+// AddClient registers client credentials
+func (s *Server) AddClient(id, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[id] = secret
+}
+
+// AddIssuer registers the key verifying the JWT assertions of an issuer
+func (s *Server) AddIssuer(issuer string, key crypto.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issuers[issuer] = key
+}
+
+// FailNext answers the next n token requests with a server error
+func (s *Server) FailNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = n
+}
+
+// Requests returns the number of token requests received
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+// Valid reports whether the token was issued by the server and is not expired
+func (s *Server) Valid(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.tokens[token]
+	return ok && time.Now().Before(exp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	if s.Delay > 0 {
+		time.Sleep(s.Delay)
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	failing := s.fail > 0
+	if failing {
+		s.fail--
+	}
+	s.mu.Unlock()
+	if failing {
+		writeError(w, http.StatusInternalServerError, "server_error", "failure requested by the test")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		id, secret, ok := r.BasicAuth()
+		if ok {
+			// RFC 6749 2.3.1 form-encodes the credentials before basic auth
+			id, _ = url.QueryUnescape(id)
+			secret, _ = url.QueryUnescape(secret)
+		} else {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		s.mu.Lock()
+		expected, known := s.clients[id]
+		s.mu.Unlock()
+		if !known || expected != secret {
+			writeError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+			return
+		}
+	case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+		if err := s.verify(r.PostForm.Get("assertion")); err != "" {
+			writeError(w, http.StatusBadRequest, "invalid_grant", err)
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", r.PostForm.Get("grant_type"))
+		return
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+	s.mu.Lock()
+	s.tokens[token] = time.Now().Add(s.TTL)
+	s.mu.Unlock()
+
+	res := map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int64(s.TTL / time.Second),
+	}
+	if scope := r.PostForm.Get("scope"); scope != "" {
+		res["scope"] = scope
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// verify checks the signature, issuer, audience and expiry of an assertion, it returns the problem found
+func (s *Server) verify(assertion string) string {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return "malformed assertion"
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	var claims struct {
+		Iss string      `json:"iss"`
+		Aud interface{} `json:"aud"`
+		Exp int64       `json:"exp"`
+	}
+	if decode(parts[0], &header) != nil || decode(parts[1], &claims) != nil {
+		return "malformed assertion"
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "malformed signature"
+	}
+
+	s.mu.Lock()
+	key, ok := s.issuers[claims.Iss]
+	s.mu.Unlock()
+	if !ok {
+		return "unknown issuer"
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return "invalid signature"
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return "invalid signature"
+		}
+	default:
+		return "unsupported issuer key"
+	}
+
+	if claims.Aud != s.TokenURL() {
+		return "invalid audience"
+	}
+	if time.Now().Unix() >= claims.Exp {
+		return "expired assertion"
+	}
+	return ""
+}
+
+func decode(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
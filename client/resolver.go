@@ -0,0 +1,187 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver provides the addresses (host:port) of the endpoints of a service
+type Resolver interface {
+	// Endpoints returns the current addresses, it is called on every request and must be cheap
+	Endpoints() []string
+	// Close stops refreshing the addresses
+	Close() error
+}
+
+type staticResolver []string
+
+// Static resolves to a fixed list of addresses
+func Static(addrs ...string) Resolver {
+	return staticResolver(addrs)
+}
+
+func (s staticResolver) Endpoints() []string {
+	return s
+}
+
+func (s staticResolver) Close() error {
+	return nil
+}
+
+// lookupFunc returns the addresses and how long they are valid, zero stands for the refresh interval
+type lookupFunc func(ctx context.Context) ([]string, time.Duration, error)
+
+// minRefresh bounds the refreshes of short lived records
+const minRefresh = time.Second
+
+// refreshResolver runs a lookup periodically and keeps the last successful result,
+// it runs again sooner when the result expires before the interval
+type refreshResolver struct {
+	lookup   lookupFunc
+	interval time.Duration
+
+	mu    sync.RWMutex
+	addrs []string
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newRefreshResolver(interval time.Duration, lookup lookupFunc) (*refreshResolver, error) {
+	r := &refreshResolver{
+		lookup:   lookup,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	next, err := r.refresh()
+	if err != nil {
+		return nil, err
+	}
+	go r.loop(next)
+	return r, nil
+}
+
+// refresh looks the addresses up and returns when to refresh them again
+func (r *refreshResolver) refresh() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+	addrs, ttl, err := r.lookup(ctx)
+	if err != nil {
+		return r.interval, err
+	}
+	sort.Strings(addrs)
+	r.mu.Lock()
+	r.addrs = addrs
+	r.mu.Unlock()
+	if ttl <= 0 || ttl > r.interval {
+		return r.interval, nil
+	}
+	return max(ttl, minRefresh), nil
+}
+
+func (r *refreshResolver) loop(next time.Duration) {
+	defer close(r.done)
+	timer := time.NewTimer(next)
+	defer timer.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-timer.C:
+			// a failed refresh keeps the previous addresses
+			next, _ = r.refresh()
+			timer.Reset(next)
+		}
+	}
+}
+
+func (r *refreshResolver) Endpoints() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.addrs
+}
+
+func (r *refreshResolver) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+	return nil
+}
+
+// DNS resolves the A and AAAA records of host, the addresses use the given port. The
+// records are looked up again when their TTL expires and at least every maxTTL. The
+// TTLs are read by querying the nameservers of /etc/resolv.conf, the system resolver
+// is used every maxTTL when they cannot be queried.
+func DNS(host, port string, maxTTL time.Duration) (Resolver, error) {
+	cfg, cfgErr := readDNSConfig("/etc/resolv.conf")
+	return newRefreshResolver(maxTTL, func(ctx context.Context) ([]string, time.Duration, error) {
+		ips, ttl, err := cfg.lookup(ctx, host)
+		if cfgErr != nil || err != nil {
+			ttl = 0
+			if ips, err = net.DefaultResolver.LookupHost(ctx, host); err != nil {
+				return nil, 0, err
+			}
+		}
+		addrs := make([]string, len(ips))
+		for i, ip := range ips {
+			addrs[i] = net.JoinHostPort(ip, port)
+		}
+		return addrs, ttl, nil
+	})
+}
+
+// SRV resolves the SRV records of _service._proto.name. The records are looked up again
+// when their TTL expires and at least every maxTTL, the system resolver is used every
+// maxTTL when the nameservers of /etc/resolv.conf cannot be queried like with DNS.
+func SRV(service, proto, name string, maxTTL time.Duration) (Resolver, error) {
+	cfg, cfgErr := readDNSConfig("/etc/resolv.conf")
+	return newRefreshResolver(maxTTL, func(ctx context.Context) ([]string, time.Duration, error) {
+		addrs, ttl, err := cfg.lookupSRV(ctx, service, proto, name)
+		if cfgErr == nil && err == nil {
+			return addrs, ttl, nil
+		}
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		addrs = make([]string, len(srvs))
+		for i, srv := range srvs {
+			addrs[i] = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		}
+		return addrs, 0, nil
+	})
+}
+
+// File reads the addresses from a file, one per line with # comments,
+// and watches it for changes every interval
+func File(path string, interval time.Duration) (Resolver, error) {
+	return newRefreshResolver(interval, func(context.Context) ([]string, time.Duration, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		var addrs []string
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				addrs = append(addrs, line)
+			}
+		}
+		return addrs, 0, scanner.Err()
+	})
+}
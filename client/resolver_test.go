@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestRefreshNextDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{"no ttl", 0, time.Minute},
+		{"longer than the interval", time.Hour, time.Minute},
+		{"shorter than the interval", 10 * time.Second, 10 * time.Second},
+		{"below the minimum", time.Millisecond, minRefresh},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &refreshResolver{interval: time.Minute, lookup: func(context.Context) ([]string, time.Duration, error) {
+				return []string{"b:1", "a:1"}, tt.ttl, nil
+			}}
+			next, err := r.refresh()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != tt.want {
+				t.Errorf("next = %v, want %v", next, tt.want)
+			}
+			if got := r.Endpoints(); !reflect.DeepEqual(got, []string{"a:1", "b:1"}) {
+				t.Errorf("endpoints = %v", got)
+			}
+		})
+	}
+}
+
+func TestReadDNSConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	conf := "# comment\nnameserver 10.0.0.1\nnameserver ::1\nsearch svc.local local\noptions ndots:5 timeout:1\n"
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readDNSConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := dnsConfig{servers: []string{"10.0.0.1:53", "[::1]:53"}, search: []string{"svc.local", "local"}, ndots: 5}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("config = %+v, want %+v", cfg, want)
+	}
+	if got := cfg.names("api"); !reflect.DeepEqual(got, []string{"api.svc.local.", "api.local.", "api."}) {
+		t.Errorf("names = %v", got)
+	}
+	cfg.ndots = 1
+	if got := cfg.names("api.example"); got[0] != "api.example." {
+		t.Errorf("names = %v", got)
+	}
+}
+
+// serveDNS answers the queries with a CNAME and an A record for api.test.
+// and two SRV records of 45s and 20s for _http._tcp.api.test.
+func serveDNS(t *testing.T, cnameTTL, aTTL uint32) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			res := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: q.ID, Response: true},
+				Questions: q.Questions,
+			}
+			question := q.Questions[0]
+			if question.Name.String() == "api.test." {
+				if question.Type == dnsmessage.TypeA {
+					target := dnsmessage.MustNewName("backend.test.")
+					res.Answers = []dnsmessage.Resource{
+						{
+							Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: cnameTTL},
+							Body:   &dnsmessage.CNAMEResource{CNAME: target},
+						},
+						{
+							Header: dnsmessage.ResourceHeader{Name: target, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: aTTL},
+							Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 7}},
+						},
+					}
+				}
+			} else if question.Name.String() == "_http._tcp.api.test." && question.Type == dnsmessage.TypeSRV {
+				for i, ttl := range []uint32{45, 20} {
+					res.Answers = append(res.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: ttl},
+						Body:   &dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: uint16(8080 + i), Target: dnsmessage.MustNewName("node" + strconv.Itoa(i) + ".api.test.")},
+					})
+				}
+			} else {
+				res.RCode = dnsmessage.RCodeNameError
+			}
+			b, err := res.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = conn.WriteTo(b, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSLookupTTL(t *testing.T) {
+	cfg := dnsConfig{servers: []string{serveDNS(t, 30, 300)}, search: []string{"svc"}, ndots: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, ttl, err := cfg.lookup(ctx, "api.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ips, []string{"10.0.0.7"}) {
+		t.Errorf("ips = %v", ips)
+	}
+	if ttl != 30*time.Second {
+		t.Errorf("ttl = %v, want the CNAME ttl 30s", ttl)
+	}
+
+	if _, _, err := cfg.lookup(ctx, "missing.test"); err == nil {
+		t.Error("expected an error for a name without records")
+	}
+	if ips, _, err := cfg.lookup(ctx, "10.1.2.3"); err != nil || ips[0] != "10.1.2.3" {
+		t.Errorf("ip literal = %v, %v", ips, err)
+	}
+}
+
+func TestSRVLookupTTL(t *testing.T) {
+	cfg := dnsConfig{servers: []string{serveDNS(t, 30, 300)}, search: []string{"svc"}, ndots: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, ttl, err := cfg.lookupSRV(ctx, "http", "tcp", "api.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addrs, []string{"node0.api.test:8080", "node1.api.test:8081"}) {
+		t.Errorf("addrs = %v", addrs)
+	}
+	if ttl != 20*time.Second {
+		t.Errorf("ttl = %v, want the smallest record ttl 20s", ttl)
+	}
+
+	// the name is looked up as is without service and proto
+	if addrs, _, err := cfg.lookupSRV(ctx, "", "", "_http._tcp.api.test"); err != nil || len(addrs) != 2 {
+		t.Errorf("direct name = %v, %v", addrs, err)
+	}
+	if _, _, err := cfg.lookupSRV(ctx, "grpc", "tcp", "api.test"); err == nil {
+		t.Error("expected an error for a service without records")
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := File(path, time.Minute); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	write("# backends\n10.0.0.2:80\n\n  10.0.0.1:80  # primary\n")
+	r, err := File(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := r.Endpoints(); !reflect.DeepEqual(got, []string{"10.0.0.1:80", "10.0.0.2:80"}) {
+		t.Errorf("endpoints = %v", got)
+	}
+
+	write("10.0.0.3:80\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(r.Endpoints(), []string{"10.0.0.3:80"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoints not refreshed: %v", r.Endpoints())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// a failed refresh keeps the previous addresses
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := r.Endpoints(); !reflect.DeepEqual(got, []string{"10.0.0.3:80"}) {
+		t.Errorf("endpoints after the file was removed = %v", got)
+	}
+}
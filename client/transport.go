@@ -0,0 +1,307 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoEndpoints is returned when the resolver has no endpoint for the service
+var ErrNoEndpoints = errors.New("client: no endpoints")
+
+// Config describes the endpoint health tracking of the Transport
+type Config struct {
+	// Scheme used to reach the endpoints, the scheme of the request URL is kept when empty
+	Scheme string `envconfig:"client_scheme" mapstructure:"client_scheme" default:""`
+	// HealthPath is probed on every endpoint when set, endpoints answering anything but 2xx are skipped
+	HealthPath string `envconfig:"client_health_path" mapstructure:"client_health_path" default:""`
+	// HealthInterval between two probes of an endpoint
+	HealthInterval time.Duration `envconfig:"client_health_interval" mapstructure:"client_health_interval" default:"10s"`
+	// ConsecutiveFailures, 5xx or transport errors, ejecting an endpoint
+	ConsecutiveFailures int `envconfig:"client_consecutive_failures" mapstructure:"client_consecutive_failures" default:"5"`
+	// EjectionTime of an endpoint, multiplied by the number of times it was ejected in a row
+	EjectionTime time.Duration `envconfig:"client_ejection_time" mapstructure:"client_ejection_time" default:"30s"`
+	// MaxEjectionPercent of the endpoints ejected at the same time
+	MaxEjectionPercent int `envconfig:"client_max_ejection_percent" mapstructure:"client_max_ejection_percent" default:"50"`
+}
+
+// Endpoint is an address of the service with its load and health
+type Endpoint struct {
+	Addr string
+
+	inflight  atomic.Int64
+	unhealthy atomic.Bool
+
+	// guarded by Transport.mu
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// InFlight returns the number of requests being sent to the endpoint, a request
+// counts until its response body is closed
+func (e *Endpoint) InFlight() int64 {
+	return e.inflight.Load()
+}
+
+// Transport sends each request to an endpoint of the service picked by the balancer,
+// the host of the request URL is replaced by the endpoint address.
+// Endpoints failing in a row are ejected for a while; when no endpoint is available
+// the request is balanced over all of them rather than failed.
+type Transport struct {
+	cfg      Config
+	resolver Resolver
+	balancer Balancer
+	base     http.RoundTripper
+
+	mu        sync.Mutex
+	endpoints map[string]*Endpoint
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+	started   bool
+}
+
+// NewTransport creates a transport over the resolver endpoints, a nil base stands for http.DefaultTransport
+func NewTransport(cfg Config, resolver Resolver, balancer Balancer, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if cfg.HealthInterval <= 0 {
+		cfg.HealthInterval = 10 * time.Second
+	}
+	if cfg.ConsecutiveFailures <= 0 {
+		cfg.ConsecutiveFailures = 5
+	}
+	if cfg.EjectionTime <= 0 {
+		cfg.EjectionTime = 30 * time.Second
+	}
+	if cfg.MaxEjectionPercent <= 0 {
+		cfg.MaxEjectionPercent = 50
+	}
+	return &Transport{
+		cfg:       cfg,
+		resolver:  resolver,
+		balancer:  balancer,
+		base:      base,
+		endpoints: make(map[string]*Endpoint),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// sync returns the endpoints of the resolver addresses, keeping the state of the known ones
+func (t *Transport) sync() []*Endpoint {
+	addrs := t.resolver.Endpoints()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]*Endpoint, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for i, addr := range addrs {
+		e, ok := t.endpoints[addr]
+		if !ok {
+			e = &Endpoint{Addr: addr}
+			t.endpoints[addr] = e
+		}
+		res[i] = e
+		seen[addr] = true
+	}
+	for addr := range t.endpoints {
+		if !seen[addr] {
+			delete(t.endpoints, addr)
+		}
+	}
+	return res
+}
+
+// available filters out the ejected and unhealthy endpoints
+func (t *Transport) available(all []*Endpoint) []*Endpoint {
+	now := time.Now()
+	res := make([]*Endpoint, 0, len(all))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, e := range all {
+		if now.Before(e.ejectedUntil) || e.unhealthy.Load() {
+			continue
+		}
+		res = append(res, e)
+	}
+	if len(res) == 0 {
+		return all
+	}
+	return res
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	all := t.sync()
+	if len(all) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	e := t.balancer.Pick(req, t.available(all))
+
+	out := req.Clone(req.Context())
+	out.URL.Host = e.Addr
+	if t.cfg.Scheme != "" {
+		out.URL.Scheme = t.cfg.Scheme
+	}
+
+	e.inflight.Add(1)
+	resp, err := t.base.RoundTrip(out)
+	if err != nil {
+		e.inflight.Add(-1)
+	} else {
+		resp.Body = newInflightBody(resp, e)
+	}
+
+	// requests canceled by the caller say nothing about the endpoint
+	if req.Context().Err() == nil {
+		t.record(e, err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	}
+	return resp, err
+}
+
+// inflightBody ends the request on its endpoint when the body is closed,
+// the response is streamed from the endpoint until then
+type inflightBody struct {
+	io.ReadCloser
+	e    *Endpoint
+	once sync.Once
+}
+
+func (b *inflightBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.e.inflight.Add(-1) })
+	return err
+}
+
+// inflightConn keeps the body of a protocol switch writable
+type inflightConn struct {
+	*inflightBody
+	w io.Writer
+}
+
+func (c inflightConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+func newInflightBody(resp *http.Response, e *Endpoint) io.ReadCloser {
+	b := &inflightBody{ReadCloser: resp.Body, e: e}
+	if w, ok := resp.Body.(io.Writer); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		return inflightConn{b, w}
+	}
+	return b
+}
+
+// record tracks the consecutive failures of the endpoint and ejects it past the threshold
+func (t *Transport) record(e *Endpoint, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !failed {
+		e.failures = 0
+		if time.Now().After(e.ejectedUntil) {
+			e.ejections = 0
+		}
+		return
+	}
+
+	e.failures++
+	if e.failures < t.cfg.ConsecutiveFailures || !t.canEject() {
+		return
+	}
+	e.failures = 0
+	e.ejections++
+	e.ejectedUntil = time.Now().Add(time.Duration(e.ejections) * t.cfg.EjectionTime)
+}
+
+func (t *Transport) canEject() bool {
+	now := time.Now()
+	ejected := 0
+	for _, e := range t.endpoints {
+		if now.Before(e.ejectedUntil) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= t.cfg.MaxEjectionPercent*len(t.endpoints)
+}
+
+// Start probes the endpoints in the background when Config.HealthPath is set,
+// the calls after the first one do nothing
+func (t *Transport) Start() error {
+	t.startOnce.Do(func() {
+		if t.cfg.HealthPath == "" {
+			close(t.done)
+			return
+		}
+		t.started = true
+		go t.loop()
+	})
+	return nil
+}
+
+func (t *Transport) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.cfg.HealthInterval)
+	defer ticker.Stop()
+	t.probe()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.probe()
+		}
+	}
+}
+
+func (t *Transport) probe() {
+	var wg sync.WaitGroup
+	for _, e := range t.sync() {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			e.unhealthy.Store(!t.healthy(e))
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (t *Transport) healthy(e *Endpoint) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), t.cfg.HealthInterval)
+	defer cancel()
+	scheme := t.cfg.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+e.Addr+t.cfg.HealthPath, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// Shutdown stops the probes and the resolver
+func (t *Transport) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+	if t.started {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return t.resolver.Close()
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInFlightUntilBodyClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	tr := NewTransport(Config{}, Static(u.Host), RoundRobin(), nil)
+	resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, srv.URL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := tr.endpoints[u.Host]
+	if n := e.InFlight(); n != 1 {
+		t.Fatalf("in flight before the body is read = %d, want 1", n)
+	}
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	_ = resp.Body.Close()
+	if n := e.InFlight(); n != 0 {
+		t.Fatalf("in flight after close = %d, want 0", n)
+	}
+}
+
+func TestInFlightOnError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	u, _ := url.Parse(srv.URL)
+	srv.Close()
+
+	tr := NewTransport(Config{}, Static(u.Host), RoundRobin(), nil)
+	if _, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, srv.URL, nil)); err == nil {
+		t.Fatal("expected an error from a closed server")
+	}
+	if n := tr.endpoints[u.Host].InFlight(); n != 0 {
+		t.Fatalf("in flight = %d, want 0", n)
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	for _, cfg := range []Config{{}, {HealthPath: "/health"}} {
+		tr := NewTransport(cfg, Static(), RoundRobin(), nil)
+		if err := tr.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// statusTransport answers each endpoint with its status, 200 by default
+type statusTransport map[string]int
+
+func (s statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, ok := s[req.URL.Host]
+	if !ok {
+		status = http.StatusOK
+	}
+	return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestOutlierEjection(t *testing.T) {
+	base := statusTransport{"bad:1": http.StatusInternalServerError}
+	tr := NewTransport(Config{ConsecutiveFailures: 2, EjectionTime: time.Hour, MaxEjectionPercent: 50},
+		Static("a:1", "bad:1", "c:1", "d:1"), RoundRobin(), base)
+
+	picked := map[string]int{}
+	for i := 0; i < 40; i++ {
+		resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://service/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		picked[resp.Request.URL.Host]++
+	}
+	// the endpoint is ejected after two failures in a row
+	if picked["bad:1"] != 2 {
+		t.Errorf("the failing endpoint got %d requests, want 2", picked["bad:1"])
+	}
+	bad := tr.endpoints["bad:1"]
+	if bad.ejections != 1 || time.Until(bad.ejectedUntil) < 59*time.Minute {
+		t.Errorf("ejections %d until %v", bad.ejections, bad.ejectedUntil)
+	}
+
+	// a success resets the consecutive failures
+	good := tr.endpoints["a:1"]
+	tr.record(good, true)
+	tr.record(good, false)
+	tr.record(good, true)
+	if good.failures != 1 || good.ejections != 0 {
+		t.Errorf("failures %d ejections %d after a success", good.failures, good.ejections)
+	}
+
+	// past MaxEjectionPercent the endpoints are not ejected: 2 of 4 would be 50%, a third 75%
+	tr.record(good, true)
+	if good.ejections != 1 {
+		t.Fatalf("the second endpoint was not ejected")
+	}
+	third := tr.endpoints["c:1"]
+	tr.record(third, true)
+	tr.record(third, true)
+	if third.ejections != 0 || !third.ejectedUntil.IsZero() {
+		t.Errorf("an endpoint was ejected past MaxEjectionPercent")
+	}
+
+	// ejecting again lasts longer, the count is reset by a success once the ejection is over
+	bad.ejectedUntil = time.Now().Add(-time.Second)
+	good.ejectedUntil = time.Now().Add(-time.Second)
+	tr.record(bad, true)
+	tr.record(bad, true)
+	if bad.ejections != 2 || time.Until(bad.ejectedUntil) < 119*time.Minute {
+		t.Errorf("second ejection: %d until %v, want twice the ejection time", bad.ejections, bad.ejectedUntil)
+	}
+	tr.record(good, false)
+	if good.ejections != 0 {
+		t.Errorf("ejections = %d after a success past the ejection", good.ejections)
+	}
+}
+
+func TestAllEjectedBalancesOverAll(t *testing.T) {
+	tr := NewTransport(Config{}, Static("a:1", "b:1"), RoundRobin(), statusTransport{})
+	all := tr.sync()
+	for _, e := range all {
+		e.ejectedUntil = time.Now().Add(time.Hour)
+	}
+	if got := tr.available(all); len(got) != 2 {
+		t.Errorf("available = %d endpoints, want all of them", len(got))
+	}
+	all[0].ejectedUntil = time.Time{}
+	if got := tr.available(all); len(got) != 1 || got[0].Addr != "a:1" {
+		t.Errorf("available = %v, want the endpoint not ejected", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/l00p8/xserver"
+)
+
+func runConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "envconfig prefix of the service")
+	format := fs.String("format", "table", "output format: table, json or env")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// the config is loaded the way the generated services load it
+	var cfg xserver.Config
+	if err := envconfig.Process(*prefix, &cfg); err != nil {
+		return err
+	}
+	fields := xserver.EffectiveConfig(cfg, xserver.ConfigEndpointOptions{EnvPrefix: *prefix})
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(fields)
+	case "env":
+		for _, f := range fields {
+			fmt.Printf("%s=%s\n", f.Env, f.Value)
+		}
+		return nil
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FIELD\tENV\tTYPE\tDEFAULT\tVALUE\tSOURCE")
+		for _, f := range fields {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", f.Name, f.Env, f.Type, f.Default, f.Value, f.Source)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
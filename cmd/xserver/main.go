@@ -0,0 +1,47 @@
+// Command xserver scaffolds and inspects xserver based services
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"new", "generate a new service skeleton", runNew},
+	{"config", "print the effective server config with defaults and env var names", runConfig},
+	{"openapi", "generate a server stub from an OpenAPI 3 document", runOpenAPI},
+	{"mock", "serve a mock from an OpenAPI 3 document or a traffic recording", runMock},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: xserver <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "xserver "+c.name+": "+err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/l00p8/xserver"
+	"github.com/l00p8/xserver/mock"
+	"github.com/l00p8/xserver/openapi"
+)
+
+func runMock(args []string) error {
+	fs := flag.NewFlagSet("mock", flag.ExitOnError)
+	spec := fs.String("spec", "", "OpenAPI 3 document to mock")
+	recording := fs.String("recording", "", "JSONL traffic recording to replay")
+	addr := fs.String("addr", ":8080", "listen address")
+	var opts mock.Options
+	fs.DurationVar(&opts.Latency, "latency", 0, "latency added to every response")
+	fs.DurationVar(&opts.Jitter, "jitter", 0, "random extra latency up to the given duration")
+	fs.Float64Var(&opts.ErrorRate, "error-rate", 0, "share of requests failing, between 0 and 1")
+	fs.IntVar(&opts.ErrorStatus, "error-status", 500, "status of the injected errors")
+	fs.Int64Var(&opts.Seed, "seed", 0, "seed of the injected jitter and errors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*spec == "") == (*recording == "") {
+		fs.Usage()
+		return errors.New("exactly one of -spec and -recording is required")
+	}
+
+	cfg := xserver.Config{
+		Addr:            *addr,
+		ShutdownTimeout: 5 * time.Second,
+		GracefulTimeout: 6 * time.Second,
+		Timeout:         time.Minute,
+		RateLimit:       1000,
+		LogHandler:      slog.NewTextHandler(os.Stderr, nil),
+	}
+	r := xserver.NewRouter(cfg)
+
+	if *spec != "" {
+		doc, err := openapi.Load(*spec)
+		if err != nil {
+			return err
+		}
+		if err := mock.FromOpenAPI(r, doc, opts); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(*recording)
+		if err != nil {
+			return err
+		}
+		err = mock.FromRecording(r, f, opts)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return xserver.Listen(cfg, r, func() {})
+}
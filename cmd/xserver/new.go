@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"text/template"
+
+	"github.com/l00p8/xserver"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+// scaffold lists generated files with their templates
+var scaffold = []struct{ file, tmpl string }{
+	{"go.mod", "go.mod.tmpl"},
+	{"main.go", "main.go.tmpl"},
+	{"config.go", "config.go.tmpl"},
+	{"routes.go", "routes.go.tmpl"},
+	{"health.go", "health.go.tmpl"},
+	{"Dockerfile", "Dockerfile.tmpl"},
+	{"deploy/k8s.yaml", "k8s.yaml.tmpl"},
+}
+
+type scaffoldData struct {
+	Name      string
+	Module    string
+	EnvPrefix string
+	Port      string
+	Health    string
+	Fields    []xserver.ConfigField
+	// Version of xserver required by the service, left to go mod tidy when unknown
+	Version string
+}
+
+// xserverVersion tells the xserver version the CLI was installed from
+func xserverVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Path != "github.com/l00p8/xserver" || info.Main.Version == "(devel)" {
+		return ""
+	}
+	return info.Main.Version
+}
+
+func runNew(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	module := fs.String("module", "", "go module path of the service, defaults to the service name")
+	dir := fs.String("dir", "", "output directory, defaults to the service name")
+	prefix := fs.String("prefix", "", "envconfig prefix, defaults to the upper-cased service name")
+	port := fs.String("port", "8080", "port the service listens on")
+	force := fs.Bool("force", false, "overwrite existing files")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: xserver new [flags] <name>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("service name is required")
+	}
+
+	name := fs.Arg(0)
+	data := scaffoldData{
+		Name:      name,
+		Module:    *module,
+		EnvPrefix: *prefix,
+		Port:      *port,
+		Health:    "/_health",
+		Version:   xserverVersion(),
+	}
+	if data.Module == "" {
+		data.Module = name
+	}
+	if data.EnvPrefix == "" {
+		data.EnvPrefix = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(path.Base(name)))
+	}
+	data.Fields = xserver.ConfigFields(data.EnvPrefix)
+	if *dir == "" {
+		*dir = path.Base(name)
+	}
+
+	tmpl, err := template.New("").ParseFS(templates, "templates/*.tmpl")
+	if err != nil {
+		return err
+	}
+
+	if !*force {
+		for _, f := range scaffold {
+			target := filepath.Join(*dir, filepath.FromSlash(f.file))
+			if _, err := os.Stat(target); err == nil {
+				return fmt.Errorf("%s already exists, use -force to overwrite", target)
+			}
+		}
+	}
+
+	for _, f := range scaffold {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, f.tmpl, data); err != nil {
+			return fmt.Errorf("%s: %w", f.file, err)
+		}
+		content := buf.Bytes()
+		if strings.HasSuffix(f.file, ".go") {
+			if content, err = format.Source(content); err != nil {
+				return fmt.Errorf("%s: %w", f.file, err)
+			}
+		}
+
+		target := filepath.Join(*dir, filepath.FromSlash(f.file))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, 0o644); err != nil {
+			return err
+		}
+		fmt.Println("created " + target)
+	}
+	fmt.Println("run `go mod tidy` in " + *dir + " to fetch the dependencies")
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/l00p8/xserver"
+)
+
+func TestScaffoldBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a generated service")
+	}
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is not installed")
+	}
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := runNew([]string{"-dir", dir, "-module", "example.com/orders", "orders"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// build against this tree instead of a released xserver
+	gomod := filepath.Join(dir, "go.mod")
+	for _, args := range [][]string{
+		{"mod", "edit", "-require", "github.com/l00p8/xserver@v0.0.0", "-replace", "github.com/l00p8/xserver=" + root, gomod},
+		{"build", "-mod=mod", "-o", os.DevNull, "."},
+		{"vet", "-mod=mod", "."},
+	} {
+		cmd := exec.Command(gobin, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod=mod", "GOSUMDB=off")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %v: %v\n%s", args[0], err, out)
+		}
+	}
+}
+
+// The defaults of the Config durations carry a unit, envconfig and viper
+// parse them with time.ParseDuration which rejects unitless numbers
+func TestConfigDefaultsLoad(t *testing.T) {
+	var cfg xserver.Config
+	if err := envconfig.Process("XSERVER_DEFAULTS_TEST", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeout.Seconds() != 20 || cfg.ShutdownTimeout.Seconds() != 20 || cfg.GracefulTimeout.Seconds() != 21 {
+		t.Errorf("timeouts = %s, %s, %s", cfg.Timeout, cfg.ShutdownTimeout, cfg.GracefulTimeout)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/l00p8/xserver/openapi"
+)
+
+func runOpenAPI(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ExitOnError)
+	spec := fs.String("spec", "", "OpenAPI 3 document in JSON or YAML format")
+	pkg := fs.String("pkg", "api", "package name of the generated code")
+	out := fs.String("o", "", "output file, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *spec == "" {
+		fs.Usage()
+		return errors.New("-spec is required")
+	}
+
+	doc, err := openapi.Load(*spec)
+	if err != nil {
+		return err
+	}
+	src, err := openapi.Generate(doc, *pkg)
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	if err := os.WriteFile(*out, src, 0o644); err != nil {
+		return err
+	}
+	fmt.Println("generated " + *out)
+	return nil
+}
@@ -0,0 +1,120 @@
+package xserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// Service describes a Router hosted by a Composite.
+// A service with a Prefix is mounted under that prefix on the composite listener,
+// a service without a Prefix gets its own listener on Config.Addr.
+// Config is expected to be the one the Router was created with,
+// the aggregated health reports the checks registered with Router.Healthers.
+type Service struct {
+	Name    string
+	Prefix  string
+	Config  Config
+	Router  Router
+	CleanUp func()
+}
+
+// Composite runs several services in one process with a unified lifecycle
+// and an aggregated health endpoint
+type Composite struct {
+	cfg      Config
+	mux      chi.Router
+	services []Service
+}
+
+// NewComposite mounts the services, cfg configures the shared listener and the shutdown.
+// The rewrite rules of cfg apply to every request of the shared listener before the
+// services are selected, the rules of a mounted service see the path below its prefix.
+func NewComposite(cfg Config, services ...Service) *Composite {
+	c := &Composite{cfg: cfg, mux: chi.NewRouter(), services: services}
+	if rw := rewriteMiddleware(cfg, cfg.Slog()); rw != nil {
+		c.mux.Use(rw)
+	}
+
+	for _, s := range services {
+		if s.Prefix != "" {
+			c.mux.Mount("/"+strings.Trim(s.Prefix, "/"), s.Router.Mux())
+		}
+	}
+	c.mux.Get("/_health", c.healthHandler)
+
+	return c
+}
+
+// Mux returns the shared router serving the mounted services
+func (c *Composite) Mux() chi.Router {
+	return c.mux
+}
+
+// Health reports the failing health checks by service name
+func (c *Composite) Health() map[string][]string {
+	return c.health(context.Background())
+}
+
+// health also reports the services as shutting down when ctx is a request of the server
+func (c *Composite) health(ctx context.Context) map[string][]string {
+	res := make(map[string][]string)
+	for _, s := range c.services {
+		if errs := checkHealth(ctx, routerHealthers(s.Router)); len(errs) > 0 {
+			res[s.Name] = errs
+		}
+	}
+	return res
+}
+
+func (c *Composite) healthHandler(w http.ResponseWriter, r *http.Request) {
+	res := c.health(r.Context())
+	if len(res) == 0 {
+		return
+	}
+	d, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = w.Write(d)
+}
+
+// Listen starts the shared listener and the listeners of standalone services,
+// all of them are shut down together
+func (c *Composite) Listen(cleanUp func(), components ...Lifecycle) error {
+	if err := validateRewrites(c.cfg); err != nil {
+		return err
+	}
+	for _, s := range c.services {
+		if err := validateRewrites(s.Config); err != nil {
+			return fmt.Errorf("%s: %w", s.Name, err)
+		}
+	}
+	c.mux.Handle("/_metrics", metricsHandler(c.cfg))
+	listeners := []*listener{newListener(c.cfg, c.mux)}
+
+	for _, s := range c.services {
+		if s.Prefix != "" {
+			continue
+		}
+		s.Router.Mux().Handle("/_metrics", metricsHandler(s.Config))
+		listeners = append(listeners, newListener(s.Config, s.Router.Mux()))
+	}
+
+	return run(c.cfg, listeners, func() {
+		for _, s := range c.services {
+			if s.CleanUp != nil {
+				s.CleanUp()
+			}
+		}
+		if cleanUp != nil {
+			cleanUp()
+		}
+	}, components)
+}
@@ -0,0 +1,163 @@
+package xserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type healther struct {
+	err error
+}
+
+func (h *healther) Health() error { return h.err }
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func named(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, name+" "+r.URL.Path)
+	}
+}
+
+func TestCompositeMountsAndAggregatesHealth(t *testing.T) {
+	db := &healther{}
+	orders := NewRouter(Config{RateLimit: 10})
+	orders.Get("/items", named("orders"))
+	orders.Healthers(db)
+	users := NewRouterWithTracing(NewRouter(Config{RateLimit: 10}))
+	users.Get("/items", named("users"))
+	users.Healthers(&healther{})
+
+	c := NewComposite(Config{},
+		Service{Name: "orders", Prefix: "/orders", Router: orders},
+		Service{Name: "users", Prefix: "users/", Router: users})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	for path, want := range map[string]string{"/orders/items": "orders /orders/items", "/users/items": "users /users/items"} {
+		if rec := serve(path); rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("%s = %d %q, want %q", path, rec.Code, rec.Body, want)
+		}
+	}
+
+	if rec := serve("/_health"); rec.Code != http.StatusOK {
+		t.Errorf("healthy composite: %d %s", rec.Code, rec.Body)
+	}
+
+	// the checks registered on the routers are aggregated by service name
+	db.err = errors.New("db down")
+	rec := serve("/_health")
+	var res map[string][]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || len(res) != 1 || len(res["orders"]) != 1 || res["orders"][0] != "db down" {
+		t.Errorf("unhealthy composite: %d %v", rec.Code, res)
+	}
+	if health := c.Health(); len(health["orders"]) != 1 {
+		t.Errorf("Health = %v", health)
+	}
+	// the health of a service stays reachable under its prefix
+	if rec := serve("/orders/_health"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("service health: %d", rec.Code)
+	}
+}
+
+func TestCompositeListen(t *testing.T) {
+	shared, standalone := freeAddr(t), freeAddr(t)
+	mounted := NewRouter(Config{RateLimit: 10})
+	mounted.Get("/ping", named("mounted"))
+	alone := NewRouter(Config{RateLimit: 10})
+	alone.Get("/ping", named("alone"))
+
+	var cleaned []string
+	cleanUp := func(name string) func() {
+		return func() { cleaned = append(cleaned, name) }
+	}
+	c := NewComposite(Config{Addr: shared, ShutdownTimeout: time.Second, GracefulTimeout: time.Millisecond},
+		Service{Name: "mounted", Prefix: "/mounted", Router: mounted, CleanUp: cleanUp("mounted")},
+		Service{Name: "alone", Config: Config{Addr: standalone}, Router: alone, CleanUp: cleanUp("alone")})
+
+	comp := &component{}
+	done := make(chan error, 1)
+	go func() { done <- c.Listen(cleanUp("composite"), comp) }()
+
+	get := func(url string) (int, string) {
+		res, err := http.Get(url)
+		if err != nil {
+			return 0, ""
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code1, _ := get("http://" + shared + "/_health")
+		code2, _ := get("http://" + standalone + "/ping")
+		if code1 == http.StatusOK && code2 == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the listeners did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for url, want := range map[string]string{
+		"http://" + shared + "/mounted/ping": "mounted /mounted/ping",
+		"http://" + standalone + "/ping":     "alone /ping",
+	} {
+		if code, body := get(url); code != http.StatusOK || body != want {
+			t.Errorf("%s = %d %q, want %q", url, code, body, want)
+		}
+	}
+	// the standalone service is not mounted on the shared listener
+	if code, _ := get("http://" + shared + "/alone/ping"); code != http.StatusNotFound {
+		t.Errorf("standalone service on the shared listener: %d", code)
+	}
+	for _, addr := range []string{shared, standalone} {
+		if code, _ := get("http://" + addr + "/_metrics"); code != http.StatusOK {
+			t.Errorf("metrics of %s: %d", addr, code)
+		}
+	}
+
+	// run handles the signal, every listener is shut down together
+	self, _ := os.FindProcess(os.Getpid())
+	if err := self.Signal(syscall.SIGTERM); err != nil {
+		t.Skip("cannot signal the process:", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Listen = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not return")
+	}
+	if code, _ := get("http://" + standalone + "/ping"); code != 0 {
+		t.Errorf("the standalone listener is still serving")
+	}
+	if len(cleaned) != 3 || cleaned[2] != "composite" || !comp.down {
+		t.Errorf("cleaned up %v, component down %v", cleaned, comp.down)
+	}
+}
@@ -0,0 +1,43 @@
+package xserver
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ConfigField describes a Config field loaded from the environment
+type ConfigField struct {
+	Name    string `json:"name"`
+	Env     string `json:"env"`
+	Key     string `json:"key"`
+	Type    string `json:"type"`
+	Default string `json:"default"`
+	Secret  bool   `json:"secret"`
+}
+
+// ConfigFields lists the Config fields settable through environment variables,
+// env names are built the way envconfig does it for the given prefix
+func ConfigFields(prefix string) []ConfigField {
+	t := reflect.TypeOf(Config{})
+	fields := make([]ConfigField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("envconfig")
+		if !ok || f.Tag.Get("ignored") == "true" {
+			continue
+		}
+		env := tag
+		if prefix != "" {
+			env = prefix + "_" + env
+		}
+		fields = append(fields, ConfigField{
+			Name:    f.Name,
+			Env:     strings.ToUpper(env),
+			Key:     f.Tag.Get("mapstructure"),
+			Type:    f.Type.String(),
+			Default: f.Tag.Get("default"),
+			Secret:  f.Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
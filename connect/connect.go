@@ -0,0 +1,229 @@
+// Package connect mounts Connect RPC handlers, such as the ones generated by
+// connectrpc.com/connect, on xserver routers. The Connect, gRPC and gRPC-Web protocols
+// of the handlers are served with the router metrics and tracing, the callers are
+// authenticated and the rejected calls are answered in the error format of their protocol.
+// gRPC needs HTTP/2, served on TLS listeners or with Config.H2C.
+package connect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l00p8/xserver"
+	"github.com/l00p8/xserver/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var calls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "connect_calls_total",
+	Help: "Number of Connect, gRPC and gRPC-Web calls by procedure and code.",
+}, []string{"procedure", "protocol", "code"})
+
+// maxErrorBody bounds the unary error bodies kept to read their code
+const maxErrorBody = 64 * 1024
+
+// Options describes how the handlers are mounted
+type Options struct {
+	// Authenticator checks the callers, the procedures are public when nil
+	Authenticator auth.Authenticator
+	// Scopes are required from every caller
+	Scopes []string
+	// Timeout bounds the Connect unary calls, a longer Connect-Timeout-Ms of the client is
+	// lowered to it. It defaults to 20s. The routes are exempt from Config.Timeout: streams
+	// and gRPC calls are only bound by the deadline of their client. The calls still count
+	// in Config.RateLimit, xserver.WithoutRateLimit in RouteOptions exempts them.
+	Timeout time.Duration
+	// RouteOptions are applied to the routes
+	RouteOptions []xserver.RouteOption
+}
+
+// Mount serves the procedures of a handler under path, such as the path and the handler
+// returned by a generated New<Service>Handler. Unary calls are served on POST and GET.
+func Mount(r xserver.Router, path string, h http.Handler, opts Options) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 20 * time.Second
+	}
+
+	// the handler gets the writer of the call, so the error writer only sees rejections
+	var guarded http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		h.ServeHTTP(w.(*errorWriter).call, req)
+	})
+	if opts.Authenticator != nil {
+		guarded = auth.Middleware(opts.Authenticator, opts.Scopes...)(guarded)
+	}
+
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		c := &call{ResponseWriter: w, protocol: protocolOf(req), status: http.StatusOK}
+		ctx := req.Context()
+		if c.protocol == connectUnary {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, capTimeout(req, opts.Timeout))
+			defer cancel()
+			req = req.WithContext(ctx)
+		} else {
+			// the server deadlines are derived from Config.Timeout
+			rc := http.NewResponseController(w)
+			_ = rc.SetReadDeadline(time.Time{})
+			_ = rc.SetWriteDeadline(time.Time{})
+		}
+
+		procedure := req.URL.Path
+		service, method := splitProcedure(procedure)
+		span := trace.SpanFromContext(ctx)
+		span.SetName(strings.TrimPrefix(procedure, "/"))
+		span.SetAttributes(
+			attribute.String("rpc.system", "connect_rpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", method))
+
+		ew := &errorWriter{ResponseWriter: w, call: c}
+		guarded.ServeHTTP(ew, req)
+		ew.finish(req)
+
+		code := c.code()
+		if code != "ok" {
+			span.SetAttributes(attribute.String("rpc.connect_rpc.error_code", code))
+			span.SetStatus(otelcodes.Error, code)
+		}
+		if code == "unimplemented" {
+			// unknown procedures would grow the label values without bound
+			procedure = "unknown"
+		}
+		calls.WithLabelValues(procedure, string(c.protocol), code).Inc()
+	}
+
+	pattern := strings.TrimSuffix(path, "/") + "/*"
+	routeOpts := append(opts.RouteOptions, xserver.WithoutTimeout())
+	r.Post(pattern, fn, routeOpts...)
+	r.Get(pattern, fn, routeOpts...)
+}
+
+// capTimeout returns the timeout of a unary call, the Connect-Timeout-Ms of the client
+// when it is shorter. A longer header is lowered so the handler reads the deadline it is held to.
+func capTimeout(req *http.Request, timeout time.Duration) time.Duration {
+	v := req.Header.Get("Connect-Timeout-Ms")
+	if v == "" {
+		return timeout
+	}
+	// invalid values are left to the handler to reject
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms < 0 {
+		return timeout
+	}
+	if max := timeout.Milliseconds(); ms > max {
+		req.Header.Set("Connect-Timeout-Ms", strconv.FormatInt(max, 10))
+		return timeout
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// splitProcedure splits /package.Service/Method
+func splitProcedure(procedure string) (string, string) {
+	service, method, _ := strings.Cut(strings.TrimPrefix(procedure, "/"), "/")
+	return service, method
+}
+
+// call records the response of a call to tell its code
+type call struct {
+	http.ResponseWriter
+	protocol protocol
+	status   int
+	errBody  bytes.Buffer
+	frames   frames
+}
+
+func (c *call) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *call) Write(b []byte) (int, error) {
+	switch {
+	case c.protocol == connectStream || c.protocol == grpcWeb:
+		c.frames.write(b)
+	case c.status != http.StatusOK && c.errBody.Len()+len(b) <= maxErrorBody:
+		c.errBody.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *call) Flush() {
+	_ = http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+func (c *call) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// code tells the Connect code of the call once it is done
+func (c *call) code() string {
+	h := c.Header()
+	switch c.protocol {
+	case connectUnary:
+		var msg struct {
+			Code string `json:"code"`
+		}
+		if c.status != http.StatusOK && json.Unmarshal(c.errBody.Bytes(), &msg) == nil && msg.Code != "" {
+			return msg.Code
+		}
+	case connectStream:
+		if code, ok := c.frames.connectCode(); ok {
+			return code
+		}
+	case grpcWeb:
+		if code, ok := c.frames.grpcWebCode(); ok {
+			return code
+		}
+	}
+	if c.protocol == grpc || c.protocol == grpcWeb {
+		for _, k := range []string{"Grpc-Status", http.TrailerPrefix + "Grpc-Status"} {
+			if v := h.Get(k); v != "" {
+				return codeOfStatus(v)
+			}
+		}
+	}
+	return codeOfHTTP(c.status)
+}
+
+// errorWriter holds the error written by the middlewares rejecting a call,
+// it is answered in the format of the call protocol
+type errorWriter struct {
+	http.ResponseWriter
+	call   *call
+	status int
+	body   bytes.Buffer
+}
+
+func (w *errorWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *errorWriter) finish(r *http.Request) {
+	if w.status == 0 {
+		return
+	}
+	var res struct {
+		Error string `json:"error"`
+	}
+	msg := http.StatusText(w.status)
+	if json.Unmarshal(w.body.Bytes(), &res) == nil && res.Error != "" {
+		msg = res.Error
+	}
+	writeError(w.call, r, w.call.protocol, w.status, msg)
+}
@@ -0,0 +1,271 @@
+package connect
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/l00p8/xserver"
+	"github.com/l00p8/xserver/auth"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUnaryTimeoutCapped(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		within time.Duration
+	}{
+		{"no header", "", "", time.Second},
+		{"shorter", "200", "200", 200 * time.Millisecond},
+		{"longer", "60000", "1000", time.Second},
+		{"invalid", "soon", "soon", time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := xserver.NewRouter(xserver.Config{RateLimit: 10})
+			var header string
+			var deadline time.Time
+			Mount(r, "/pkg.Service/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				header = req.Header.Get("Connect-Timeout-Ms")
+				deadline, _ = req.Context().Deadline()
+				_, _ = w.Write([]byte("{}"))
+			}), Options{Timeout: time.Second})
+
+			req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", strings.NewReader("{}"))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set("Connect-Timeout-Ms", tt.header)
+			}
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			r.Mux().ServeHTTP(rec, req)
+
+			if header != tt.want {
+				t.Errorf("Connect-Timeout-Ms = %q, want %q", header, tt.want)
+			}
+			if deadline.IsZero() || deadline.Sub(start) > tt.within+100*time.Millisecond {
+				t.Errorf("deadline in %v, want within %v", deadline.Sub(start), tt.within)
+			}
+		})
+	}
+}
+
+func TestRejectedCalls(t *testing.T) {
+	authenticator := auth.AuthenticatorFunc(func(r *http.Request) (*auth.Principal, error) {
+		switch auth.BearerToken(r) {
+		case "writer":
+			return &auth.Principal{Subject: "w", Scopes: []string{"write"}}, nil
+		case "reader":
+			return &auth.Principal{Subject: "r"}, nil
+		}
+		return nil, auth.ErrUnauthenticated
+	})
+	r := xserver.NewRouter(xserver.Config{RateLimit: 10})
+	called := false
+	Mount(r, "/pkg.Service/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = true
+		_, _ = w.Write([]byte("{}"))
+	}), Options{Authenticator: authenticator, Scopes: []string{"write"}})
+
+	tests := []struct {
+		name        string
+		contentType string
+		token       string
+		check       func(t *testing.T, rec *httptest.ResponseRecorder)
+	}{
+		{"connect unauthenticated", "application/json", "", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			assertConnectError(t, rec, http.StatusUnauthorized, "unauthenticated", "authentication is required")
+		}},
+		{"connect insufficient scope", "application/json", "reader", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			assertConnectError(t, rec, http.StatusForbidden, "permission_denied", "insufficient scope")
+		}},
+		{"connect stream", "application/connect+json", "", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			var f frames
+			f.write(rec.Body.Bytes())
+			if code, ok := f.connectCode(); rec.Code != http.StatusOK || !ok || code != "unauthenticated" {
+				t.Errorf("status %d, end of stream code %q %v", rec.Code, code, ok)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/connect+json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+		}},
+		{"grpc", "application/grpc+proto", "", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			assertGRPCError(t, rec, "16", "authentication is required")
+		}},
+		{"grpc insufficient scope", "application/grpc", "reader", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			assertGRPCError(t, rec, "7", "insufficient scope")
+		}},
+		{"grpc-web", "application/grpc-web+proto", "", func(t *testing.T, rec *httptest.ResponseRecorder) {
+			assertGRPCError(t, rec, "16", "authentication is required")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", strings.NewReader("{}"))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			r.Mux().ServeHTTP(rec, req)
+			if called {
+				t.Error("the handler was called")
+			}
+			tt.check(t, rec)
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer writer")
+	rec := httptest.NewRecorder()
+	r.Mux().ServeHTTP(rec, req)
+	if !called || rec.Code != http.StatusOK {
+		t.Errorf("authorized call: called %v, status %d", called, rec.Code)
+	}
+}
+
+func assertConnectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code, msg string) {
+	t.Helper()
+	var res struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("body %q: %v", rec.Body, err)
+	}
+	if rec.Code != status || res.Code != code || res.Message != msg {
+		t.Errorf("%d %+v, want %d %s %q", rec.Code, res, status, code, msg)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func assertGRPCError(t *testing.T, rec *httptest.ResponseRecorder, status, msg string) {
+	t.Helper()
+	h := rec.Header()
+	if rec.Code != http.StatusOK || h.Get("Grpc-Status") != status || h.Get("Grpc-Message") != msg {
+		t.Errorf("%d Grpc-Status %q Grpc-Message %q, want %s %q", rec.Code, h.Get("Grpc-Status"), h.Get("Grpc-Message"), status, msg)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("the trailers-only response has a body %q", rec.Body)
+	}
+}
+
+func TestCallCodes(t *testing.T) {
+	tests := []struct {
+		method      string
+		contentType string
+		write       func(w http.ResponseWriter)
+		procedure   string
+		code        string
+	}{
+		{"UnaryOK", "application/json", func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte("{}"))
+		}, "", "ok"},
+		{"UnaryError", "application/json", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":"invalid_argument","message":"bad"}`))
+		}, "", "invalid_argument"},
+		{"UnaryPlainError", "application/json", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("down"))
+		}, "", "unavailable"},
+		{"Unknown", "application/json", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+		}, "unknown", "unimplemented"},
+		{"StreamError", "application/connect+json", func(w http.ResponseWriter) {
+			// the frames are split across writes
+			b := append(envelope(0, []byte(`{"a":1}`)), envelope(0x02, []byte(`{"error":{"code":"not_found"}}`))...)
+			for len(b) > 0 {
+				n := min(3, len(b))
+				_, _ = w.Write(b[:n])
+				b = b[n:]
+			}
+		}, "", "not_found"},
+		{"StreamOK", "application/connect+json", func(w http.ResponseWriter) {
+			_, _ = w.Write(envelope(0x02, []byte(`{}`)))
+		}, "", "ok"},
+		{"GRPCHeader", "application/grpc", func(w http.ResponseWriter) {
+			w.Header().Set("Grpc-Status", "7")
+			w.WriteHeader(http.StatusOK)
+		}, "", "permission_denied"},
+		{"GRPCTrailer", "application/grpc", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(envelope(0, []byte("msg")))
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "5")
+		}, "", "not_found"},
+		{"GRPCInvalidStatus", "application/grpc", func(w http.ResponseWriter) {
+			w.Header().Set("Grpc-Status", "99")
+		}, "", "unknown"},
+		{"GRPCWebTrailers", "application/grpc-web+proto", func(w http.ResponseWriter) {
+			_, _ = w.Write(envelope(0, []byte("msg")))
+			_, _ = w.Write(envelope(0x80, []byte("grpc-status: 14\r\ngrpc-message: down\r\n")))
+		}, "", "unavailable"},
+	}
+
+	r := xserver.NewRouter(xserver.Config{RateLimit: 10})
+	Mount(r, "/pkg.Codes/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, tt := range tests {
+			if req.URL.Path == "/pkg.Codes/"+tt.method {
+				tt.write(w)
+			}
+		}
+	}), Options{})
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			procedure := "/pkg.Codes/" + tt.method
+			if tt.procedure != "" {
+				procedure = tt.procedure
+			}
+			counter := calls.WithLabelValues(procedure, string(protocolOf(&http.Request{Method: http.MethodPost, Header: http.Header{"Content-Type": {tt.contentType}}})), tt.code)
+			before := testutil.ToFloat64(counter)
+
+			req := httptest.NewRequest(http.MethodPost, "/pkg.Codes/"+tt.method, strings.NewReader("{}"))
+			req.Header.Set("Content-Type", tt.contentType)
+			r.Mux().ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := testutil.ToFloat64(counter); got != before+1 {
+				t.Errorf("calls with code %s: %v, want %v", tt.code, got, before+1)
+			}
+		})
+	}
+}
+
+func TestUnaryCallsAreRateLimited(t *testing.T) {
+	r := xserver.NewRouter(xserver.Config{RateLimit: 1})
+	hold, held := make(chan struct{}), make(chan struct{})
+	Mount(r, "/pkg.Service/", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/Hold") {
+			held <- struct{}{}
+			<-hold
+		}
+		_, _ = w.Write([]byte("{}"))
+	}), Options{})
+
+	serve := func(method string) int {
+		req := httptest.NewRequest(http.MethodPost, "/pkg.Service/"+method, strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		r.Mux().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	done := make(chan int)
+	go func() { done <- serve("Hold") }()
+	<-held
+	if code := serve("Method"); code != http.StatusTooManyRequests {
+		t.Errorf("call past the limit: %d, want 429", code)
+	}
+	close(hold)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("held call: %d", code)
+	}
+}
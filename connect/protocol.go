@@ -0,0 +1,218 @@
+package connect
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type protocol string
+
+const (
+	connectUnary  protocol = "connect"
+	connectStream protocol = "connect_stream"
+	grpc          protocol = "grpc"
+	grpcWeb       protocol = "grpcweb"
+)
+
+// protocolOf tells the protocol of a call from its method and content type
+func protocolOf(r *http.Request) protocol {
+	ct := r.Header.Get("Content-Type")
+	switch {
+	case r.Method == http.MethodGet:
+		return connectUnary
+	case strings.HasPrefix(ct, "application/grpc-web"):
+		return grpcWeb
+	case strings.HasPrefix(ct, "application/grpc"):
+		return grpc
+	case strings.HasPrefix(ct, "application/connect+"):
+		return connectStream
+	default:
+		return connectUnary
+	}
+}
+
+// codes are the Connect error codes, indexed by their gRPC status
+var codes = []string{
+	"ok",
+	"canceled",
+	"unknown",
+	"invalid_argument",
+	"deadline_exceeded",
+	"not_found",
+	"already_exists",
+	"permission_denied",
+	"resource_exhausted",
+	"failed_precondition",
+	"aborted",
+	"out_of_range",
+	"unimplemented",
+	"internal",
+	"unavailable",
+	"data_loss",
+	"unauthenticated",
+}
+
+func codeOfStatus(s string) string {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 0 || n >= len(codes) {
+		return "unknown"
+	}
+	return codes[n]
+}
+
+func statusOfCode(code string) int {
+	for i, c := range codes {
+		if c == code {
+			return i
+		}
+	}
+	return 2
+}
+
+// codeOfHTTP maps the status of a response carrying no Connect error, as Connect clients do
+func codeOfHTTP(status int) string {
+	switch status {
+	case http.StatusOK:
+		return "ok"
+	case http.StatusBadRequest:
+		return "internal"
+	case http.StatusUnauthorized:
+		return "unauthenticated"
+	case http.StatusForbidden:
+		return "permission_denied"
+	case http.StatusNotFound:
+		return "unimplemented"
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+// maxEndFrame bounds the end of stream frame kept to read the code of a stream
+const maxEndFrame = 64 * 1024
+
+// frames follows the enveloped messages of a stream and keeps the payload of
+// its end frame, the Connect end of stream message or the gRPC-Web trailers
+type frames struct {
+	head  [5]byte
+	read  int
+	left  int
+	ended bool
+	end   []byte
+}
+
+func (f *frames) isEnd() bool {
+	// 0x02 flags a Connect end of stream message, 0x80 gRPC-Web trailers
+	return f.head[0]&0x82 != 0
+}
+
+func (f *frames) write(b []byte) {
+	for len(b) > 0 {
+		if f.read < len(f.head) {
+			n := copy(f.head[f.read:], b)
+			f.read += n
+			b = b[n:]
+			if f.read < len(f.head) {
+				return
+			}
+			f.left = int(binary.BigEndian.Uint32(f.head[1:]))
+			if f.isEnd() {
+				f.ended = true
+				f.end = f.end[:0]
+			}
+		}
+		n := min(f.left, len(b))
+		if f.isEnd() && len(f.end)+n <= maxEndFrame {
+			f.end = append(f.end, b[:n]...)
+		}
+		f.left -= n
+		b = b[n:]
+		if f.left == 0 {
+			f.read = 0
+		}
+	}
+}
+
+// connectCode reads the code of a Connect end of stream message
+func (f *frames) connectCode() (string, bool) {
+	if !f.ended || f.head[0]&0x01 != 0 {
+		return "", false
+	}
+	var msg struct {
+		Error *struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(f.end, &msg); err != nil {
+		return "", false
+	}
+	if msg.Error == nil {
+		return "ok", true
+	}
+	return msg.Error.Code, true
+}
+
+// grpcWebCode reads the status of gRPC-Web trailers
+func (f *frames) grpcWebCode() (string, bool) {
+	if !f.ended || f.head[0]&0x01 != 0 {
+		return "", false
+	}
+	for _, line := range strings.Split(string(f.end), "\r\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), "grpc-status") {
+			return codeOfStatus(v), true
+		}
+	}
+	return "", false
+}
+
+// envelope frames a message with its flags
+func envelope(flags byte, msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	b[0] = flags
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// grpcMessage percent-encodes a gRPC status message
+func grpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// writeError answers a call rejected before its handler in the error format of its protocol
+func writeError(w http.ResponseWriter, r *http.Request, p protocol, status int, msg string) {
+	code := codeOfHTTP(status)
+	h := w.Header()
+	switch p {
+	case connectUnary:
+		d, _ := json.Marshal(map[string]string{"code": code, "message": msg})
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(d)
+	case connectStream:
+		d, _ := json.Marshal(map[string]interface{}{"error": map[string]string{"code": code, "message": msg}})
+		h.Set("Content-Type", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(envelope(0x02, d))
+	default:
+		// trailers-only response
+		h.Set("Content-Type", r.Header.Get("Content-Type"))
+		h.Set("Grpc-Status", strconv.Itoa(statusOfCode(code)))
+		h.Set("Grpc-Message", grpcMessage(msg))
+		w.WriteHeader(http.StatusOK)
+	}
+}
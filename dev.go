@@ -0,0 +1,143 @@
+package xserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// selfSignedCert generates an in-memory certificate for localhost, used in dev mode
+// when TLS is enabled without a certificate
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"xserver dev mode"}, CommonName: "localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(7 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// logRoutes logs the route table of a chi router
+func logRoutes(log *slog.Logger, handler http.Handler) {
+	routes, ok := handler.(chi.Routes)
+	if !ok {
+		return
+	}
+	var b strings.Builder
+	_ = chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		fmt.Fprintf(&b, "%-7s %s\n", method, route)
+		return nil
+	})
+	log.Info("Routes\n" + strings.TrimRight(b.String(), "\n"))
+}
+
+// devHandler pretty prints logs for humans: a colored header line
+// followed by an indented line per attribute
+type devHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	attrs  []slog.Attr
+	prefix string
+}
+
+func newDevHandler(w io.Writer) *devHandler {
+	return &devHandler{mu: &sync.Mutex{}, w: w}
+}
+
+var devLevelColors = map[slog.Level]string{
+	slog.LevelDebug: "\033[90mDBG\033[0m",
+	slog.LevelInfo:  "\033[32mINF\033[0m",
+	slog.LevelWarn:  "\033[33mWRN\033[0m",
+	slog.LevelError: "\033[31mERR\033[0m",
+}
+
+func (h *devHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *devHandler) Handle(_ context.Context, r slog.Record) error {
+	level, ok := devLevelColors[r.Level]
+	if !ok {
+		level = r.Level.String()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\033[90m%s\033[0m %s %s\n", r.Time.Format("15:04:05.000"), level, r.Message)
+	for _, a := range h.attrs {
+		h.appendAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		h.appendAttr(&b, h.prefix, a)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *devHandler) appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	FlattenAttr(prefix, a, func(key string, v slog.Value) {
+		s := v.String()
+		if strings.Contains(s, "\n") {
+			s = "\n        " + strings.ReplaceAll(strings.TrimRight(s, "\r\n"), "\n", "\n        ")
+		}
+		fmt.Fprintf(b, "    \033[36m%s\033[0m=%s\n", key, s)
+	})
+}
+
+func (h *devHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	cp := *h
+	cp.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		FlattenAttr(h.prefix, a, func(key string, v slog.Value) {
+			cp.attrs = append(cp.attrs, slog.Attr{Key: key, Value: v})
+		})
+	}
+	return &cp
+}
+
+func (h *devHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	cp := *h
+	cp.prefix = h.prefix + name + "."
+	return &cp
+}
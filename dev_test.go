@@ -0,0 +1,139 @@
+package xserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDevModeServesSelfSignedTLS(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := Config{Addr: freeAddr(t), TLSEnabled: true, DevMode: true, Timeout: time.Second,
+		LogHandler: newDevHandler(&logs)}
+	r := NewRouter(Config{RateLimit: 10})
+	r.Get("/hello", named("hello"))
+	l := newListener(cfg, r.Mux())
+	served := make(chan error, 1)
+	go func() { served <- l.serve() }()
+
+	var conn *tls.Conn
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if conn, err = tls.Dial("tcp", cfg.Addr, &tls.Config{InsecureSkipVerify: true}); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := conn.ConnectionState().PeerCertificates[0]
+	_ = conn.Close()
+
+	if err := cert.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if err := cert.VerifyHostname("127.0.0.1"); err != nil {
+		t.Error(err)
+	}
+	if cert.Subject.CommonName != "localhost" || time.Until(cert.NotAfter) < 6*24*time.Hour {
+		t.Errorf("certificate for %s until %v", cert.Subject.CommonName, cert.NotAfter)
+	}
+
+	// the generated certificate is trusted like a local CA would be
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + strings.Replace(cfg.Addr, "127.0.0.1", "localhost", 1) + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "hello /hello" {
+		t.Errorf("body %q", body)
+	}
+
+	if err := l.srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+	out := logs.String()
+	for _, want := range []string{"Serving with a self-signed certificate for localhost", "Routes\nGET     /hello\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("the logs miss %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestPanicStackOnlyInDevMode(t *testing.T) {
+	for _, dev := range []bool{false, true} {
+		r := NewRouter(Config{RateLimit: 10, DevMode: dev, LogHandler: slog.NewTextHandler(io.Discard, nil)})
+		r.Get("/panic", func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})
+		rec := httptest.NewRecorder()
+		r.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+		var res panicResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("dev %v: body %q: %v", dev, rec.Body, err)
+		}
+		if rec.Code != http.StatusInternalServerError || res.Error != "Internal Server Error" {
+			t.Errorf("dev %v: %d %+v", dev, rec.Code, res)
+		}
+		if !dev {
+			if res.Panic != "" || res.Stack != nil {
+				t.Errorf("the panic is exposed outside of dev mode: %+v", res)
+			}
+			continue
+		}
+		if res.Panic != "boom" || len(res.Stack) == 0 || !strings.Contains(strings.Join(res.Stack, "\n"), "dev_test.go") {
+			t.Errorf("dev mode response without the panic and its stack: %+v", res)
+		}
+	}
+}
+
+func TestDevHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newDevHandler(&buf)).With("service", "api").WithGroup("req").With("id", 7).WithGroup("")
+
+	log.Info("hello", "status", 200, slog.Group("user", "name", "bob"), "dump", "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+	log.Log(context.Background(), slog.LevelWarn+2, "custom level")
+
+	lines := strings.Split(buf.String(), "\n")
+	// the header line starts with the time
+	for _, i := range []int{0, 8} {
+		if len(lines[i]) < 22 || !strings.HasPrefix(lines[i], "\033[90m") {
+			t.Fatalf("header line %q", lines[i])
+		}
+		lines[i] = lines[i][22:]
+	}
+	want := []string{
+		"\033[32mINF\033[0m hello",
+		"    \033[36mservice\033[0m=api",
+		"    \033[36mreq.id\033[0m=7",
+		"    \033[36mreq.status\033[0m=200",
+		"    \033[36mreq.user.name\033[0m=bob",
+		"    \033[36mreq.dump\033[0m=",
+		"        GET / HTTP/1.1\r",
+		"        Host: a",
+		"WARN+2 custom level",
+		"    \033[36mservice\033[0m=api",
+		"    \033[36mreq.id\033[0m=7",
+		"",
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got\n%q\nwant\n%q", got, strings.Join(want, "\n"))
+	}
+}
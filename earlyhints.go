@@ -0,0 +1,40 @@
+package xserver
+
+import (
+	"net/http"
+)
+
+// Preload formats a Link header value preloading url as the given destination,
+// such as "style", "script" or "font"
+func Preload(url, as string) string {
+	return "<" + url + ">; rel=preload; as=" + as
+}
+
+// EarlyHints sends a 103 Early Hints response with the Link header values, for clients
+// to preload them while the final response is being prepared. The links stay on the
+// final response. Nothing is sent to HTTP/1.0 clients, which do not expect 1xx responses.
+func EarlyHints(w http.ResponseWriter, r *http.Request, links ...string) {
+	if len(links) == 0 || !r.ProtoAtLeast(1, 1) {
+		return
+	}
+	// under a tracing router the hints are sent below the span, so it records the final status
+	if hw, ok := r.Context().Value(hintsWriterKey{}).(http.ResponseWriter); ok {
+		w = hw
+	}
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+}
+
+// WithEarlyHints sends 103 Early Hints with the Link header values before calling the route handler
+func WithEarlyHints(links ...string) RouteOption {
+	return func(o *routeOptions) {
+		o.hints = append(o.hints, links...)
+	}
+}
+
+// informational reports whether code is a 1xx status followed by the final response
+func informational(code int) bool {
+	return code >= 100 && code < 200 && code != http.StatusSwitchingProtocols
+}
@@ -0,0 +1,158 @@
+package xserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// headerWriter records the status codes written and the headers sent with them
+type headerWriter struct {
+	http.ResponseWriter
+	codes []int
+	links [][]string
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	w.codes = append(w.codes, code)
+	w.links = append(w.links, w.Header().Values("Link"))
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func TestEarlyHints(t *testing.T) {
+	css, font := Preload("/app.css", "style"), Preload("/font.woff2", "font")
+	if css != "</app.css>; rel=preload; as=style" {
+		t.Errorf("Preload = %q", css)
+	}
+
+	w := &headerWriter{ResponseWriter: httptest.NewRecorder()}
+	EarlyHints(w, httptest.NewRequest(http.MethodGet, "/", nil), css, font)
+	if len(w.codes) != 1 || w.codes[0] != http.StatusEarlyHints || len(w.links[0]) != 2 {
+		t.Errorf("codes %v links %v, want a 103 with both links", w.codes, w.links)
+	}
+
+	for name, req := range map[string]*http.Request{
+		"no link":  httptest.NewRequest(http.MethodGet, "/", nil),
+		"HTTP/1.0": {Method: http.MethodGet, Proto: "HTTP/1.0", ProtoMajor: 1, ProtoMinor: 0, Header: http.Header{}},
+	} {
+		w := &headerWriter{ResponseWriter: httptest.NewRecorder()}
+		links := []string{css}
+		if name == "no link" {
+			links = nil
+		}
+		EarlyHints(w, req, links...)
+		if len(w.codes) != 0 || w.Header().Get("Link") != "" {
+			t.Errorf("%s: sent %v with %q", name, w.codes, w.Header().Get("Link"))
+		}
+	}
+}
+
+// getWithHints requests url and returns the Link headers of each 103 and the final response
+func getWithHints(t *testing.T, url string) ([][]string, *http.Response) {
+	t.Helper()
+	var hints [][]string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, header.Values("Link"))
+			}
+			return nil
+		},
+	}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, url, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return hints, res
+}
+
+func TestWithEarlyHints(t *testing.T) {
+	r := NewRouter(Config{RateLimit: 10})
+	r.Get("/page", func(w http.ResponseWriter, req *http.Request) {
+		EarlyHints(w, req, Preload("/app.js", "script"))
+		w.WriteHeader(http.StatusCreated)
+	}, WithEarlyHints(Preload("/app.css", "style")))
+	srv := httptest.NewServer(r.Mux())
+	defer srv.Close()
+
+	// the hints go through the access log and the metrics writers
+	hints, res := getWithHints(t, srv.URL+"/page")
+	if len(hints) != 2 || len(hints[0]) != 1 || len(hints[1]) != 2 {
+		t.Errorf("hints = %v, want the route hint then both", hints)
+	}
+	if res.StatusCode != http.StatusCreated || len(res.Header.Values("Link")) != 2 {
+		t.Errorf("final response %d with links %v", res.StatusCode, res.Header.Values("Link"))
+	}
+}
+
+func TestEarlyHintsTraced(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	defer otel.SetTracerProvider(prev)
+
+	r := NewRouterWithTracing(NewRouter(Config{RateLimit: 10}))
+	r.Get("/page", func(w http.ResponseWriter, req *http.Request) {
+		EarlyHints(w, req, Preload("/app.css", "style"))
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(r.Mux())
+	defer srv.Close()
+
+	hints, res := getWithHints(t, srv.URL+"/page")
+	if len(hints) != 1 || res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("hints %v, status %d", hints, res.StatusCode)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("%d spans ended", len(ended))
+	}
+	status := 0
+	for _, kv := range ended[0].Attributes() {
+		if strings.HasSuffix(string(kv.Key), "status_code") {
+			status = int(kv.Value.AsInt64())
+		}
+	}
+	if status != http.StatusInternalServerError || ended[0].Status().Code != codes.Error {
+		t.Errorf("span status %d %v, want the final 500", status, ended[0].Status())
+	}
+}
+
+func TestInformationalStatusNotRecorded(t *testing.T) {
+	under := &headerWriter{ResponseWriter: httptest.NewRecorder()}
+	rw := newResponseWriter(under)
+	rw.WriteHeader(http.StatusEarlyHints)
+	rw.WriteHeader(http.StatusAccepted)
+	if rw.statusCode != http.StatusAccepted || len(under.codes) != 2 {
+		t.Errorf("metrics writer: status %d, sent %v", rw.statusCode, under.codes)
+	}
+
+	under = &headerWriter{ResponseWriter: httptest.NewRecorder()}
+	rec := &recorder{ResponseRecorder: httptest.NewRecorder(), w: under}
+	rec.Header().Set("Link", Preload("/app.css", "style"))
+	rec.WriteHeader(http.StatusEarlyHints)
+	rec.WriteHeader(http.StatusAccepted)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("access log status %d, want 202", rec.Code)
+	}
+	// the 103 is sent right away with its links, the final status waits for the flush
+	if len(under.codes) != 1 || under.codes[0] != http.StatusEarlyHints || len(under.links[0]) != 1 {
+		t.Errorf("sent %v with links %v", under.codes, under.links)
+	}
+	rec.Flush()
+	if len(under.codes) != 2 || under.codes[1] != http.StatusAccepted {
+		t.Errorf("sent %v after the flush", under.codes)
+	}
+}
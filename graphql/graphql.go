@@ -0,0 +1,404 @@
+// Package graphql mounts GraphQL-over-HTTP endpoints on xserver routers. Requests are
+// read before the handler to resolve persisted queries, enforce depth and complexity
+// limits and label the metrics and the spans with the operation name. The handler
+// always receives the query text, in a POST json body or the GET query string.
+package graphql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/l00p8/xserver"
+	"github.com/l00p8/xserver/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	operations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "graphql_operations_total",
+		Help: "Number of GraphQL operations by name, type and outcome.",
+	}, []string{"operation", "type", "outcome"})
+
+	duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "graphql_operation_duration_seconds",
+		Help: "Duration of the GraphQL operations passed to the handler.",
+	}, []string{"operation", "type"})
+)
+
+// Config describes the limits of a GraphQL endpoint
+type Config struct {
+	// MaxDepth is the deepest field nesting of an operation
+	MaxDepth int `envconfig:"graphql_max_depth" mapstructure:"graphql_max_depth" default:"12"`
+	// MaxComplexity bounds the cost of an operation: every field costs 1 plus the
+	// cost of its selections times its largest SizeArguments value
+	MaxComplexity int64 `envconfig:"graphql_max_complexity" mapstructure:"graphql_max_complexity" default:"1000"`
+	// SizeArguments are the arguments giving the length of the lists returned
+	// by a field, first, last and limit when empty
+	SizeArguments []string `envconfig:"graphql_size_arguments" mapstructure:"graphql_size_arguments" default:""`
+	// MaxBodyBytes bounds the POST bodies
+	MaxBodyBytes int64 `envconfig:"graphql_max_body_bytes" mapstructure:"graphql_max_body_bytes" default:"1048576"`
+	// MaxOperationNames bounds the operation names used as metric labels,
+	// the names seen past it are counted as "other"
+	MaxOperationNames int `envconfig:"graphql_max_operation_names" mapstructure:"graphql_max_operation_names" default:"500"`
+	// PersistedOnly rejects the queries missing from Persisted
+	PersistedOnly bool `envconfig:"graphql_persisted_only" mapstructure:"graphql_persisted_only" default:"false"`
+	// Persisted are the trusted queries by id, requested with a documentId
+	// or the sha256 hash of the persistedQuery extension
+	Persisted map[string]string `ignored:"true"`
+	// APQ keeps the automatically persisted queries, they are not supported when nil
+	APQ Store `ignored:"true"`
+	// Authenticator checks the callers, the endpoint is public when nil
+	Authenticator auth.Authenticator `ignored:"true"`
+	// Scopes are required from every caller
+	Scopes []string `ignored:"true"`
+	// RouteOptions are applied to the routes
+	RouteOptions []xserver.RouteOption `ignored:"true"`
+}
+
+// Mount serves the GraphQL handler on path, with GET for queries and POST for all operations
+func Mount(r xserver.Router, path string, h http.Handler, cfg Config) {
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = 12
+	}
+	if cfg.MaxComplexity <= 0 {
+		cfg.MaxComplexity = 1000
+	}
+	if len(cfg.SizeArguments) == 0 {
+		cfg.SizeArguments = []string{"first", "last", "limit"}
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.MaxOperationNames <= 0 {
+		cfg.MaxOperationNames = 500
+	}
+
+	e := &endpoint{cfg: cfg, handler: h, names: map[string]bool{}}
+	fn := e.ServeHTTP
+	if cfg.Authenticator != nil {
+		fn = auth.Handler(cfg.Authenticator, fn, cfg.Scopes...)
+	}
+	r.Get(path, fn, cfg.RouteOptions...)
+	r.Post(path, fn, cfg.RouteOptions...)
+}
+
+// request is a GraphQL-over-HTTP request
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+	DocumentID    string                 `json:"documentId,omitempty"`
+}
+
+// requestError is answered to the client in the errors of a GraphQL response
+type requestError struct {
+	status int
+	code   string
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(msg string) *requestError {
+	return &requestError{status: http.StatusBadRequest, msg: msg}
+}
+
+// invalid rejects a well formed request whose document cannot be executed,
+// such errors are answered 200 to clients accepting application/json only
+func invalid(code, msg string) *requestError {
+	return &requestError{code: code, msg: msg}
+}
+
+type endpoint struct {
+	cfg     Config
+	handler http.Handler
+
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := e.read(w, r)
+	if err != nil {
+		operations.WithLabelValues("unknown", "unknown", "rejected").Inc()
+		writeErrors(w, r, err)
+		return
+	}
+
+	hash := persistedHash(req)
+	query, err := e.resolve(req, hash)
+	if err != nil {
+		operations.WithLabelValues("unknown", "unknown", "rejected").Inc()
+		writeErrors(w, r, err)
+		return
+	}
+
+	op, err := e.check(r, query, req)
+	if err != nil {
+		name, kind := "unknown", "unknown"
+		if op != nil {
+			name, kind = e.label(op.name), op.kind
+		}
+		operations.WithLabelValues(name, kind, "rejected").Inc()
+		writeErrors(w, r, err)
+		return
+	}
+	if hash != "" && req.Query != "" && e.cfg.APQ != nil {
+		e.cfg.APQ.Set(hash, query)
+	}
+
+	name := e.label(op.name)
+	span := trace.SpanFromContext(r.Context())
+	span.SetName(strings.TrimSpace(op.kind + " " + op.name))
+	span.SetAttributes(
+		attribute.String("graphql.operation.type", op.kind),
+		attribute.String("graphql.operation.name", op.name))
+
+	r = forward(r, query, req)
+	rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	timer := prometheus.NewTimer(duration.WithLabelValues(name, op.kind))
+	e.handler.ServeHTTP(rw, r)
+	timer.ObserveDuration()
+
+	outcome := "ok"
+	if rw.status >= 400 {
+		outcome = "error"
+	}
+	operations.WithLabelValues(name, op.kind, outcome).Inc()
+}
+
+// read decodes the request from the query string of a GET or the json body of a POST
+func (e *endpoint) read(w http.ResponseWriter, r *http.Request) (*request, error) {
+	req := &request{}
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		req.DocumentID = q.Get("documentId")
+		for name, dst := range map[string]*map[string]interface{}{"variables": &req.Variables, "extensions": &req.Extensions} {
+			if v := q.Get(name); v != "" {
+				if err := json.Unmarshal([]byte(v), dst); err != nil {
+					return nil, badRequest(name + " is not a json object")
+				}
+			}
+		}
+		return req, nil
+	}
+
+	ct := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/json") && !strings.HasPrefix(ct, "application/graphql-response+json") {
+		return nil, &requestError{status: http.StatusUnsupportedMediaType, msg: "the body must be json"}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, e.cfg.MaxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &requestError{status: http.StatusRequestEntityTooLarge, msg: "the body is too large"}
+		}
+		return nil, badRequest("cannot read the body")
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		return nil, badRequest("batched requests are not supported")
+	}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, badRequest("the body is not a GraphQL request: " + err.Error())
+	}
+	return req, nil
+}
+
+func persistedHash(req *request) string {
+	pq, _ := req.Extensions["persistedQuery"].(map[string]interface{})
+	hash, _ := pq["sha256Hash"].(string)
+	return hash
+}
+
+// resolve returns the query of a request, looking persisted queries up
+func (e *endpoint) resolve(req *request, hash string) (string, error) {
+	switch {
+	case req.DocumentID != "":
+		q, ok := e.cfg.Persisted[req.DocumentID]
+		if !ok {
+			return "", invalid("PERSISTED_QUERY_NOT_FOUND", "unknown documentId")
+		}
+		return q, nil
+	case hash != "" && req.Query == "":
+		if q, ok := e.cfg.Persisted[hash]; ok {
+			return q, nil
+		}
+		if e.cfg.APQ == nil {
+			return "", invalid("PERSISTED_QUERY_NOT_SUPPORTED", "PersistedQueryNotSupported")
+		}
+		if q, ok := e.cfg.APQ.Get(hash); ok && !e.cfg.PersistedOnly {
+			return q, nil
+		}
+		return "", invalid("PERSISTED_QUERY_NOT_FOUND", "PersistedQueryNotFound")
+	case req.Query == "":
+		return "", badRequest("query is required")
+	}
+
+	sum := sha256.Sum256([]byte(req.Query))
+	if hash != "" && !strings.EqualFold(hash, hex.EncodeToString(sum[:])) {
+		return "", badRequest("the sha256Hash does not match the query")
+	}
+	if e.cfg.PersistedOnly {
+		if _, ok := e.cfg.Persisted[hex.EncodeToString(sum[:])]; !ok {
+			return "", invalid("PERSISTED_QUERY_REQUIRED", "only persisted queries are allowed")
+		}
+	}
+	return req.Query, nil
+}
+
+// check parses the query, selects the operation and measures it
+func (e *endpoint) check(r *http.Request, query string, req *request) (*operation, error) {
+	doc, err := parse(query)
+	if err != nil {
+		return nil, invalid("GRAPHQL_PARSE_FAILED", err.Error())
+	}
+
+	var op *operation
+	for i := range doc.operations {
+		o := &doc.operations[i]
+		if req.OperationName == "" || o.name == req.OperationName {
+			if op != nil {
+				return nil, invalid("GRAPHQL_VALIDATION_FAILED", "operationName is required with several operations")
+			}
+			op = o
+		}
+	}
+	if op == nil {
+		return nil, invalid("GRAPHQL_VALIDATION_FAILED", fmt.Sprintf("unknown operation %q", req.OperationName))
+	}
+	if r.Method == http.MethodGet && op.kind != "query" {
+		return op, &requestError{status: http.StatusMethodNotAllowed, msg: op.kind + " operations must be sent with POST"}
+	}
+
+	m, err := newMeasurer(doc, op, req.Variables, e.cfg.SizeArguments).measure(op.selections)
+	if err != nil {
+		return op, invalid("GRAPHQL_VALIDATION_FAILED", err.Error())
+	}
+	if m.depth > e.cfg.MaxDepth {
+		return op, invalid("DEPTH_LIMIT_EXCEEDED", fmt.Sprintf("the operation depth %d exceeds %d", m.depth, e.cfg.MaxDepth))
+	}
+	if m.cost > e.cfg.MaxComplexity {
+		return op, invalid("COMPLEXITY_LIMIT_EXCEEDED", fmt.Sprintf("the operation complexity %d exceeds %d", m.cost, e.cfg.MaxComplexity))
+	}
+	return op, nil
+}
+
+// label bounds the operation names used as metric labels
+func (e *endpoint) label(name string) string {
+	if name == "" {
+		return "anonymous"
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.names[name] {
+		return name
+	}
+	if len(e.names) >= e.cfg.MaxOperationNames {
+		return "other"
+	}
+	e.names[name] = true
+	return name
+}
+
+// forward passes the resolved query to the handler, without the persistedQuery
+// extension handled here
+func forward(r *http.Request, query string, req *request) *http.Request {
+	req.Query = query
+	req.DocumentID = ""
+	if _, ok := req.Extensions["persistedQuery"]; ok {
+		ext := make(map[string]interface{}, len(req.Extensions))
+		for k, v := range req.Extensions {
+			if k != "persistedQuery" {
+				ext[k] = v
+			}
+		}
+		req.Extensions = ext
+	}
+
+	r = r.Clone(r.Context())
+	if r.Method == http.MethodGet {
+		q := url.Values{"query": {query}}
+		if req.OperationName != "" {
+			q.Set("operationName", req.OperationName)
+		}
+		for name, v := range map[string]map[string]interface{}{"variables": req.Variables, "extensions": req.Extensions} {
+			if len(v) > 0 {
+				d, _ := json.Marshal(v)
+				q.Set(name, string(d))
+			}
+		}
+		r.URL.RawQuery = q.Encode()
+		return r
+	}
+
+	d, _ := json.Marshal(req)
+	r.Body = io.NopCloser(bytes.NewReader(d))
+	r.ContentLength = int64(len(d))
+	r.Header.Set("Content-Length", strconv.Itoa(len(d)))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// writeErrors answers a rejected request with a GraphQL response. The clients accepting
+// application/graphql-response+json get the status of the error, the others get the
+// validation errors with 200 as GraphQL-over-HTTP expects.
+func writeErrors(w http.ResponseWriter, r *http.Request, err error) {
+	var re *requestError
+	if !errors.As(err, &re) {
+		re = &requestError{status: http.StatusInternalServerError, msg: err.Error()}
+	}
+
+	ct, status := "application/json", re.status
+	if strings.Contains(r.Header.Get("Accept"), "application/graphql-response+json") {
+		ct = "application/graphql-response+json"
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+	} else if status == 0 {
+		status = http.StatusOK
+	}
+
+	gqlErr := map[string]interface{}{"message": re.msg}
+	if re.code != "" {
+		gqlErr["extensions"] = map[string]string{"code": re.code}
+	}
+	d, _ := json.Marshal(map[string]interface{}{"errors": []interface{}{gqlErr}})
+	if status == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", http.MethodPost)
+	}
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
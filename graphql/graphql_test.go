@@ -0,0 +1,172 @@
+package graphql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/l00p8/xserver"
+)
+
+func hashOf(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// newEndpoint mounts an endpoint whose handler answers the query it received
+func newEndpoint(cfg Config) http.Handler {
+	r := xserver.NewRouter(xserver.Config{RateLimit: 10})
+	Mount(r, "/graphql", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if r.Method == http.MethodGet {
+			req.Query = r.URL.Query().Get("query")
+		} else {
+			_ = json.NewDecoder(r.Body).Decode(&req)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"query": req.Query}})
+	}), cfg)
+	return r.Mux()
+}
+
+type response struct {
+	status int
+	query  string
+	code   string
+}
+
+func post(h http.Handler, body string) response {
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var res struct {
+		Data struct {
+			Query string `json:"query"`
+		} `json:"data"`
+		Errors []struct {
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	data, _ := io.ReadAll(rec.Body)
+	_ = json.Unmarshal(data, &res)
+	r := response{status: rec.Code, query: res.Data.Query}
+	if len(res.Errors) > 0 {
+		r.code = res.Errors[0].Extensions.Code
+	}
+	return r
+}
+
+func TestLimits(t *testing.T) {
+	h := newEndpoint(Config{MaxDepth: 3, MaxComplexity: 100})
+	tests := []struct {
+		name  string
+		query string
+		code  string
+	}{
+		{"accepted", "{ a { b { c } } }", ""},
+		{"parse error", "{ a { b }", "GRAPHQL_PARSE_FAILED"},
+		{"too deep", "{ a { b { c { d } } } }", "DEPTH_LIMIT_EXCEEDED"},
+		{"too deep through a fragment", "{ a { ...F } } fragment F on T { b { c { d } } }", "DEPTH_LIMIT_EXCEEDED"},
+		{"fragment cycle", "{ a { ...F } } fragment F on T { ...F }", "GRAPHQL_VALIDATION_FAILED"},
+		{"too complex", "{ a(first: 50) { b c } }", "COMPLEXITY_LIMIT_EXCEEDED"},
+		{"overflowing sizes", "{ a(first: 99999999999999999999) { b(first: 99999999999999999999) { c } } }", "COMPLEXITY_LIMIT_EXCEEDED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(request{Query: tt.query})
+			res := post(h, string(body))
+			if res.code != tt.code {
+				t.Errorf("code = %q, want %q", res.code, tt.code)
+			}
+		})
+	}
+
+	// a variable size past the float range of int64 is not ignored
+	body, _ := json.Marshal(request{
+		Query:     "query Q($n: Int) { a(first: $n) { b } }",
+		Variables: map[string]interface{}{"n": 1e300},
+	})
+	if res := post(h, string(body)); res.code != "COMPLEXITY_LIMIT_EXCEEDED" {
+		t.Errorf("huge variable: code = %q", res.code)
+	}
+
+	// nor a default size when the variable is not sent
+	body, _ = json.Marshal(request{Query: "query Q($n: Int = 1000000) { items(first: $n) { a b c } }"})
+	if res := post(h, string(body)); res.code != "COMPLEXITY_LIMIT_EXCEEDED" {
+		t.Errorf("default size: code = %q", res.code)
+	}
+}
+
+func TestPersistedQueries(t *testing.T) {
+	trusted := "{ trusted }"
+	apq := "{ automatic }"
+	persisted := map[string]string{"doc1": trusted, hashOf(trusted): trusted}
+	extensions := func(hash string) map[string]interface{} {
+		return map[string]interface{}{"persistedQuery": map[string]interface{}{"version": 1, "sha256Hash": hash}}
+	}
+
+	h := newEndpoint(Config{Persisted: persisted, APQ: NewMemoryStore(10)})
+	steps := []struct {
+		name string
+		req  request
+		res  response
+	}{
+		{"document id", request{DocumentID: "doc1"}, response{status: http.StatusOK, query: trusted}},
+		{"unknown document id", request{DocumentID: "doc2"}, response{status: http.StatusOK, code: "PERSISTED_QUERY_NOT_FOUND"}},
+		{"persisted hash", request{Extensions: extensions(hashOf(trusted))}, response{status: http.StatusOK, query: trusted}},
+		{"apq miss", request{Extensions: extensions(hashOf(apq))}, response{status: http.StatusOK, code: "PERSISTED_QUERY_NOT_FOUND"}},
+		{"apq register", request{Query: apq, Extensions: extensions(hashOf(apq))}, response{status: http.StatusOK, query: apq}},
+		{"apq hit", request{Extensions: extensions(hashOf(apq))}, response{status: http.StatusOK, query: apq}},
+		{"hash mismatch", request{Query: apq, Extensions: extensions(hashOf(trusted))}, response{status: http.StatusBadRequest}},
+		{"no query", request{}, response{status: http.StatusBadRequest}},
+	}
+	for _, step := range steps {
+		body, _ := json.Marshal(step.req)
+		if res := post(h, string(body)); res != step.res {
+			t.Errorf("%s: %+v, want %+v", step.name, res, step.res)
+		}
+	}
+
+	// without a store the clients are told to send the query
+	h = newEndpoint(Config{})
+	body, _ := json.Marshal(request{Extensions: extensions(hashOf(apq))})
+	if res := post(h, string(body)); res.code != "PERSISTED_QUERY_NOT_SUPPORTED" {
+		t.Errorf("without APQ: code = %q", res.code)
+	}
+
+	// only the trusted queries are run, the automatic ones are not registered
+	h = newEndpoint(Config{Persisted: persisted, APQ: NewMemoryStore(10), PersistedOnly: true})
+	for _, step := range []struct {
+		req  request
+		code string
+	}{
+		{request{Query: trusted}, ""},
+		{request{Query: apq}, "PERSISTED_QUERY_REQUIRED"},
+		{request{Query: apq, Extensions: extensions(hashOf(apq))}, "PERSISTED_QUERY_REQUIRED"},
+		{request{Extensions: extensions(hashOf(apq))}, "PERSISTED_QUERY_NOT_FOUND"},
+	} {
+		body, _ := json.Marshal(step.req)
+		if res := post(h, string(body)); res.code != step.code {
+			t.Errorf("persisted only %+v: code = %q, want %q", step.req, res.code, step.code)
+		}
+	}
+}
+
+func TestGetRejectsMutations(t *testing.T) {
+	h := newEndpoint(Config{})
+	req := httptest.NewRequest(http.MethodGet, "/graphql?query="+url.QueryEscape("mutation { a }"), nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("mutation over GET: %d %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,667 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The parser reads just enough of a GraphQL document to tell its operations and
+// measure them, validating the document against the schema is left to the handler.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+	return token{kind: tokEOF, pos: l.pos}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, value: string(c), pos: start}, nil
+	case c == '.':
+		if !strings.HasPrefix(l.src[l.pos:], "...") {
+			return token{}, syntaxError(start, "unexpected .")
+		}
+		l.pos += 3
+		return token{kind: tokPunct, value: "...", pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+	return token{}, syntaxError(start, fmt.Sprintf("unexpected character %q", c))
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	digits := func() {
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	}
+	digits()
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		digits()
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		digits()
+	}
+	return token{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		l.pos += 3
+		for l.pos < len(l.src) {
+			switch {
+			case strings.HasPrefix(l.src[l.pos:], `\"""`):
+				l.pos += 4
+			case strings.HasPrefix(l.src[l.pos:], `"""`):
+				l.pos += 3
+				return token{kind: tokString, value: l.src[start+3 : l.pos-3], pos: start}, nil
+			default:
+				l.pos++
+			}
+		}
+		return token{}, syntaxError(start, "unterminated string")
+	}
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+		case '"':
+			l.pos++
+			return token{kind: tokString, value: l.src[start+1 : l.pos-1], pos: start}, nil
+		case '\n', '\r':
+			return token{}, syntaxError(start, "unterminated string")
+		default:
+			l.pos++
+		}
+	}
+	return token{}, syntaxError(start, "unterminated string")
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func syntaxError(pos int, msg string) error {
+	return fmt.Errorf("syntax error at %d: %s", pos, msg)
+}
+
+// value is an argument value, only integers and variables are kept
+// as they are all the measures need
+type value struct {
+	variable string
+	number   int64
+	isNumber bool
+}
+
+type selection struct {
+	// field
+	name       string
+	arguments  map[string]value
+	selections []selection
+	// fragment spread, the inline fragments keep their selections
+	fragment string
+}
+
+type operation struct {
+	kind       string
+	name       string
+	selections []selection
+	// defaults are the default values of the variables
+	defaults map[string]value
+}
+
+type document struct {
+	operations []operation
+	fragments  map[string][]selection
+}
+
+// maxNesting bounds the nesting of selection sets and values, the parser recurses into them
+const maxNesting = 256
+
+type parser struct {
+	lex     *lexer
+	tok     token
+	nesting int
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lex: &lexer{src: strings.TrimPrefix(src, "\uFEFF")}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: map[string][]selection{}}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.is(tokPunct, "{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, operation{kind: "query", selections: sel})
+		case p.is(tokName, "query"), p.is(tokName, "mutation"), p.is(tokName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.is(tokName, "fragment"):
+			name, sel, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined twice", name)
+			}
+			doc.fragments[name] = sel
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no operation")
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) is(kind tokenKind, v string) bool {
+	return p.tok.kind == kind && p.tok.value == v
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return syntaxError(p.tok.pos, "unexpected end of document")
+	}
+	return syntaxError(p.tok.pos, fmt.Sprintf("unexpected %q", p.tok.value))
+}
+
+func (p *parser) expect(kind tokenKind, v string) error {
+	if !p.is(kind, v) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (operation, error) {
+	op := operation{kind: p.tok.value}
+	if err := p.advance(); err != nil {
+		return op, err
+	}
+	if p.tok.kind == tokName {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return op, err
+		}
+	}
+	if p.is(tokPunct, "(") {
+		var err error
+		if op.defaults, err = p.variableDefinitions(); err != nil {
+			return op, err
+		}
+	}
+	if err := p.directives(); err != nil {
+		return op, err
+	}
+	sel, err := p.selectionSet()
+	op.selections = sel
+	return op, err
+}
+
+func (p *parser) variableDefinitions() (map[string]value, error) {
+	if err := p.expect(tokPunct, "("); err != nil {
+		return nil, err
+	}
+	defaults := map[string]value{}
+	for !p.is(tokPunct, ")") {
+		if err := p.expect(tokPunct, "$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+		if err := p.typeRef(); err != nil {
+			return nil, err
+		}
+		if p.is(tokPunct, "=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if defaults[name], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.directives(); err != nil {
+			return nil, err
+		}
+	}
+	return defaults, p.advance()
+}
+
+func (p *parser) typeRef() error {
+	if err := p.nest(); err != nil {
+		return err
+	}
+	defer func() { p.nesting-- }()
+	if p.is(tokPunct, "[") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.typeRef(); err != nil {
+			return err
+		}
+		if err := p.expect(tokPunct, "]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	if p.is(tokPunct, "!") {
+		return p.advance()
+	}
+	return nil
+}
+
+func (p *parser) fragment() (string, []selection, error) {
+	if err := p.advance(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "on" {
+		return "", nil, syntaxError(p.tok.pos, "a fragment cannot be named on")
+	}
+	if err := p.expect(tokName, "on"); err != nil {
+		return "", nil, err
+	}
+	if _, err := p.name(); err != nil {
+		return "", nil, err
+	}
+	if err := p.directives(); err != nil {
+		return "", nil, err
+	}
+	sel, err := p.selectionSet()
+	return name, sel, err
+}
+
+func (p *parser) directives() error {
+	for p.is(tokPunct, "@") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if p.is(tokPunct, "(") {
+			if _, err := p.arguments(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) nest() error {
+	p.nesting++
+	if p.nesting > maxNesting {
+		return syntaxError(p.tok.pos, "the document is nested too deep")
+	}
+	return nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { p.nesting-- }()
+	if err := p.expect(tokPunct, "{"); err != nil {
+		return nil, err
+	}
+	var res []selection
+	for !p.is(tokPunct, "}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sel)
+	}
+	if len(res) == 0 {
+		return nil, syntaxError(p.tok.pos, "empty selection set")
+	}
+	return res, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	if p.is(tokPunct, "...") {
+		return p.spread()
+	}
+	var sel selection
+	name, err := p.name()
+	if err != nil {
+		return sel, err
+	}
+	if p.is(tokPunct, ":") {
+		if err := p.advance(); err != nil {
+			return sel, err
+		}
+		if name, err = p.name(); err != nil {
+			return sel, err
+		}
+	}
+	sel.name = name
+	if p.is(tokPunct, "(") {
+		if sel.arguments, err = p.arguments(); err != nil {
+			return sel, err
+		}
+	}
+	if err := p.directives(); err != nil {
+		return sel, err
+	}
+	if p.is(tokPunct, "{") {
+		sel.selections, err = p.selectionSet()
+	}
+	return sel, err
+}
+
+func (p *parser) spread() (selection, error) {
+	var sel selection
+	if err := p.advance(); err != nil {
+		return sel, err
+	}
+	if p.tok.kind == tokName && p.tok.value != "on" {
+		sel.fragment = p.tok.value
+		if err := p.advance(); err != nil {
+			return sel, err
+		}
+		return sel, p.directives()
+	}
+	if p.is(tokName, "on") {
+		if err := p.advance(); err != nil {
+			return sel, err
+		}
+		if _, err := p.name(); err != nil {
+			return sel, err
+		}
+	}
+	if err := p.directives(); err != nil {
+		return sel, err
+	}
+	var err error
+	sel.selections, err = p.selectionSet()
+	return sel, err
+}
+
+func (p *parser) arguments() (map[string]value, error) {
+	if err := p.expect(tokPunct, "("); err != nil {
+		return nil, err
+	}
+	args := map[string]value{}
+	for !p.is(tokPunct, ")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.advance()
+}
+
+func (p *parser) value() (value, error) {
+	var v value
+	if err := p.nest(); err != nil {
+		return v, err
+	}
+	defer func() { p.nesting-- }()
+	switch {
+	case p.is(tokPunct, "$"):
+		if err := p.advance(); err != nil {
+			return v, err
+		}
+		name, err := p.name()
+		v.variable = name
+		return v, err
+	case p.tok.kind == tokInt:
+		n, err := strconv.ParseInt(p.tok.value, 10, 64)
+		// the values out of range saturate
+		if err == nil || errors.Is(err, strconv.ErrRange) {
+			v.number, v.isNumber = n, true
+		}
+		return v, p.advance()
+	case p.tok.kind == tokFloat, p.tok.kind == tokString, p.tok.kind == tokName:
+		return v, p.advance()
+	case p.is(tokPunct, "["):
+		if err := p.advance(); err != nil {
+			return v, err
+		}
+		for !p.is(tokPunct, "]") {
+			if _, err := p.value(); err != nil {
+				return v, err
+			}
+		}
+		return v, p.advance()
+	case p.is(tokPunct, "{"):
+		if err := p.advance(); err != nil {
+			return v, err
+		}
+		for !p.is(tokPunct, "}") {
+			if _, err := p.name(); err != nil {
+				return v, err
+			}
+			if err := p.expect(tokPunct, ":"); err != nil {
+				return v, err
+			}
+			if _, err := p.value(); err != nil {
+				return v, err
+			}
+		}
+		return v, p.advance()
+	}
+	return v, p.unexpected()
+}
+
+// maxCost saturates the complexity of the operations, multipliers could overflow it
+const maxCost = 1 << 40
+
+type measure struct {
+	depth int
+	cost  int64
+}
+
+// measurer computes the depth and the complexity of the operations of a document,
+// a field costs 1 plus its selections times its list size argument
+type measurer struct {
+	doc       *document
+	defaults  map[string]value
+	variables map[string]interface{}
+	sizeArgs  []string
+	fragments map[string]measure
+	visiting  map[string]bool
+}
+
+func newMeasurer(doc *document, op *operation, variables map[string]interface{}, sizeArgs []string) *measurer {
+	return &measurer{
+		doc:       doc,
+		defaults:  op.defaults,
+		variables: variables,
+		sizeArgs:  sizeArgs,
+		fragments: map[string]measure{},
+		visiting:  map[string]bool{},
+	}
+}
+
+func (m *measurer) measure(sels []selection) (measure, error) {
+	var res measure
+	for _, s := range sels {
+		var sub measure
+		var err error
+		switch {
+		case s.fragment != "":
+			sub, err = m.fragment(s.fragment)
+		case s.name == "":
+			sub, err = m.measure(s.selections)
+		case s.name == "__typename":
+			continue
+		default:
+			sub, err = m.measure(s.selections)
+			sub.depth++
+			sub.cost = min(1+mulCost(sub.cost, m.size(s.arguments)), maxCost)
+		}
+		if err != nil {
+			return res, err
+		}
+		res.depth = max(res.depth, sub.depth)
+		res.cost = min(res.cost+sub.cost, maxCost)
+	}
+	return res, nil
+}
+
+// mulCost multiplies costs of at most maxCost, saturating at maxCost before the product overflows
+func mulCost(cost, size int64) int64 {
+	if size > 0 && cost > maxCost/size {
+		return maxCost
+	}
+	return min(cost*size, maxCost)
+}
+
+// fragment measures a fragment once however many times it is spread
+func (m *measurer) fragment(name string) (measure, error) {
+	if res, ok := m.fragments[name]; ok {
+		return res, nil
+	}
+	sels, ok := m.doc.fragments[name]
+	if !ok {
+		return measure{}, fmt.Errorf("unknown fragment %q", name)
+	}
+	if m.visiting[name] {
+		return measure{}, fmt.Errorf("fragment %q spreads itself", name)
+	}
+	m.visiting[name] = true
+	res, err := m.measure(sels)
+	delete(m.visiting, name)
+	if err == nil {
+		m.fragments[name] = res
+	}
+	return res, err
+}
+
+// size is the largest list size argument of a field, 1 without one.
+// The variables missing from the request take their default value
+// and the sizes are bounded by maxCost.
+func (m *measurer) size(args map[string]value) int64 {
+	size := int64(1)
+	for _, name := range m.sizeArgs {
+		v, ok := args[name]
+		if !ok {
+			continue
+		}
+		if v.variable != "" {
+			if _, sent := m.variables[v.variable]; !sent {
+				v = m.defaults[v.variable]
+			}
+		}
+		n := v.number
+		if v.variable != "" {
+			f, ok := m.variables[v.variable].(float64)
+			if !ok || math.IsNaN(f) {
+				continue
+			}
+			// converting a float past the int64 range is undefined
+			n = int64(max(0, min(f, maxCost)))
+		} else if !v.isNumber {
+			continue
+		}
+		size = max(size, min(n, maxCost))
+	}
+	return size
+}
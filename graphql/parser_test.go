@@ -0,0 +1,110 @@
+package graphql
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"unclosed selection", "{ a { b }"},
+		{"unclosed string", `{ a(s: "x) }`},
+		{"missing argument value", "{ a(first:) }"},
+		{"unknown definition", "subscriptionx { a }"},
+		{"fragment without type", "fragment F { a }"},
+		{"empty", ""},
+		{"nesting", strings.Repeat("{ a ", 300) + strings.Repeat("}", 300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parse(tt.query); err == nil {
+				t.Errorf("parse(%q) succeeded", tt.query)
+			}
+		})
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	sizeArgs := []string{"first", "last", "limit"}
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		depth     int
+		cost      int64
+		err       string
+	}{
+		{"flat", "{ a b __typename }", nil, 1, 2, ""},
+		{"nested", "{ a { b { c } } }", nil, 3, 3, ""},
+		{"list", "{ users(first: 10) { name email } }", nil, 2, 21, ""},
+		{"largest size", "{ users(first: 10, last: 50) { name } }", nil, 2, 51, ""},
+		{"variable size", "query Q($n: Int) { users(first: $n) { name } }", map[string]interface{}{"n": 5.0}, 2, 6, ""},
+		{"missing variable", "query Q($n: Int) { users(first: $n) { name } }", nil, 2, 2, ""},
+		{"default size", "query Q($n: Int = 20) { users(first: $n) { name } }", nil, 2, 21, ""},
+		{"sent variable over the default", "query Q($n: Int = 20) { users(first: $n) { name } }", map[string]interface{}{"n": 5.0}, 2, 6, ""},
+		{"null variable over the default", "query Q($n: Int = 20) { users(first: $n) { name } }", map[string]interface{}{"n": nil}, 2, 2, ""},
+		{"negative size", "{ users(first: -5) { name } }", nil, 2, 2, ""},
+		{"inline fragment", "{ node { ... on User { name } } }", nil, 2, 2, ""},
+		{"fragment", "{ a { ...F } b { ...F } } fragment F on T { x { y } }", nil, 3, 6, ""},
+		{"unknown fragment", "{ a { ...F } }", nil, 0, 0, `unknown fragment "F"`},
+		{"fragment cycle", "{ a { ...F } } fragment F on T { b { ...G } } fragment G on T { ...F }", nil, 0, 0, `fragment "F" spreads itself`},
+		{"self spread", "{ ...F } fragment F on Query { a { ...F } }", nil, 0, 0, `fragment "F" spreads itself`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := newMeasurer(doc, &doc.operations[0], tt.variables, sizeArgs).measure(doc.operations[0].selections)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.depth != tt.depth || m.cost != tt.cost {
+				t.Errorf("depth %d cost %d, want %d %d", m.depth, m.cost, tt.depth, tt.cost)
+			}
+		})
+	}
+}
+
+func TestMeasureSaturates(t *testing.T) {
+	sizeArgs := []string{"first"}
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+	}{
+		{"literal sizes", "{ a(first: 1000000) { b(first: 1000000) { c(first: 1000000) { d } } } }", nil},
+		{"out of range literal", "{ a(first: 99999999999999999999) { b } }", nil},
+		{"huge default", "query Q($n: Int = 99999999999999999999) { a(first: $n) { b } }", nil},
+		{"huge variable", "query Q($n: Int) { a(first: $n) { b } }", map[string]interface{}{"n": 1e300}},
+		{"infinite variable", "query Q($n: Int) { a(first: $n) { b } }", map[string]interface{}{"n": math.Inf(1)}},
+		{"product past int64", "query Q($n: Int) { a(first: $n) { b(first: $n) { c(first: $n) { d } } } }", map[string]interface{}{"n": 9e18}},
+		{"overflowing product", "query Q($n: Int) { a(first: $n) { b(first: $n) { c } } }", map[string]interface{}{"n": 3e12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parse(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := newMeasurer(doc, &doc.operations[0], tt.variables, sizeArgs).measure(doc.operations[0].selections)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.cost != maxCost {
+				t.Errorf("cost = %d, want the saturated cost %d", m.cost, maxCost)
+			}
+		})
+	}
+}
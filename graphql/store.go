@@ -0,0 +1,61 @@
+package graphql
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Store keeps the automatically persisted queries by their sha256 hash
+type Store interface {
+	Get(hash string) (string, bool)
+	Set(hash, query string)
+}
+
+type memoryEntry struct {
+	hash  string
+	query string
+}
+
+// MemoryStore is an in-memory store evicting the least recently used queries past its size
+type MemoryStore struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryStore creates a store holding up to maxEntries queries
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Get(hash string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[hash]
+	if !ok {
+		return "", false
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*memoryEntry).query, true
+}
+
+func (s *MemoryStore) Set(hash, query string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[hash]; ok {
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.entries[hash] = s.lru.PushFront(&memoryEntry{hash: hash, query: query})
+	for s.lru.Len() > s.maxEntries {
+		el := s.lru.Back()
+		s.lru.Remove(el)
+		delete(s.entries, el.Value.(*memoryEntry).hash)
+	}
+}
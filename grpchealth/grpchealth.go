@@ -0,0 +1,147 @@
+// Package grpchealth exposes xserver health checks through the grpc.health.v1 protocol.
+package grpchealth
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/l00p8/xserver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Config describes the health bridge
+type Config struct {
+	// Addr of a dedicated gRPC listener, leave it empty to Register the bridge on an existing grpc.Server
+	Addr string `envconfig:"grpc_health_addr" mapstructure:"grpc_health_addr" default:""`
+	// Interval between two runs of the health checks
+	Interval time.Duration `envconfig:"grpc_health_interval" mapstructure:"grpc_health_interval" default:"5s"`
+}
+
+// Bridge runs the Healthers periodically and publishes their status per service name,
+// the overall status under the empty service name is serving when all services are.
+// Check and Watch are answered by the standard grpc health server. Passed to
+// xserver.Listen, it reports not serving from the termination signal on.
+type Bridge struct {
+	cfg      Config
+	services map[string][]xserver.Healther
+	health   *health.Server
+	grpc     *grpc.Server
+	started  bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New creates a bridge for the healthers by service name, the empty name is reserved for the overall status
+func New(cfg Config, services map[string][]xserver.Healther) *Bridge {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	b := &Bridge{
+		cfg:      cfg,
+		services: services,
+		health:   health.NewServer(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	b.check()
+	return b
+}
+
+// Register adds the health service to a gRPC server
+func (b *Bridge) Register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, b.health)
+}
+
+// Start runs the checks in the background and serves them on Config.Addr when set
+func (b *Bridge) Start() error {
+	if b.cfg.Addr != "" {
+		lis, err := net.Listen("tcp", b.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		b.grpc = grpc.NewServer()
+		b.Register(b.grpc)
+		go func() {
+			_ = b.grpc.Serve(lis)
+		}()
+	}
+	b.started = true
+	go b.loop()
+	return nil
+}
+
+func (b *Bridge) loop() {
+	defer close(b.done)
+	t := time.NewTicker(b.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			b.check()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+func (b *Bridge) check() {
+	names := make([]string, 0, len(b.services))
+	for name := range b.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	overall := healthpb.HealthCheckResponse_SERVING
+	for _, name := range names {
+		status := healthpb.HealthCheckResponse_SERVING
+		for _, h := range b.services[name] {
+			if err := h.Health(); err != nil {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+				overall = status
+				break
+			}
+		}
+		b.health.SetServingStatus(name, status)
+	}
+	b.health.SetServingStatus("", overall)
+}
+
+// Drain reports every service as not serving as soon as the server receives a termination
+// signal, the later checks do not change the statuses anymore
+func (b *Bridge) Drain() {
+	b.health.Shutdown()
+}
+
+// Shutdown reports every service as not serving, then stops the dedicated listener
+func (b *Bridge) Shutdown(ctx context.Context) error {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+		if b.started {
+			<-b.done
+		}
+		b.health.Shutdown()
+	})
+	if b.grpc == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		b.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		b.grpc.Stop()
+		return ctx.Err()
+	}
+}
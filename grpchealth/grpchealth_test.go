@@ -0,0 +1,58 @@
+package grpchealth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/l00p8/xserver"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type healther struct {
+	err error
+}
+
+func (h *healther) Health() error {
+	return h.err
+}
+
+func status(t *testing.T, b *Bridge, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	res, err := b.health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Status
+}
+
+func TestBridge(t *testing.T) {
+	db := &healther{}
+	b := New(Config{}, map[string][]xserver.Healther{"db": {db}, "cache": {&healther{}}})
+
+	if s := status(t, b, ""); s != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("overall = %s, want SERVING", s)
+	}
+
+	db.err = errors.New("down")
+	b.check()
+	if s := status(t, b, "db"); s != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("db = %s, want NOT_SERVING", s)
+	}
+	if s := status(t, b, "cache"); s != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("cache = %s, want SERVING", s)
+	}
+	if s := status(t, b, ""); s != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("overall = %s, want NOT_SERVING", s)
+	}
+
+	// the drain is final, the next checks keep every service not serving
+	db.err = nil
+	b.Drain()
+	b.check()
+	for _, service := range []string{"", "db", "cache"} {
+		if s := status(t, b, service); s != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("%q after Drain = %s, want NOT_SERVING", service, s)
+		}
+	}
+}
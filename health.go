@@ -1,6 +1,7 @@
 package xserver
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 )
@@ -9,14 +10,22 @@ type Healther interface {
 	Health() error
 }
 
+func checkHealth(ctx context.Context, healthers []Healther) []string {
+	var errs []string
+	if ShuttingDown(ctx) {
+		errs = append(errs, "server is shutting down")
+	}
+	for _, h := range healthers {
+		if err := h.Health(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return errs
+}
+
 func healthHandler(healthers ...Healther) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var errs []string
-		for _, h := range healthers {
-			if err := h.Health(); err != nil {
-				errs = append(errs, err.Error())
-			}
-		}
+		errs := checkHealth(r.Context(), healthers)
 		if len(errs) > 0 {
 			d, err := json.Marshal(errs)
 			if err != nil {
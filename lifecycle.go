@@ -0,0 +1,69 @@
+package xserver
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Lifecycle is a component started with the server and shut down with it,
+// Shutdown is called after the http listeners stopped accepting requests
+type Lifecycle interface {
+	Start() error
+	Shutdown(ctx context.Context) error
+}
+
+// Drainer is a component told as soon as the server receives a termination signal,
+// before the listeners stop accepting requests
+type Drainer interface {
+	Drain()
+}
+
+// serverState is the shutdown state of a running server, its requests carry it in their context
+type serverState struct {
+	shuttingDown atomic.Bool
+	drainOnce    sync.Once
+	// draining is closed with the termination signal, so waiting handlers return early
+	draining chan struct{}
+	// work is the work detached from the requests of the server
+	work *workTracker
+}
+
+func newServerState(log *slog.Logger) *serverState {
+	return &serverState{draining: make(chan struct{}), work: newWorkTracker(log)}
+}
+
+type serverStateKey struct{}
+
+func (s *serverState) context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serverStateKey{}, s)
+}
+
+// ShuttingDown reports whether the server serving the request of ctx received a
+// termination signal, health checks report unhealthy from then on
+func ShuttingDown(ctx context.Context) bool {
+	s, ok := ctx.Value(serverStateKey{}).(*serverState)
+	return ok && s.shuttingDown.Load()
+}
+
+// Draining returns a channel closed when the server serving the request of ctx receives
+// a termination signal, so handlers holding requests open can return early. The channel
+// of a context outside of a server is never closed.
+func Draining(ctx context.Context) <-chan struct{} {
+	if s, ok := ctx.Value(serverStateKey{}).(*serverState); ok {
+		return s.draining
+	}
+	return nil
+}
+
+// beginShutdown flags the server as shutting down and tells the Drainer components
+func (s *serverState) beginShutdown(components []Lifecycle) {
+	s.shuttingDown.Store(true)
+	s.drainOnce.Do(func() { close(s.draining) })
+	for _, c := range components {
+		if d, ok := c.(Drainer); ok {
+			d.Drain()
+		}
+	}
+}
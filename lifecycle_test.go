@@ -0,0 +1,35 @@
+package xserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShuttingDownPerServer(t *testing.T) {
+	stopping, running := newServerState(slog.Default()), newServerState(slog.Default())
+	stopping.beginShutdown(nil)
+
+	if !ShuttingDown(stopping.context(context.Background())) {
+		t.Error("the stopping server is not shutting down")
+	}
+	if ShuttingDown(running.context(context.Background())) {
+		t.Error("the running server is shutting down")
+	}
+	if ShuttingDown(context.Background()) {
+		t.Error("a request outside of a server is shutting down")
+	}
+
+	r := NewRouter(Config{RateLimit: 10})
+	r.Healthers()
+	for state, want := range map[*serverState]int{stopping: http.StatusServiceUnavailable, running: http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/_health", nil)
+		rec := httptest.NewRecorder()
+		r.Mux().ServeHTTP(rec, req.WithContext(state.context(req.Context())))
+		if rec.Code != want {
+			t.Errorf("health status = %d, want %d", rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package xserver
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	logger "github.com/l00p8/log"
+)
+
+// devLogHandler is shared by the dev mode loggers to keep their lines apart
+var devLogHandler = newDevHandler(os.Stderr)
+
+// Slog returns the structured logger of the config: LogHandler when set, pretty printed
+// logs in dev mode, otherwise Logger wrapped in a slog handler or the default slog logger.
+// Records are copied to LogBridge when set, without the request and response dumps.
+func (cfg Config) Slog() *slog.Logger {
+	var h slog.Handler
+	switch {
+	case cfg.LogHandler != nil:
+		h = cfg.LogHandler
+	case cfg.DevMode:
+		h = devLogHandler
+	case cfg.Logger != nil:
+		h = NewLegacyHandler(cfg.Logger)
+	default:
+		h = slog.Default().Handler()
+	}
+	if cfg.LogBridge != nil {
+		h = teeHandler{h, withoutDumps{cfg.LogBridge}}
+	}
+	return slog.New(h)
+}
+
+// teeHandler sends records to every handler enabled for their level
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if herr := h.Handle(ctx, r.Clone()); herr != nil && err == nil {
+			err = herr
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	res := make(teeHandler, len(t))
+	for i, h := range t {
+		res[i] = h.WithAttrs(attrs)
+	}
+	return res
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	res := make(teeHandler, len(t))
+	for i, h := range t {
+		res[i] = h.WithGroup(name)
+	}
+	return res
+}
+
+// legacyHandler writes slog records to a string based github.com/l00p8/log Logger,
+// attributes are appended to the message as key=value pairs
+type legacyHandler struct {
+	log    logger.Logger
+	attrs  string
+	prefix string
+}
+
+// NewLegacyHandler adapts a github.com/l00p8/log Logger to slog
+func NewLegacyHandler(log logger.Logger) slog.Handler {
+	return &legacyHandler{log: log}
+}
+
+func (h *legacyHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *legacyHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+
+	switch {
+	case r.Level < slog.LevelInfo:
+		h.log.Debug(b.String())
+	case r.Level < slog.LevelError:
+		h.log.Info(b.String())
+	default:
+		h.log.Error(b.String())
+	}
+	return nil
+}
+
+func (h *legacyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+	return &legacyHandler{log: h.log, attrs: b.String(), prefix: h.prefix}
+}
+
+func (h *legacyHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &legacyHandler{log: h.log, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	FlattenAttr(prefix, a, func(key string, v slog.Value) {
+		s := v.String()
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(" " + key + "=" + s)
+	})
+}
+
+// FlattenAttr resolves an attribute and calls fn for each of its values, the keys
+// of groups are joined with dots after prefix. It is shared by the handlers writing
+// to flat key value loggers.
+func FlattenAttr(prefix string, a slog.Attr, fn func(key string, v slog.Value)) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			FlattenAttr(prefix, ga, fn)
+		}
+		return
+	}
+	fn(prefix+a.Key, a.Value)
+}
@@ -0,0 +1,40 @@
+package xserver
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type lazyValue string
+
+func (v lazyValue) LogValue() slog.Value {
+	return slog.StringValue(string(v))
+}
+
+func TestFlattenAttr(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		attr   slog.Attr
+		want   string
+	}{
+		{"plain", "", slog.Int("n", 1), "n=1"},
+		{"prefixed", "req.", slog.String("id", "a"), "req.id=a"},
+		{"group", "", slog.Group("http", slog.Int("status", 200), slog.Group("req", slog.String("method", "GET"))),
+			"http.status=200 http.req.method=GET"},
+		{"inline group", "p.", slog.Attr{Key: "", Value: slog.GroupValue(slog.Bool("ok", true))}, "p.ok=true"},
+		{"empty group", "", slog.Group("none"), ""},
+		{"empty attr", "", slog.Attr{}, ""},
+		{"resolved", "", slog.Any("lazy", lazyValue("v")), "lazy=v"},
+	}
+	for _, tt := range tests {
+		var got []string
+		FlattenAttr(tt.prefix, tt.attr, func(key string, v slog.Value) {
+			got = append(got, key+"="+v.String())
+		})
+		if s := strings.Join(got, " "); s != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, s, tt.want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+// Package logadapter provides slog handlers writing to zap and zerolog loggers,
+// to be used as xserver Config.LogHandler.
+package logadapter
@@ -0,0 +1,92 @@
+package logadapter
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/l00p8/xserver"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type zapHandler struct {
+	log    *zap.Logger
+	prefix string
+}
+
+// Zap returns a slog handler writing to a zap logger
+func Zap(log *zap.Logger) slog.Handler {
+	return &zapHandler{log: log}
+}
+
+func zapLevel(l slog.Level) zapcore.Level {
+	switch {
+	case l < slog.LevelInfo:
+		return zapcore.DebugLevel
+	case l < slog.LevelWarn:
+		return zapcore.InfoLevel
+	case l < slog.LevelError:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+func zapFields(prefix string, attrs ...slog.Attr) []zap.Field {
+	fields := make([]zap.Field, 0, len(attrs))
+	for _, a := range attrs {
+		xserver.FlattenAttr(prefix, a, func(key string, v slog.Value) {
+			switch v.Kind() {
+			case slog.KindString:
+				fields = append(fields, zap.String(key, v.String()))
+			case slog.KindInt64:
+				fields = append(fields, zap.Int64(key, v.Int64()))
+			case slog.KindUint64:
+				fields = append(fields, zap.Uint64(key, v.Uint64()))
+			case slog.KindFloat64:
+				fields = append(fields, zap.Float64(key, v.Float64()))
+			case slog.KindBool:
+				fields = append(fields, zap.Bool(key, v.Bool()))
+			case slog.KindDuration:
+				fields = append(fields, zap.Duration(key, v.Duration()))
+			case slog.KindTime:
+				fields = append(fields, zap.Time(key, v.Time()))
+			default:
+				fields = append(fields, zap.Any(key, v.Any()))
+			}
+		})
+	}
+	return fields
+}
+
+func (h *zapHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return h.log.Core().Enabled(zapLevel(l))
+}
+
+func (h *zapHandler) Handle(_ context.Context, r slog.Record) error {
+	ce := h.log.Check(zapLevel(r.Level), r.Message)
+	if ce == nil {
+		return nil
+	}
+	if !r.Time.IsZero() {
+		ce.Time = r.Time
+	}
+	fields := make([]zap.Field, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		fields = append(fields, zapFields(h.prefix, a)...)
+		return true
+	})
+	ce.Write(fields...)
+	return nil
+}
+
+func (h *zapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &zapHandler{log: h.log.With(zapFields(h.prefix, attrs...)...), prefix: h.prefix}
+}
+
+func (h *zapHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &zapHandler{log: h.log, prefix: h.prefix + name + "."}
+}
@@ -0,0 +1,87 @@
+package logadapter
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestZapLevels(t *testing.T) {
+	tests := []struct {
+		level   slog.Level
+		want    zapcore.Level
+		enabled bool
+	}{
+		{slog.LevelDebug - 4, zapcore.DebugLevel, false},
+		{slog.LevelDebug, zapcore.DebugLevel, false},
+		{slog.LevelInfo, zapcore.InfoLevel, true},
+		{slog.LevelInfo + 2, zapcore.InfoLevel, true},
+		{slog.LevelWarn, zapcore.WarnLevel, true},
+		{slog.LevelError, zapcore.ErrorLevel, true},
+		{slog.LevelError + 4, zapcore.ErrorLevel, true},
+	}
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := Zap(zap.New(core))
+	for _, tt := range tests {
+		if got := zapLevel(tt.level); got != tt.want {
+			t.Errorf("%v: level %v, want %v", tt.level, got, tt.want)
+		}
+		if got := h.Enabled(context.Background(), tt.level); got != tt.enabled {
+			t.Errorf("%v: enabled %v, want %v", tt.level, got, tt.enabled)
+		}
+		_ = h.Handle(context.Background(), slog.NewRecord(time.Time{}, tt.level, tt.level.String(), 0))
+		if entries := logs.TakeAll(); (len(entries) != 0) != tt.enabled {
+			t.Errorf("%v: %d entries written", tt.level, len(entries))
+		} else if tt.enabled && entries[0].Level != tt.want {
+			t.Errorf("%v: written at %v", tt.level, entries[0].Level)
+		}
+	}
+}
+
+func TestZapFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	log := slog.New(Zap(zap.New(core))).With("service", "api").WithGroup("req").With("id", 7).WithGroup("")
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	log.Info("hello",
+		"status", 200,
+		slog.Group("user", "name", "bob", slog.Group("role", "admin", true)),
+		slog.Uint64("bytes", 12),
+		slog.Float64("ratio", 0.5),
+		slog.Duration("took", time.Second),
+		slog.Time("at", at),
+		slog.Any("tags", []string{"a"}))
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 || entries[0].Message != "hello" || entries[0].Level != zapcore.InfoLevel {
+		t.Fatalf("entries %+v", entries)
+	}
+	want := map[string]interface{}{
+		"service":             "api",
+		"req.id":              int64(7),
+		"req.status":          int64(200),
+		"req.user.name":       "bob",
+		"req.user.role.admin": true,
+		"req.bytes":           uint64(12),
+		"req.ratio":           0.5,
+		"req.took":            time.Second,
+		"req.at":              at,
+		"req.tags":            []interface{}{"a"},
+	}
+	if got := entries[0].ContextMap(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fields %#v, want %#v", got, want)
+	}
+
+	// the record time is kept
+	r := slog.NewRecord(at, slog.LevelWarn, "dated", 0)
+	_ = Zap(zap.New(core)).Handle(context.Background(), r)
+	if entries := logs.TakeAll(); len(entries) != 1 || !entries[0].Time.Equal(at) {
+		t.Errorf("entries %+v, want the record time", entries)
+	}
+}
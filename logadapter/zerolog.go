@@ -0,0 +1,74 @@
+package logadapter
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/l00p8/xserver"
+	"github.com/rs/zerolog"
+)
+
+type zerologHandler struct {
+	log    zerolog.Logger
+	prefix string
+}
+
+// Zerolog returns a slog handler writing to a zerolog logger
+func Zerolog(log zerolog.Logger) slog.Handler {
+	return &zerologHandler{log: log}
+}
+
+func zerologLevel(l slog.Level) zerolog.Level {
+	switch {
+	case l < slog.LevelDebug:
+		return zerolog.TraceLevel
+	case l < slog.LevelInfo:
+		return zerolog.DebugLevel
+	case l < slog.LevelWarn:
+		return zerolog.InfoLevel
+	case l < slog.LevelError:
+		return zerolog.WarnLevel
+	default:
+		return zerolog.ErrorLevel
+	}
+}
+
+func zerologFields(prefix string, attrs ...slog.Attr) map[string]interface{} {
+	fields := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		xserver.FlattenAttr(prefix, a, func(key string, v slog.Value) {
+			fields[key] = v.Any()
+		})
+	}
+	return fields
+}
+
+func (h *zerologHandler) Enabled(_ context.Context, l slog.Level) bool {
+	level := zerologLevel(l)
+	return level >= h.log.GetLevel() && level >= zerolog.GlobalLevel()
+}
+
+func (h *zerologHandler) Handle(_ context.Context, r slog.Record) error {
+	e := h.log.WithLevel(zerologLevel(r.Level))
+	if e == nil {
+		return nil
+	}
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	e.Fields(zerologFields(h.prefix, attrs...)).Msg(r.Message)
+	return nil
+}
+
+func (h *zerologHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &zerologHandler{log: h.log.With().Fields(zerologFields(h.prefix, attrs...)).Logger(), prefix: h.prefix}
+}
+
+func (h *zerologHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &zerologHandler{log: h.log, prefix: h.prefix + name + "."}
+}
@@ -0,0 +1,86 @@
+package logadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestZerologLevels(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+
+	tests := []struct {
+		level   slog.Level
+		want    zerolog.Level
+		enabled bool
+	}{
+		{slog.LevelDebug - 4, zerolog.TraceLevel, false},
+		{slog.LevelDebug, zerolog.DebugLevel, true},
+		{slog.LevelInfo, zerolog.InfoLevel, true},
+		{slog.LevelInfo + 2, zerolog.InfoLevel, true},
+		{slog.LevelWarn, zerolog.WarnLevel, true},
+		{slog.LevelError, zerolog.ErrorLevel, true},
+		{slog.LevelError + 4, zerolog.ErrorLevel, true},
+	}
+	var buf bytes.Buffer
+	h := Zerolog(zerolog.New(&buf).Level(zerolog.DebugLevel))
+	for _, tt := range tests {
+		if got := zerologLevel(tt.level); got != tt.want {
+			t.Errorf("%v: level %v, want %v", tt.level, got, tt.want)
+		}
+		if got := h.Enabled(context.Background(), tt.level); got != tt.enabled {
+			t.Errorf("%v: enabled %v, want %v", tt.level, got, tt.enabled)
+		}
+		buf.Reset()
+		_ = h.Handle(context.Background(), slog.NewRecord(time.Time{}, tt.level, "msg", 0))
+		if tt.enabled != (buf.Len() != 0) {
+			t.Errorf("%v: wrote %q", tt.level, buf.String())
+		} else if tt.enabled && !strings.Contains(buf.String(), `"level":"`+tt.want.String()+`"`) {
+			t.Errorf("%v: wrote %q, want the level %v", tt.level, buf.String(), tt.want)
+		}
+	}
+
+	// the global level applies too
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	if h.Enabled(context.Background(), slog.LevelInfo) || !h.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("the global level is not applied")
+	}
+}
+
+func TestZerologFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(Zerolog(zerolog.New(&buf))).With("service", "api").WithGroup("req").With("id", 7).WithGroup("")
+
+	log.Info("hello",
+		"status", 200,
+		slog.Group("user", "name", "bob", slog.Group("role", "admin", true)),
+		slog.Float64("ratio", 0.5),
+		slog.Any("tags", []string{"a"}))
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"level":               "info",
+		"message":             "hello",
+		"service":             "api",
+		"req.id":              float64(7),
+		"req.status":          float64(200),
+		"req.user.name":       "bob",
+		"req.user.role.admin": true,
+		"req.ratio":           0.5,
+		"req.tags":            []interface{}{"a"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fields %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package xserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// longPollGrace is added to the wait when extending the connection deadlines,
+// leaving time to write the response
+const longPollGrace = 10 * time.Second
+
+var (
+	longPollWaiters = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_longpoll_waiters",
+		Help: "Number of long-poll requests waiting for data.",
+	}, []string{"path"})
+
+	longPollRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_longpoll_requests_total",
+		Help: "Number of long-poll requests by outcome.",
+	}, []string{"path", "outcome"})
+)
+
+// LongPollConfig describes the waits of a long-poll route
+type LongPollConfig struct {
+	// Wait is how long a request waits for data before it is answered 204 No Content
+	Wait time.Duration `envconfig:"longpoll_wait" mapstructure:"longpoll_wait" default:"30s"`
+	// MaxWaiters caps the requests waiting on the route, requests past it are answered 503
+	MaxWaiters int `envconfig:"longpoll_max_waiters" mapstructure:"longpoll_max_waiters" default:"1000"`
+}
+
+// Subscribe subscribes a long-poll request to its data. The first value received
+// on the channel is written as json, the returned func is called when the request is done.
+type Subscribe[T any] func(r *http.Request) (<-chan T, func(), error)
+
+// LongPoll registers a GET route waiting on the subscription of each request until data
+// arrives, the wait is over or the server starts shutting down. Timeouts, shutdowns and
+// closed channels are answered 204 for the client to poll again. The route is exempt
+// from Config.Timeout and Config.RateLimit, the connection deadlines are extended to cover the wait.
+func LongPoll[T any](r Router, pattern string, cfg LongPollConfig, subscribe Subscribe[T], opts ...RouteOption) {
+	if cfg.Wait <= 0 {
+		cfg.Wait = 30 * time.Second
+	}
+	if cfg.MaxWaiters <= 0 {
+		cfg.MaxWaiters = 1000
+	}
+
+	rcfg := routerConfig(r)
+	metrics := metricsFor(rcfg.MetricsNamespace, rcfg.NativeHistograms)
+	slots := make(chan struct{}, cfg.MaxWaiters)
+	waiters := metrics.longPollWaiters.WithLabelValues(pattern)
+	outcome := func(name string) {
+		metrics.longPollRequests.WithLabelValues(pattern, name).Inc()
+	}
+
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if ShuttingDown(req.Context()) {
+			outcome("drain")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		default:
+			outcome("rejected")
+			w.Header().Set("Retry-After", "1")
+			longPollError(w, http.StatusServiceUnavailable, "too many waiting requests")
+			return
+		}
+
+		ch, done, err := subscribe(req)
+		if err != nil {
+			outcome("error")
+			longPollError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer done()
+
+		waiters.Inc()
+		defer waiters.Dec()
+
+		// the server deadlines are derived from Config.Timeout, and an expired read
+		// deadline cancels the request context
+		rc := http.NewResponseController(w)
+		deadline := time.Now().Add(cfg.Wait + longPollGrace)
+		_ = rc.SetReadDeadline(deadline)
+		_ = rc.SetWriteDeadline(deadline)
+
+		timer := time.NewTimer(cfg.Wait)
+		defer timer.Stop()
+
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				outcome("closed")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			d, err := json.Marshal(v)
+			if err != nil {
+				outcome("error")
+				longPollError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			outcome("data")
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(d)
+		case <-timer.C:
+			outcome("timeout")
+			w.WriteHeader(http.StatusNoContent)
+		case <-Draining(req.Context()):
+			outcome("drain")
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusNoContent)
+		case <-req.Context().Done():
+			outcome("canceled")
+		}
+	}
+	r.Get(pattern, fn, append(opts, WithoutTimeout(), WithoutRateLimit())...)
+}
+
+// longPollError answers a long-poll request with a json error
+func longPollError(w http.ResponseWriter, status int, msg string) {
+	d, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
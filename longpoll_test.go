@@ -0,0 +1,104 @@
+package xserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLongPoll(t *testing.T) {
+	r := NewRouter(Config{RateLimit: 10, MetricsNamespace: "longpoll_test"})
+	updates := make(chan string)
+	subscribed := make(chan struct{}, 1)
+	LongPoll(r, "/events", LongPollConfig{Wait: 50 * time.Millisecond, MaxWaiters: 1},
+		func(req *http.Request) (<-chan string, func(), error) {
+			switch req.URL.Query().Get("mode") {
+			case "error":
+				return nil, nil, errors.New("no subscription")
+			case "closed":
+				ch := make(chan string)
+				close(ch)
+				return ch, func() {}, nil
+			}
+			subscribed <- struct{}{}
+			return updates, func() {}, nil
+		})
+	metrics := metricsFor("longpoll_test", false)
+	outcomes := func(name string) float64 {
+		return testutil.ToFloat64(metrics.longPollRequests.WithLabelValues("/events", name))
+	}
+
+	serve := func(ctx context.Context, query string) chan *httptest.ResponseRecorder {
+		res := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/events"+query, nil).WithContext(ctx)
+			r.Mux().ServeHTTP(rec, req)
+			res <- rec
+		}()
+		return res
+	}
+	ctx := context.Background()
+
+	// a wait without data is answered 204
+	start := time.Now()
+	rec := <-serve(ctx, "")
+	<-subscribed
+	if rec.Code != http.StatusNoContent || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("timeout: %d after %v", rec.Code, time.Since(start))
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+	}
+	if outcomes("timeout") != 1 {
+		t.Errorf("timeout outcomes = %v", outcomes("timeout"))
+	}
+
+	// data wakes the request up, a second waiter is past MaxWaiters
+	res := serve(ctx, "")
+	<-subscribed
+	rejected := <-serve(ctx, "")
+	if rejected.Code != http.StatusServiceUnavailable || rejected.Header().Get("Retry-After") != "1" ||
+		!strings.Contains(rejected.Body.String(), `"error":"too many waiting requests"`) {
+		t.Errorf("past MaxWaiters: %d %v %s", rejected.Code, rejected.Header(), rejected.Body)
+	}
+	updates <- "hello"
+	rec = <-res
+	if rec.Code != http.StatusOK || rec.Body.String() != `"hello"` || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("data: %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+	if got := testutil.ToFloat64(metrics.longPollWaiters.WithLabelValues("/events")); got != 0 {
+		t.Errorf("waiters = %v after the requests", got)
+	}
+
+	// a closed channel and a failed subscription
+	if rec := <-serve(ctx, "?mode=closed"); rec.Code != http.StatusNoContent {
+		t.Errorf("closed channel: %d", rec.Code)
+	}
+	if rec := <-serve(ctx, "?mode=error"); rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "no subscription") {
+		t.Errorf("subscription error: %d %s", rec.Code, rec.Body)
+	}
+
+	// the waiting requests are released when the server drains, and new ones are not held
+	state := newServerState(slog.Default())
+	res = serve(state.context(ctx), "")
+	<-subscribed
+	state.beginShutdown(nil)
+	rec = <-res
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Connection") != "close" {
+		t.Errorf("drain: %d %v", rec.Code, rec.Header())
+	}
+	if rec := <-serve(state.context(ctx), ""); rec.Code != http.StatusNoContent {
+		t.Errorf("request while shutting down: %d", rec.Code)
+	}
+	if outcomes("drain") != 2 || outcomes("rejected") != 1 || outcomes("data") != 1 {
+		t.Errorf("outcomes drain %v rejected %v data %v", outcomes("drain"), outcomes("rejected"), outcomes("data"))
+	}
+}
@@ -0,0 +1,101 @@
+package xserver
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Native histogram settings of the duration histograms, classic buckets are kept
+// so scrapers without native histograms support see no change
+const (
+	nativeBucketFactor     = 1.1
+	nativeMaxBucketNumber  = 160
+	nativeMinResetDuration = time.Hour
+)
+
+// histogramOpts adds the native histogram settings to opts when native is set
+func histogramOpts(opts prometheus.HistogramOpts, native bool) prometheus.HistogramOpts {
+	if native {
+		opts.NativeHistogramBucketFactor = nativeBucketFactor
+		opts.NativeHistogramMaxBucketNumber = nativeMaxBucketNumber
+		opts.NativeHistogramMinResetDuration = nativeMinResetDuration
+	}
+	return opts
+}
+
+// BucketProfile is a set of duration buckets selectable per route,
+// its durations are exported as http_response_time_<name>_seconds
+type BucketProfile struct {
+	Name    string
+	Buckets []float64
+}
+
+var (
+	// FastBuckets suit sub-10ms endpoints
+	FastBuckets = BucketProfile{
+		Name:    "fast",
+		Buckets: []float64{.0005, .001, .0025, .005, .0075, .01, .025, .05, .1, .25},
+	}
+	// SlowBuckets suit long running endpoints such as report generation
+	SlowBuckets = BucketProfile{
+		Name:    "slow",
+		Buckets: []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}
+)
+
+// WithBuckets observes the route durations with the buckets of the profile,
+// in addition to the default http_response_time_seconds histogram
+func WithBuckets(profile BucketProfile) RouteOption {
+	return func(o *routeOptions) {
+		o.buckets = &profile
+	}
+}
+
+type bucketEntry struct {
+	profile BucketProfile
+	native  bool
+	h       *prometheus.HistogramVec
+}
+
+var (
+	bucketHistogramsMu sync.Mutex
+	bucketHistograms   = map[string]bucketEntry{}
+)
+
+// bucketHistogram returns the histogram of a profile and whether it is native, the
+// first route of a profile decides. Profiles sharing a name must share their buckets.
+func bucketHistogram(namespace string, profile BucketProfile, native bool) (*prometheus.HistogramVec, bool) {
+	key := namespace + "/" + profile.Name
+	bucketHistogramsMu.Lock()
+	defer bucketHistogramsMu.Unlock()
+	if e, ok := bucketHistograms[key]; ok {
+		if !slices.Equal(e.profile.Buckets, profile.Buckets) {
+			panic(fmt.Sprintf("xserver: bucket profile %q is already used with other buckets", profile.Name))
+		}
+		return e.h, e.native
+	}
+
+	h := prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_response_time_" + profile.Name + "_seconds",
+		Help:      "Duration of HTTP requests of the routes using the " + profile.Name + " buckets.",
+		Buckets:   profile.Buckets,
+	}, native), []string{"method", "path"})
+	prometheus.MustRegister(h)
+	bucketHistograms[key] = bucketEntry{profile: profile, native: native, h: h}
+	return h, native
+}
+
+func observeDuration(h *prometheus.HistogramVec, method, pattern string, next http.Handler) http.Handler {
+	observer := h.WithLabelValues(method, pattern)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timer := prometheus.NewTimer(observer)
+		next.ServeHTTP(w, r)
+		timer.ObserveDuration()
+	})
+}
@@ -0,0 +1,58 @@
+package xserver
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func isNative(t *testing.T, h *prometheus.HistogramVec) bool {
+	t.Helper()
+	o := h.WithLabelValues("GET", "/")
+	o.Observe(0.1)
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().Schema != nil
+}
+
+func TestNativeHistogramsOptIn(t *testing.T) {
+	if m := metricsFor("classic_test", false); m.native || isNative(t, m.duration) {
+		t.Error("the duration histogram is native without NativeHistograms")
+	}
+	if m := metricsFor("native_test", true); !m.native || !isNative(t, m.duration) {
+		t.Error("the duration histogram is not native with NativeHistograms")
+	}
+	// the first router of a namespace decides
+	if m := metricsFor("native_test", false); !m.native {
+		t.Error("the namespace collectors were not reused")
+	}
+
+	h, native := bucketHistogram("classic_test", FastBuckets, false)
+	if native || isNative(t, h) {
+		t.Error("the bucket histogram is native without NativeHistograms")
+	}
+	h, native = bucketHistogram("native_test", FastBuckets, true)
+	if !native || !isNative(t, h) {
+		t.Error("the bucket histogram is not native with NativeHistograms")
+	}
+}
+
+func TestBucketHistogramProfiles(t *testing.T) {
+	fast, _ := bucketHistogram("profiles_test", FastBuckets, false)
+	if again, _ := bucketHistogram("profiles_test", FastBuckets, false); again != fast {
+		t.Error("the histogram of a profile is not reused")
+	}
+	if slow, _ := bucketHistogram("profiles_test", SlowBuckets, false); slow == fast {
+		t.Error("two profiles share a histogram")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a profile reusing a name with other buckets was accepted")
+		}
+	}()
+	bucketHistogram("profiles_test", BucketProfile{Name: "fast", Buckets: []float64{1, 2}}, false)
+}
@@ -0,0 +1,68 @@
+package xserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promLogger forwards promhttp errors to slog
+type promLogger struct {
+	log *slog.Logger
+}
+
+func (l promLogger) Println(v ...interface{}) {
+	l.log.Error("Metrics scrape error", "error", strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+// metricsHandler serves the default registry with gzip, the configured scrape limits
+// and promhttp's own scrape metrics, behind the configured authentication
+func metricsHandler(cfg Config) http.Handler {
+	h := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			ErrorLog:            promLogger{cfg.Slog()},
+			Registry:            prometheus.DefaultRegisterer,
+			MaxRequestsInFlight: cfg.MetricsMaxScrapes,
+			Timeout:             cfg.MetricsTimeout,
+		}))
+
+	if cfg.MetricsToken == "" && cfg.MetricsUser == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !metricsAuthorized(cfg, r) {
+			if cfg.MetricsToken != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="metrics"`)
+			}
+			if cfg.MetricsUser != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="metrics"`)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func metricsAuthorized(cfg Config, r *http.Request) bool {
+	if cfg.MetricsToken != "" {
+		auth := r.Header.Get("Authorization")
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") &&
+			subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(cfg.MetricsToken)) == 1 {
+			return true
+		}
+	}
+	if cfg.MetricsUser != "" {
+		user, pass, ok := r.BasicAuth()
+		if ok && subtle.ConstantTimeCompare([]byte(user), []byte(cfg.MetricsUser)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.MetricsPassword)) == 1 {
+			return true
+		}
+	}
+	return false
+}
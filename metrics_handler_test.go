@@ -0,0 +1,107 @@
+package xserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricsAuth(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		token     string
+		user      string
+		password  string
+		status    int
+		challenge []string
+	}{
+		{"open", Config{}, "", "", "", 200, nil},
+		{"token", Config{MetricsToken: "secret"}, "secret", "", "", 200, nil},
+		{"wrong token", Config{MetricsToken: "secret"}, "guess", "", "", 401, []string{`Bearer realm="metrics"`}},
+		{"missing token", Config{MetricsToken: "secret"}, "", "", "", 401, []string{`Bearer realm="metrics"`}},
+		{"basic", Config{MetricsUser: "prom", MetricsPassword: "pw"}, "", "prom", "pw", 200, nil},
+		{"wrong password", Config{MetricsUser: "prom", MetricsPassword: "pw"}, "", "prom", "guess", 401, []string{`Basic realm="metrics"`}},
+		{"wrong user", Config{MetricsUser: "prom", MetricsPassword: "pw"}, "", "root", "pw", 401, []string{`Basic realm="metrics"`}},
+		{"missing basic", Config{MetricsUser: "prom", MetricsPassword: "pw"}, "", "", "", 401, []string{`Basic realm="metrics"`}},
+		{"either with token", Config{MetricsToken: "secret", MetricsUser: "prom", MetricsPassword: "pw"}, "secret", "", "", 200, nil},
+		{"either with basic", Config{MetricsToken: "secret", MetricsUser: "prom", MetricsPassword: "pw"}, "", "prom", "pw", 200, nil},
+		{"neither", Config{MetricsToken: "secret", MetricsUser: "prom", MetricsPassword: "pw"}, "", "", "", 401,
+			[]string{`Bearer realm="metrics"`, `Basic realm="metrics"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/_metrics", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			metricsHandler(tt.cfg).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Values("WWW-Authenticate"); strings.Join(got, ",") != strings.Join(tt.challenge, ",") {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.challenge)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), "promhttp_metric_handler_requests_total") {
+				t.Error("the metrics are not served")
+			}
+		})
+	}
+}
+
+// blockingCollector holds the scrapes until it is released
+type blockingCollector struct {
+	collecting chan struct{}
+	release    chan struct{}
+}
+
+func (c *blockingCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c *blockingCollector) Collect(chan<- prometheus.Metric) {
+	c.collecting <- struct{}{}
+	<-c.release
+}
+
+func TestMetricsScrapeLimits(t *testing.T) {
+	c := &blockingCollector{collecting: make(chan struct{}, 4), release: make(chan struct{})}
+	prometheus.MustRegister(c)
+	defer prometheus.Unregister(c)
+
+	scrape := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_metrics", nil))
+		return rec.Code
+	}
+
+	// a scrape past MetricsTimeout is answered 503
+	start := time.Now()
+	if code := scrape(metricsHandler(Config{MetricsTimeout: 20 * time.Millisecond})); code != http.StatusServiceUnavailable {
+		t.Errorf("slow scrape: %d, want 503", code)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the slow scrape took %v", d)
+	}
+	<-c.collecting
+
+	// a scrape past MetricsMaxScrapes is answered 503
+	h := metricsHandler(Config{MetricsMaxScrapes: 1})
+	first := make(chan int)
+	go func() { first <- scrape(h) }()
+	<-c.collecting
+	if code := scrape(h); code != http.StatusServiceUnavailable {
+		t.Errorf("scrape past the limit: %d, want 503", code)
+	}
+	close(c.release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first scrape: %d", code)
+	}
+}
@@ -0,0 +1,184 @@
+package xserver
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BruteForceConfig describes the lockouts of the brute force protection
+type BruteForceConfig struct {
+	// AccountThreshold is the number of failures of an account before it is locked out
+	AccountThreshold int `envconfig:"bruteforce_account_threshold" mapstructure:"bruteforce_account_threshold" default:"5"`
+	// IPThreshold is the number of failures from an address before it is locked out,
+	// higher than AccountThreshold as credential stuffing spreads over accounts
+	IPThreshold int `envconfig:"bruteforce_ip_threshold" mapstructure:"bruteforce_ip_threshold" default:"20"`
+	// Window over which the failures are counted
+	Window time.Duration `envconfig:"bruteforce_window" mapstructure:"bruteforce_window" default:"15m"`
+	// BaseLockout is the first lockout, it doubles with every failure past the threshold
+	BaseLockout time.Duration `envconfig:"bruteforce_base_lockout" mapstructure:"bruteforce_base_lockout" default:"30s"`
+	// MaxLockout caps the lockouts
+	MaxLockout time.Duration `envconfig:"bruteforce_max_lockout" mapstructure:"bruteforce_max_lockout" default:"1h"`
+	// Account extracts the account of a login request, only addresses are tracked when nil.
+	// Reading a form value is fine, handlers still see the parsed form.
+	Account func(r *http.Request) string `ignored:"true"`
+	// OnLockout is called when an account or an address gets locked out, key is "account:<name>" or "ip:<addr>"
+	OnLockout func(r *http.Request, key string, lockout time.Duration) `ignored:"true"`
+}
+
+// BruteForce locks out the accounts and the addresses failing to authenticate repeatedly
+type BruteForce struct {
+	cfg   BruteForceConfig
+	store RateLimitStore
+	now   func() time.Time
+}
+
+// NewBruteForce creates the protection over the store
+func NewBruteForce(cfg BruteForceConfig, store RateLimitStore) *BruteForce {
+	if cfg.AccountThreshold <= 0 {
+		cfg.AccountThreshold = 5
+	}
+	if cfg.IPThreshold <= 0 {
+		cfg.IPThreshold = 20
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 15 * time.Minute
+	}
+	if cfg.BaseLockout <= 0 {
+		cfg.BaseLockout = 30 * time.Second
+	}
+	if cfg.MaxLockout <= 0 {
+		cfg.MaxLockout = time.Hour
+	}
+	return &BruteForce{cfg: cfg, store: store, now: time.Now}
+}
+
+type loginAttempt struct {
+	guard   *BruteForce
+	ip      string
+	account string
+}
+
+type loginAttemptKey struct{}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware answers 429 with Retry-After while the address or the account of the request is locked out,
+// handlers report the outcome of the attempt with LoginFailed and LoginSucceeded
+func (b *BruteForce) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			a := &loginAttempt{guard: b, ip: clientIP(r)}
+			if b.cfg.Account != nil {
+				a.account = b.cfg.Account(r)
+			}
+
+			now := b.now()
+			var until time.Time
+			for _, key := range a.keys() {
+				_, expiry, err := b.store.Get(r.Context(), key+":lock")
+				if err != nil {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				if expiry.After(until) {
+					until = expiry
+				}
+			}
+			if until.After(now) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(until.Sub(now).Seconds()))))
+				d, _ := json.Marshal(map[string]string{"error": "too many failed attempts"})
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = w.Write(d)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loginAttemptKey{}, a)))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func (a *loginAttempt) keys() []string {
+	keys := []string{"bruteforce:ip:" + a.ip}
+	if a.account != "" {
+		keys = append(keys, "bruteforce:account:"+a.account)
+	}
+	return keys
+}
+
+// LoginFailed reports a failed authentication of the request, account overrides
+// the account extracted by the middleware when not empty
+func LoginFailed(r *http.Request, account string) error {
+	a, ok := r.Context().Value(loginAttemptKey{}).(*loginAttempt)
+	if !ok {
+		return nil
+	}
+	if account != "" {
+		a.account = account
+	}
+	b := a.guard
+	if err := b.fail(r, "bruteforce:ip:"+a.ip, "ip:"+a.ip, b.cfg.IPThreshold); err != nil {
+		return err
+	}
+	if a.account == "" {
+		return nil
+	}
+	return b.fail(r, "bruteforce:account:"+a.account, "account:"+a.account, b.cfg.AccountThreshold)
+}
+
+// fail counts a failure and locks the key out past the threshold, the lockout doubling with each extra failure
+func (b *BruteForce) fail(r *http.Request, key, name string, threshold int) error {
+	n, err := b.store.Incr(r.Context(), key+":failures", b.cfg.Window)
+	if err != nil {
+		return err
+	}
+	if n < int64(threshold) {
+		return nil
+	}
+	lockout := b.cfg.MaxLockout
+	if extra := n - int64(threshold); extra < 32 {
+		if d := b.cfg.BaseLockout << uint(extra); d > 0 && d < lockout {
+			lockout = d
+		}
+	}
+	if err := b.store.Set(r.Context(), key+":lock", n, lockout); err != nil {
+		return err
+	}
+	if b.cfg.OnLockout != nil {
+		b.cfg.OnLockout(r, name, lockout)
+	}
+	return nil
+}
+
+// LoginSucceeded reports a successful authentication, it clears the failures of the account.
+// The failures of the address are kept: an attacker owning one account could otherwise
+// log into it between guesses to keep stuffing the credentials of the others.
+func LoginSucceeded(r *http.Request, account string) error {
+	a, ok := r.Context().Value(loginAttemptKey{}).(*loginAttempt)
+	if !ok {
+		return nil
+	}
+	if account != "" {
+		a.account = account
+	}
+	if a.account == "" {
+		return nil
+	}
+	key := "bruteforce:account:" + a.account
+	if err := a.guard.store.Delete(r.Context(), key+":failures"); err != nil {
+		return err
+	}
+	return a.guard.store.Delete(r.Context(), key+":lock")
+}
@@ -0,0 +1,163 @@
+package xserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock advanced by the tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	s := NewMemoryRateLimitStore()
+	s.now = clock.now
+
+	for want := int64(1); want <= 3; want++ {
+		if n, _ := s.Incr(ctx, "k", time.Minute); n != want {
+			t.Fatalf("Incr = %d, want %d", n, want)
+		}
+	}
+	// the ttl of the first increment is kept
+	clock.t = clock.t.Add(30 * time.Second)
+	s.Incr(ctx, "k", time.Minute)
+	if n, expiry, _ := s.Get(ctx, "k"); n != 4 || !expiry.Equal(time.Unix(1700000060, 0)) {
+		t.Errorf("Get = %d %v", n, expiry)
+	}
+
+	// an expired counter reads zero and starts over
+	clock.t = clock.t.Add(30 * time.Second)
+	if n, expiry, _ := s.Get(ctx, "k"); n != 0 || !expiry.IsZero() {
+		t.Errorf("expired Get = %d %v", n, expiry)
+	}
+	if n, _ := s.Incr(ctx, "k", time.Minute); n != 1 {
+		t.Errorf("Incr after expiry = %d, want 1", n)
+	}
+
+	_ = s.Set(ctx, "k", 42, time.Second)
+	if n, _, _ := s.Get(ctx, "k"); n != 42 {
+		t.Errorf("Get after Set = %d", n)
+	}
+	_ = s.Delete(ctx, "k")
+	if n, _, _ := s.Get(ctx, "k"); n != 0 {
+		t.Errorf("Get after Delete = %d", n)
+	}
+
+	// the expired counters are swept as others are written
+	_ = s.Set(ctx, "stale", 1, time.Second)
+	clock.t = clock.t.Add(time.Minute)
+	for i := 0; i < 1024; i++ {
+		s.Incr(ctx, "live", time.Hour)
+	}
+	if _, ok := s.counters["stale"]; ok {
+		t.Error("the expired counter was not swept")
+	}
+}
+
+func TestBruteForce(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	store := NewMemoryRateLimitStore()
+	store.now = clock.now
+	var lockouts []string
+	b := NewBruteForce(BruteForceConfig{
+		AccountThreshold: 3,
+		IPThreshold:      5,
+		Window:           15 * time.Minute,
+		BaseLockout:      30 * time.Second,
+		MaxLockout:       2 * time.Minute,
+		Account:          func(r *http.Request) string { return r.FormValue("user") },
+		OnLockout: func(r *http.Request, key string, lockout time.Duration) {
+			lockouts = append(lockouts, key+" "+lockout.String())
+		},
+	}, store)
+	b.now = clock.now
+
+	login := b.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("password") != "right" {
+			if err := LoginFailed(r, ""); err != nil {
+				t.Error(err)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := LoginSucceeded(r, ""); err != nil {
+			t.Error(err)
+		}
+	}))
+
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		ip         string
+		user       string
+		password   string
+		status     int
+		retryAfter string
+		lockout    string
+	}{
+		{"first failure", 0, "10.0.0.1", "alice", "wrong", 401, "", ""},
+		{"second failure", 0, "10.0.0.2", "alice", "wrong", 401, "", ""},
+		{"threshold", 0, "10.0.0.3", "alice", "wrong", 401, "", "account:alice 30s"},
+		{"locked out", 0, "10.0.0.4", "alice", "right", 429, "30", ""},
+		{"retry after shrinks", 10500 * time.Millisecond, "10.0.0.4", "alice", "right", 429, "20", ""},
+		{"lockout over", 20 * time.Second, "10.0.0.5", "alice", "wrong", 401, "", "account:alice 1m0s"},
+		{"lockout doubled", 0, "10.0.0.5", "alice", "right", 429, "60", ""},
+		{"doubled again", time.Minute, "10.0.0.6", "alice", "wrong", 401, "", "account:alice 2m0s"},
+		{"capped", 2 * time.Minute, "10.0.0.7", "alice", "wrong", 401, "", "account:alice 2m0s"},
+		{"success once unlocked", 2 * time.Minute, "10.0.0.8", "alice", "right", 200, "", ""},
+		{"failures cleared", 0, "10.0.0.8", "alice", "wrong", 401, "", ""},
+		{"not locked after clearing", 0, "10.0.0.8", "alice", "right", 200, "", ""},
+
+		{"window failure", 0, "10.0.1.1", "bob", "wrong", 401, "", ""},
+		{"window second failure", 0, "10.0.1.2", "bob", "wrong", 401, "", ""},
+		{"failures expired", 15 * time.Minute, "10.0.1.3", "bob", "wrong", 401, "", ""},
+		{"not locked in a new window", 0, "10.0.1.4", "bob", "right", 200, "", ""},
+
+		{"stuffing 1", 0, "10.0.2.1", "u1", "wrong", 401, "", ""},
+		{"stuffing 2", 0, "10.0.2.1", "u2", "wrong", 401, "", ""},
+		{"stuffing 3", 0, "10.0.2.1", "u3", "wrong", 401, "", ""},
+		{"stuffing 4", 0, "10.0.2.1", "u4", "wrong", 401, "", ""},
+		{"stuffing 5", 0, "10.0.2.1", "u5", "wrong", 401, "", "ip:10.0.2.1 30s"},
+		{"address locked out", 0, "10.0.2.1", "carol", "right", 429, "30", ""},
+		{"other address", 0, "10.0.2.2", "carol", "right", 200, "", ""},
+	}
+	for _, step := range steps {
+		clock.t = clock.t.Add(step.advance)
+		lockouts = nil
+		form := url.Values{"user": {step.user}, "password": {step.password}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = step.ip + ":1234"
+		rec := httptest.NewRecorder()
+		login.ServeHTTP(rec, req)
+
+		if rec.Code != step.status || rec.Header().Get("Retry-After") != step.retryAfter {
+			t.Errorf("%s: %d Retry-After %q, want %d %q", step.name, rec.Code, rec.Header().Get("Retry-After"), step.status, step.retryAfter)
+		}
+		if got := strings.Join(lockouts, ","); got != step.lockout {
+			t.Errorf("%s: lockouts %q, want %q", step.name, got, step.lockout)
+		}
+	}
+}
+
+func TestLoginOutsideOfTheMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/login", nil)
+	if err := LoginFailed(req, "alice"); err != nil {
+		t.Error(err)
+	}
+	if err := LoginSucceeded(req, "alice"); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,37 +1,192 @@
 package xserver
 
 import (
+	"context"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"time"
 
+	chiMiddleware "github.com/go-chi/chi/middleware"
 	"github.com/l00p8/log"
 )
 
+// WithLogging logs requests and responses to a github.com/l00p8/log Logger
 func WithLogging(log log.Logger) func(http.Handler) http.Handler {
+	return WithSlog(slog.New(NewLegacyHandler(log)))
+}
+
+type logContextKey struct{}
+
+// setLogContext hands the context of an inner handler, carrying its span, back to the access log
+func setLogContext(ctx context.Context) {
+	if slot, ok := ctx.Value(logContextKey{}).(*context.Context); ok {
+		*slot = ctx
+	}
+}
+
+// recorder buffers the response for the access log until the handler flushes it,
+// from then on the response is streamed. Unwrap lets http.ResponseController reach
+// the connection to set its deadlines.
+type recorder struct {
+	*httptest.ResponseRecorder
+	w         http.ResponseWriter
+	streaming bool
+}
+
+func (rec *recorder) Unwrap() http.ResponseWriter {
+	return rec.w
+}
+
+// WriteHeader sends 1xx responses such as 103 Early Hints right away with the headers
+// set so far, only the final status is recorded
+func (rec *recorder) WriteHeader(code int) {
+	if rec.streaming {
+		return
+	}
+	if !informational(code) {
+		rec.ResponseRecorder.WriteHeader(code)
+		return
+	}
+	rec.copyHeader()
+	rec.w.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.streaming {
+		return rec.w.Write(b)
+	}
+	return rec.ResponseRecorder.Write(b)
+}
+
+func (rec *recorder) WriteString(s string) (int, error) {
+	if rec.streaming {
+		return io.WriteString(rec.w, s)
+	}
+	return rec.ResponseRecorder.WriteString(s)
+}
+
+// Flush writes what was buffered and switches to streaming, the access log
+// only dumps the body written before
+func (rec *recorder) Flush() {
+	if !rec.streaming {
+		rec.streaming = true
+		rec.copyHeader()
+		rec.w.WriteHeader(rec.Code)
+		_, _ = rec.w.Write(rec.Body.Bytes())
+	}
+	_ = http.NewResponseController(rec.w).Flush()
+}
+
+// copyHeader copies the captured headers to the response, trailers included
+func (rec *recorder) copyHeader() {
+	for k, v := range rec.Header() {
+		rec.w.Header()[k] = v
+	}
+}
+
+// redactedHeaders are masked in the request and response dumps
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+func redactHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range redactedHeaders {
+		if _, ok := h[k]; ok {
+			h.Set(k, "REDACTED")
+		}
+	}
+	return h
+}
+
+// httpDumps are the request and response dumps of a finish record, they are
+// left out of the copies sent to Config.LogBridge
+type httpDumps struct {
+	request  string
+	response string
+}
+
+func (d httpDumps) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("request", d.request), slog.String("response", d.response))
+}
+
+// withoutDumps drops the request and response dumps of the records
+type withoutDumps struct {
+	slog.Handler
+}
+
+func (h withoutDumps) Handle(ctx context.Context, r slog.Record) error {
+	dumps := false
+	r.Attrs(func(a slog.Attr) bool {
+		_, dumps = a.Value.Any().(httpDumps)
+		return !dumps
+	})
+	if !dumps {
+		return h.Handler.Handle(ctx, r)
+	}
+	filtered := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		if _, ok := a.Value.Any().(httpDumps); !ok {
+			filtered.AddAttrs(a)
+		}
+		return true
+	})
+	return h.Handler.Handle(ctx, filtered)
+}
+
+func (h withoutDumps) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return withoutDumps{h.Handler.WithAttrs(attrs)}
+}
+
+func (h withoutDumps) WithGroup(name string) slog.Handler {
+	return withoutDumps{h.Handler.WithGroup(name)}
+}
+
+// WithSlog logs requests and responses at debug level with their dumps as fields,
+// the finish record is logged with the route span when the router traces requests.
+// The credentials headers are redacted from the dumps.
+func WithSlog(log *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			slot := new(context.Context)
+			*slot = ctx
+			r = r.WithContext(context.WithValue(ctx, logContextKey{}, slot))
 			t1 := time.Now()
-			rec := httptest.NewRecorder()
-			log.Debug(r.Method + " " + r.URL.String() + " " + r.Header.Get("X-Request-Id"))
+			rec := &recorder{ResponseRecorder: httptest.NewRecorder(), w: w}
+			reqID := chiMiddleware.GetReqID(ctx)
+			log.DebugContext(ctx, "Request started",
+				"method", r.Method, "url", r.URL.String(), "request_id", reqID)
 			next.ServeHTTP(rec, r)
 
-			dumpResp, _ := httputil.DumpResponse(rec.Result(), true)
-			dumpReq, _ := httputil.DumpRequest(r, true)
-
 			// we copy the captured response headers to our new response
-			for k, v := range rec.Header() {
-				w.Header()[k] = v
-			}
+			rec.copyHeader()
 
-			// grab the captured response body
-			data := rec.Body.Bytes()
-			w.WriteHeader(rec.Result().StatusCode)
-			_, _ = w.Write(data)
+			// grab the captured response body, unless it was streamed
+			status := rec.Result().StatusCode
+			if !rec.streaming {
+				w.WriteHeader(status)
+				_, _ = w.Write(rec.Body.Bytes())
+			}
 
-			dur := time.Since(t1)
-			log.Debug("\n" + string(dumpReq) + "\n\n" + string(dumpResp) + " " + dur.String())
+			ctx = *slot
+			if !log.Enabled(ctx, slog.LevelDebug) {
+				return
+			}
+			res := *rec.Result()
+			res.Header = redactHeaders(res.Header)
+			dumpResp, _ := httputil.DumpResponse(&res, true)
+			req := *r
+			req.Header = redactHeaders(r.Header)
+			dumpReq, _ := httputil.DumpRequest(&req, true)
+			log.DebugContext(ctx, "Request finished",
+				"method", r.Method,
+				"url", r.URL.String(),
+				"request_id", reqID,
+				"status", status,
+				"duration", time.Since(t1),
+				"dump", httpDumps{request: string(dumpReq), response: string(dumpResp)})
 		}
 		return http.HandlerFunc(fn)
 	}
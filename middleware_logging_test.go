@@ -0,0 +1,54 @@
+package xserver
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithSlogDumps(t *testing.T) {
+	var local, bridge bytes.Buffer
+	cfg := Config{
+		LogHandler: slog.NewTextHandler(&local, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		LogBridge:  slog.NewTextHandler(&bridge, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	}
+	h := WithSlog(cfg.Slog())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "response-secret"})
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("body"))
+	req.Header.Set("Authorization", "Bearer request-secret")
+	req.Header.Set("Cookie", "session=cookie-secret")
+	req.Header.Set("X-Trace", "kept")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Set-Cookie") == "" || rec.Body.String() != "hello" {
+		t.Errorf("the response was changed: %v %q", rec.Header(), rec.Body.String())
+	}
+	if req.Header.Get("Authorization") != "Bearer request-secret" {
+		t.Error("the request headers were redacted in place")
+	}
+
+	out := local.String()
+	for _, secret := range []string{"request-secret", "cookie-secret", "response-secret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("the dumps contain %s:\n%s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "X-Trace: kept") || !strings.Contains(out, "REDACTED") {
+		t.Errorf("the dumps are missing:\n%s", out)
+	}
+
+	copied := bridge.String()
+	if !strings.Contains(copied, `msg="Request finished"`) || !strings.Contains(copied, "status=200") {
+		t.Errorf("the bridge misses the finish record:\n%s", copied)
+	}
+	if strings.Contains(copied, "dump") {
+		t.Errorf("the bridge received the dumps:\n%s", copied)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"net/http"
 	"strconv"
+	"sync"
 )
 
 type responseWriter struct {
@@ -17,10 +18,22 @@ func newResponseWriter(w http.ResponseWriter) *responseWriter {
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
+	if !informational(code) {
+		rw.statusCode = code
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the connection
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Flush streams the response to handlers checking for http.Flusher
+func (rw *responseWriter) Flush() {
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
 var totalRequests = prometheus.NewCounterVec(
 	prometheus.CounterOpts{
 		Name: "http_requests_total",
@@ -42,21 +55,101 @@ var httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Help: "Duration of HTTP requests.",
 }, []string{"method", "path"})
 
-func prometheusMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
-		method := r.Method
-		timer := prometheus.NewTimer(httpDuration.WithLabelValues(method, path))
-		rw := newResponseWriter(w)
-		next.ServeHTTP(rw, r)
+// httpMetrics groups the http collectors of a metrics namespace
+type httpMetrics struct {
+	totalRequests  *prometheus.CounterVec
+	responseStatus *prometheus.CounterVec
+	duration       *prometheus.HistogramVec
+	// the collectors of the LongPoll routes
+	longPollWaiters  *prometheus.GaugeVec
+	longPollRequests *prometheus.CounterVec
+	// native tells whether duration also records a native histogram
+	native bool
+}
+
+var (
+	namespacedMu      sync.Mutex
+	namespacedMetrics = map[string]*httpMetrics{}
+)
+
+// metricsFor returns the http collectors for a namespace, the empty namespace
+// keeps the historical unprefixed metric names. The collectors are shared by the
+// routers of a namespace, the first one decides whether they are native.
+func metricsFor(namespace string, native bool) *httpMetrics {
+	namespacedMu.Lock()
+	defer namespacedMu.Unlock()
+	if m, ok := namespacedMetrics[namespace]; ok {
+		return m
+	}
+
+	var m *httpMetrics
+	if namespace == "" {
+		m = &httpMetrics{totalRequests: totalRequests, responseStatus: responseStatus, duration: httpDuration,
+			longPollWaiters: longPollWaiters, longPollRequests: longPollRequests}
+		if native {
+			// the classic histogram is registered on init
+			prometheus.Unregister(httpDuration)
+			m.duration = prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+				Name: "http_response_time_seconds",
+				Help: "Duration of HTTP requests.",
+			}, true), []string{"method", "path"})
+			m.native = true
+			prometheus.MustRegister(m.duration)
+		}
+		namespacedMetrics[namespace] = m
+		return m
+	}
+
+	m = &httpMetrics{
+		totalRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of requests.",
+		}, []string{"method", "path"}),
+		responseStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "response_status",
+			Help:      "Status of HTTP response",
+		}, []string{"status"}),
+		duration: prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_response_time_seconds",
+			Help:      "Duration of HTTP requests.",
+		}, native), []string{"method", "path"}),
+		longPollWaiters: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_longpoll_waiters",
+			Help:      "Number of long-poll requests waiting for data.",
+		}, []string{"path"}),
+		longPollRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_longpoll_requests_total",
+			Help:      "Number of long-poll requests by outcome.",
+		}, []string{"path", "outcome"}),
+		native: native,
+	}
+	prometheus.MustRegister(m.totalRequests, m.responseStatus, m.duration, m.longPollWaiters, m.longPollRequests)
+	namespacedMetrics[namespace] = m
+	return m
+}
+
+func prometheusMiddleware(m *httpMetrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.Path
+			method := r.Method
+			timer := prometheus.NewTimer(m.duration.WithLabelValues(method, path))
+			rw := newResponseWriter(w)
+			next.ServeHTTP(rw, r)
 
-		statusCode := rw.statusCode
+			statusCode := rw.statusCode
 
-		responseStatus.WithLabelValues(strconv.Itoa(statusCode)).Inc()
-		totalRequests.WithLabelValues(method, path).Inc()
+			m.responseStatus.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+			m.totalRequests.WithLabelValues(method, path).Inc()
 
-		timer.ObserveDuration()
-	})
+			timer.ObserveDuration()
+		})
+	}
 }
 
 func init() {
@@ -0,0 +1,54 @@
+package xserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	chiMiddleware "github.com/go-chi/chi/middleware"
+)
+
+type panicResponse struct {
+	Error string   `json:"error"`
+	Panic string   `json:"panic,omitempty"`
+	Stack []string `json:"stack,omitempty"`
+}
+
+// recoverer logs panics with their stack and answers 500,
+// in dev mode the panic and the stack are included in the response
+func recoverer(log *slog.Logger, devMode bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+
+				stack := string(debug.Stack())
+				log.ErrorContext(r.Context(), "Panic recovered",
+					"panic", fmt.Sprint(rvr),
+					"request_id", chiMiddleware.GetReqID(r.Context()),
+					"stack", stack)
+
+				res := panicResponse{Error: http.StatusText(http.StatusInternalServerError)}
+				if devMode {
+					res.Panic = fmt.Sprint(rvr)
+					res.Stack = strings.Split(strings.TrimSpace(stack), "\n")
+				}
+				d, _ := json.Marshal(res)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write(d)
+			}()
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
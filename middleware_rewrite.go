@@ -0,0 +1,251 @@
+package xserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// RewriteRule describes a declarative transformation applied to a request before routing.
+// A rule with an empty Match applies to every request.
+type RewriteRule struct {
+	// Match is a regular expression matched against the request path
+	Match string `mapstructure:"match"`
+	// Replace is the new path for matched requests, it may reference groups of Match ($1, ${name})
+	Replace string `mapstructure:"replace"`
+	// Redirect is a redirect status code (301, 302, 307 or 308), zero means an internal rewrite
+	Redirect int `mapstructure:"redirect"`
+	// Methods limits the rule to the listed request methods
+	Methods         []string    `mapstructure:"methods"`
+	RequestHeaders  HeaderRules `mapstructure:"request_headers"`
+	ResponseHeaders HeaderRules `mapstructure:"response_headers"`
+	Query           QueryRules  `mapstructure:"query"`
+}
+
+// HeaderRules describes header manipulations, applied in order: remove, rename, add
+type HeaderRules struct {
+	Add    map[string]string `mapstructure:"add"`
+	Remove []string          `mapstructure:"remove"`
+	Rename map[string]string `mapstructure:"rename"`
+}
+
+// QueryRules describes query parameter manipulations, applied in order: remove, rename, set
+type QueryRules struct {
+	Set    map[string]string `mapstructure:"set"`
+	Remove []string          `mapstructure:"remove"`
+	Rename map[string]string `mapstructure:"rename"`
+}
+
+type compiledRewriteRule struct {
+	RewriteRule
+	re *regexp.Regexp
+}
+
+func compileRewriteRules(rules []RewriteRule) ([]compiledRewriteRule, error) {
+	compiled := make([]compiledRewriteRule, 0, len(rules))
+	for i, rule := range rules {
+		c := compiledRewriteRule{RewriteRule: rule}
+		if rule.Match != "" {
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite rule %d: %w", i, err)
+			}
+			c.re = re
+		}
+		switch rule.Redirect {
+		case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("rewrite rule %d: unsupported redirect code %d", i, rule.Redirect)
+		}
+		if rule.Redirect != 0 && rule.Replace == "" {
+			return nil, fmt.Errorf("rewrite rule %d: redirect requires a replace target", i)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// validateRewrites checks the rewrite rules of a config, Listen refuses to start with invalid ones
+func validateRewrites(cfg Config) error {
+	if _, err := compileRewriteRules(cfg.Rewrites); err != nil {
+		return fmt.Errorf("xserver: %w", err)
+	}
+	return nil
+}
+
+// rewriteMiddleware returns the rewriter of the config rules, nil without rules.
+// Invalid rules are logged and left out, Listen reports them before serving.
+func rewriteMiddleware(cfg Config, log *slog.Logger) func(http.Handler) http.Handler {
+	if len(cfg.Rewrites) == 0 {
+		return nil
+	}
+	rules, err := compileRewriteRules(cfg.Rewrites)
+	if err != nil {
+		log.Error("Rewrite rules are not applied", "error", err)
+		return nil
+	}
+	return rewriter(rules)
+}
+
+func (c *compiledRewriteRule) matches(method, path string) bool {
+	if len(c.Methods) > 0 {
+		found := false
+		for _, m := range c.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return c.re == nil || c.re.MatchString(path)
+}
+
+func (c *compiledRewriteRule) rewritePath(path string) string {
+	if c.Replace == "" {
+		return path
+	}
+	if c.re == nil {
+		return c.Replace
+	}
+	return c.re.ReplaceAllString(path, c.Replace)
+}
+
+func (h HeaderRules) empty() bool {
+	return len(h.Add) == 0 && len(h.Remove) == 0 && len(h.Rename) == 0
+}
+
+func (h HeaderRules) apply(header http.Header) {
+	for _, k := range h.Remove {
+		header.Del(k)
+	}
+	for from, to := range h.Rename {
+		if v, ok := header[http.CanonicalHeaderKey(from)]; ok {
+			header.Del(from)
+			header[http.CanonicalHeaderKey(to)] = v
+		}
+	}
+	for k, v := range h.Add {
+		header.Add(k, v)
+	}
+}
+
+func (q QueryRules) empty() bool {
+	return len(q.Set) == 0 && len(q.Remove) == 0 && len(q.Rename) == 0
+}
+
+func (q QueryRules) apply(u *url.URL) {
+	values := u.Query()
+	for _, k := range q.Remove {
+		values.Del(k)
+	}
+	for from, to := range q.Rename {
+		if v, ok := values[from]; ok {
+			values.Del(from)
+			values[to] = v
+		}
+	}
+	for k, v := range q.Set {
+		values.Set(k, v)
+	}
+	u.RawQuery = values.Encode()
+}
+
+// rewriteResponseWriter applies response header rules right before the header is sent
+type rewriteResponseWriter struct {
+	http.ResponseWriter
+	rules       []HeaderRules
+	wroteHeader bool
+}
+
+func (rw *rewriteResponseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader && code >= 200 {
+		rw.wroteHeader = true
+		for _, rule := range rw.rules {
+			rule.apply(rw.ResponseWriter.Header())
+		}
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *rewriteResponseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *rewriteResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if !rw.wroteHeader {
+			rw.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// rewriter applies rewrite rules in order before the request reaches the router.
+// Internal rewrites are chained, a redirect stops the processing. On a router mounted
+// under a prefix, such as a Composite service, the rules see the path below the prefix.
+func rewriter(rules []compiledRewriteRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			prefix, path := "", r.URL.Path
+			rctx := chi.RouteContext(r.Context())
+			mounted := rctx != nil && rctx.RoutePath != "" && strings.HasSuffix(r.URL.Path, rctx.RoutePath)
+			if mounted {
+				prefix, path = strings.TrimSuffix(r.URL.Path, rctx.RoutePath), rctx.RoutePath
+			}
+
+			var respRules []HeaderRules
+			for i := range rules {
+				rule := &rules[i]
+				if !rule.matches(r.Method, path) {
+					continue
+				}
+
+				path = rule.rewritePath(path)
+				u := *r.URL
+				u.Path = prefix + path
+				u.RawPath = ""
+				if !rule.Query.empty() {
+					rule.Query.apply(&u)
+				}
+
+				if rule.Redirect != 0 {
+					location := u.String()
+					if target, err := url.Parse(path); err == nil && target.IsAbs() {
+						target.RawQuery = u.RawQuery
+						location = target.String()
+					}
+					rule.ResponseHeaders.apply(w.Header())
+					http.Redirect(w, r, location, rule.Redirect)
+					return
+				}
+
+				r.URL = &u
+				r.RequestURI = u.RequestURI()
+				rule.RequestHeaders.apply(r.Header)
+				if !rule.ResponseHeaders.empty() {
+					respRules = append(respRules, rule.ResponseHeaders)
+				}
+			}
+
+			if mounted {
+				rctx.RoutePath = path
+			}
+			if len(respRules) > 0 {
+				w = &rewriteResponseWriter{ResponseWriter: w, rules: respRules}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
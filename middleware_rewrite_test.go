@@ -0,0 +1,161 @@
+package xserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompileRewriteRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RewriteRule
+		err  string
+	}{
+		{"internal rewrite", RewriteRule{Match: "^/old/(.*)$", Replace: "/new/$1"}, ""},
+		{"match all", RewriteRule{RequestHeaders: HeaderRules{Add: map[string]string{"X-A": "1"}}}, ""},
+		{"redirect", RewriteRule{Match: "^/a$", Replace: "/b", Redirect: http.StatusMovedPermanently}, ""},
+		{"invalid expression", RewriteRule{Match: "(", Replace: "/b"}, "rewrite rule 0: error parsing regexp"},
+		{"unsupported redirect", RewriteRule{Match: "^/a$", Replace: "/b", Redirect: http.StatusOK}, "unsupported redirect code 200"},
+		{"redirect without target", RewriteRule{Match: "^/a$", Redirect: http.StatusFound}, "redirect requires a replace target"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileRewriteRules([]RewriteRule{tt.rule})
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRewriteRuleMatches(t *testing.T) {
+	rules, err := compileRewriteRules([]RewriteRule{
+		{Match: "^/users/(?P<id>[0-9]+)$", Replace: "/v2/users/${id}", Methods: []string{"get"}},
+		{Replace: "/everything"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rule   int
+		method string
+		path   string
+		match  bool
+		result string
+	}{
+		{0, http.MethodGet, "/users/42", true, "/v2/users/42"},
+		{0, http.MethodPost, "/users/42", false, ""},
+		{0, http.MethodGet, "/users/abc", false, ""},
+		{1, http.MethodDelete, "/any", true, "/everything"},
+	}
+	for _, tt := range tests {
+		rule := &rules[tt.rule]
+		if got := rule.matches(tt.method, tt.path); got != tt.match {
+			t.Errorf("rule %d matches(%s %s) = %v, want %v", tt.rule, tt.method, tt.path, got, tt.match)
+			continue
+		}
+		if tt.match {
+			if got := rule.rewritePath(tt.path); got != tt.result {
+				t.Errorf("rule %d rewritePath(%s) = %s, want %s", tt.rule, tt.path, got, tt.result)
+			}
+		}
+	}
+}
+
+// echo answers the path, query and X-Test header it received
+func echo(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.WriteString(w, r.URL.Path+"?"+r.URL.RawQuery+" "+r.Header.Get("X-Test"))
+}
+
+func get(t *testing.T, h http.Handler, target string) *http.Response {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec.Result()
+}
+
+func body(t *testing.T, res *http.Response) string {
+	t.Helper()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRouterRewrites(t *testing.T) {
+	r := NewRouter(Config{RateLimit: 10, Rewrites: []RewriteRule{
+		{
+			Match:           "^/old/(.*)$",
+			Replace:         "/new/$1",
+			RequestHeaders:  HeaderRules{Rename: map[string]string{"X-Legacy": "X-Test"}, Add: map[string]string{"X-Test": "added"}},
+			ResponseHeaders: HeaderRules{Add: map[string]string{"X-Rewritten": "yes"}},
+			Query:           QueryRules{Set: map[string]string{"v": "2"}, Remove: []string{"debug"}},
+		},
+		{Match: "^/moved$", Replace: "/new/moved", Redirect: http.StatusPermanentRedirect},
+	}})
+	r.Get("/new/{name}", echo)
+
+	res := get(t, r.Mux(), "/old/thing?debug=1")
+	if got := body(t, res); got != "/new/thing?v=2 added" {
+		t.Errorf("rewritten request = %q", got)
+	}
+	if res.Header.Get("X-Rewritten") != "yes" {
+		t.Errorf("response headers = %v, want X-Rewritten", res.Header)
+	}
+
+	res = get(t, r.Mux(), "/moved?a=b")
+	if res.StatusCode != http.StatusPermanentRedirect || res.Header.Get("Location") != "/new/moved?a=b" {
+		t.Errorf("redirect = %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+}
+
+func TestRouterInvalidRewrites(t *testing.T) {
+	cfg := Config{RateLimit: 10, Rewrites: []RewriteRule{{Match: "("}}}
+	r := NewRouter(cfg)
+	r.Get("/ok", echo)
+	if res := get(t, r.Mux(), "/ok"); res.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, the invalid rules should be left out", res.StatusCode)
+	}
+	if err := Listen(cfg, r, nil); err == nil || !strings.Contains(err.Error(), "rewrite rule 0") {
+		t.Errorf("Listen error = %v, want the invalid rule", err)
+	}
+}
+
+func TestCompositeRewrites(t *testing.T) {
+	svcCfg := Config{RateLimit: 10, Rewrites: []RewriteRule{
+		{Match: "^/old$", Replace: "/new"},
+		{Match: "^/gone$", Replace: "/new", Redirect: http.StatusFound},
+	}}
+	svc := NewRouter(svcCfg)
+	svc.Get("/new", echo)
+
+	c := NewComposite(Config{Rewrites: []RewriteRule{{Match: "^/legacy/(.*)$", Replace: "/svc/$1"}}},
+		Service{Name: "svc", Prefix: "/svc", Config: svcCfg, Router: svc})
+
+	for target, want := range map[string]string{
+		"/svc/new":    "/svc/new? ",
+		"/svc/old":    "/svc/new? ",
+		"/legacy/old": "/svc/new? ",
+	} {
+		res := get(t, c.Mux(), target)
+		if got := body(t, res); res.StatusCode != http.StatusOK || got != want {
+			t.Errorf("%s = %d %q, want %q", target, res.StatusCode, got, want)
+		}
+	}
+
+	res := get(t, c.Mux(), "/svc/gone")
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/svc/new" {
+		t.Errorf("mounted redirect = %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+}
@@ -0,0 +1,67 @@
+// Package mock serves xserver routers answering with canned responses,
+// either derived from an OpenAPI document or replayed from recorded traffic.
+package mock
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options configures the latency and the errors injected by the mock handlers
+type Options struct {
+	// Latency is added to every response
+	Latency time.Duration
+	// Jitter is a random extra latency up to the given duration
+	Jitter time.Duration
+	// ErrorRate is the share of requests, between 0 and 1, answered with ErrorStatus
+	ErrorRate float64
+	// ErrorStatus defaults to 500
+	ErrorStatus int
+	// Seed makes the injected jitter and errors reproducible, zero uses the current time
+	Seed int64
+}
+
+// Inject returns a middleware delaying responses and failing a share of requests
+func Inject(opts Options) func(http.Handler) http.Handler {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewSource(seed))
+	status := opts.ErrorStatus
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			delay := opts.Latency
+			if opts.Jitter > 0 {
+				delay += time.Duration(rnd.Int63n(int64(opts.Jitter)))
+			}
+			fail := opts.ErrorRate > 0 && rnd.Float64() < opts.ErrorRate
+			mu.Unlock()
+
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if fail {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"error":"injected error ` + strconv.Itoa(status) + `"}`))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
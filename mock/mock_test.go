@@ -0,0 +1,107 @@
+package mock
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("ok"))
+})
+
+// failures returns which of n requests are answered with an injected error
+func failures(opts Options, n int) []bool {
+	h := Inject(opts)(ok)
+	res := make([]bool, n)
+	for i := range res {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		res[i] = rec.Body.String() != "ok"
+	}
+	return res
+}
+
+func TestInjectErrors(t *testing.T) {
+	opts := Options{ErrorRate: 0.3, Seed: 42}
+	first, second := failures(opts, 1000), failures(opts, 1000)
+	failed := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("request %d differs with the same seed", i)
+		}
+		if first[i] {
+			failed++
+		}
+	}
+	if failed < 250 || failed > 350 {
+		t.Errorf("%d of 1000 requests failed, want about 300", failed)
+	}
+
+	other := failures(Options{ErrorRate: 0.3, Seed: 7}, 1000)
+	same := true
+	for i := range first {
+		same = same && first[i] == other[i]
+	}
+	if same {
+		t.Error("another seed injected the same errors")
+	}
+
+	for _, rate := range []float64{0, 1} {
+		for i, failed := range failures(Options{ErrorRate: rate}, 50) {
+			if failed != (rate == 1) {
+				t.Fatalf("rate %v: request %d failed %v", rate, i, failed)
+			}
+		}
+	}
+}
+
+func TestInjectErrorStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   int
+		body   string
+	}{
+		{0, http.StatusInternalServerError, `{"error":"injected error 500"}`},
+		{http.StatusServiceUnavailable, http.StatusServiceUnavailable, `{"error":"injected error 503"}`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		Inject(Options{ErrorRate: 1, ErrorStatus: tt.status})(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tt.want || rec.Body.String() != tt.body || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("status %d: %d %s", tt.status, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestInjectLatency(t *testing.T) {
+	opts := Options{Latency: 10 * time.Millisecond, Jitter: 30 * time.Millisecond, Seed: 1}
+	h := Inject(opts)(ok)
+	// the jitter of a seed is reproducible, it is drawn from the same source
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	for i := 0; i < 5; i++ {
+		want := opts.Latency + time.Duration(rnd.Int63n(int64(opts.Jitter)))
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if d := time.Since(start); d < want || d > want+time.Second {
+			t.Errorf("request %d answered in %v, want %v", i, d, want)
+		}
+		if rec.Body.String() != "ok" {
+			t.Errorf("request %d: %q", i, rec.Body)
+		}
+	}
+
+	// a request cancelled while delayed is not answered
+	h = Inject(Options{Latency: time.Hour})(ok)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if rec.Body.Len() != 0 {
+		t.Errorf("a cancelled request was answered %q", rec.Body)
+	}
+}
@@ -0,0 +1,230 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l00p8/xserver"
+	"github.com/l00p8/xserver/openapi"
+)
+
+// preferCode matches the Prefer: code=404 request header selecting a documented response
+var preferCode = regexp.MustCompile(`code=(\d{3})`)
+
+// FromOpenAPI registers a handler per operation of the document answering with
+// the example of its success response, or a value generated from the schema.
+// Clients pick another documented response with the "Prefer: code=<status>" header.
+func FromOpenAPI(r xserver.Router, doc *openapi.Document, opts Options) error {
+	paths := make([]string, 0, len(doc.Paths))
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	inject := Inject(opts)
+	for _, path := range paths {
+		for _, op := range doc.Paths[path].Operations() {
+			responses, err := mockResponses(doc, op.Operation)
+			if err != nil {
+				return err
+			}
+			status, _, err := doc.SuccessResponse(op.Operation)
+			if err != nil {
+				return err
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+			h := inject(operationHandler(status, responses))
+			register(r, op.Method, path, h.ServeHTTP)
+		}
+	}
+	return nil
+}
+
+type mockResponse struct {
+	contentType string
+	body        []byte
+}
+
+func mockResponses(doc *openapi.Document, op *openapi.Operation) (map[int]mockResponse, error) {
+	res := make(map[int]mockResponse)
+	for code, r := range op.Responses {
+		status, err := strconv.Atoi(strings.Replace(strings.ToLower(code), "xx", "00", 1))
+		if err != nil {
+			continue
+		}
+		r, err := doc.ResolveResponse(r)
+		if err != nil {
+			return nil, err
+		}
+		mt := openapi.JSONContent(r.Content)
+		if mt == nil {
+			res[status] = mockResponse{}
+			continue
+		}
+		d, err := json.Marshal(exampleOf(doc, mt))
+		if err != nil {
+			return nil, err
+		}
+		res[status] = mockResponse{contentType: "application/json", body: d}
+	}
+	return res, nil
+}
+
+func operationHandler(status int, responses map[int]mockResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := status
+		if m := preferCode.FindStringSubmatch(r.Header.Get("Prefer")); m != nil {
+			if c, _ := strconv.Atoi(m[1]); c != 0 {
+				if _, ok := responses[c]; ok {
+					code = c
+				}
+			}
+		}
+		res := responses[code]
+		if res.contentType != "" {
+			w.Header().Set("Content-Type", res.contentType)
+		}
+		w.WriteHeader(code)
+		_, _ = w.Write(res.body)
+	}
+}
+
+func exampleOf(doc *openapi.Document, mt *openapi.MediaType) interface{} {
+	if mt.Example != nil {
+		return mt.Example
+	}
+	names := make([]string, 0, len(mt.Examples))
+	for name := range mt.Examples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ex := mt.Examples[name]; ex != nil && ex.Value != nil {
+			return ex.Value
+		}
+	}
+	return Generate(doc, mt.Schema)
+}
+
+// Generate builds a value matching the schema, preferring its examples, defaults and enums
+func Generate(doc *openapi.Document, s *openapi.Schema) interface{} {
+	return generate(doc, s, 0)
+}
+
+func generate(doc *openapi.Document, s *openapi.Schema, depth int) interface{} {
+	s, err := doc.ResolveSchema(s)
+	if err != nil || s == nil || depth > 8 {
+		return nil
+	}
+	switch {
+	case s.Example != nil:
+		return s.Example
+	case s.Default != nil:
+		return s.Default
+	case len(s.Enum) > 0:
+		return s.Enum[0]
+	case len(s.AllOf) > 0:
+		merged := map[string]interface{}{}
+		for _, part := range s.AllOf {
+			if m, ok := generate(doc, part, depth+1).(map[string]interface{}); ok {
+				for k, v := range m {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	case len(s.OneOf) > 0:
+		return generate(doc, s.OneOf[0], depth+1)
+	case len(s.AnyOf) > 0:
+		return generate(doc, s.AnyOf[0], depth+1)
+	}
+
+	switch s.Type {
+	case "string":
+		return generateString(s)
+	case "integer":
+		if s.Minimum != nil {
+			return int64(*s.Minimum)
+		}
+		return 0
+	case "number":
+		if s.Minimum != nil {
+			return *s.Minimum
+		}
+		return 0.0
+	case "boolean":
+		return true
+	case "array":
+		n := 1
+		if s.MinItems != nil && *s.MinItems > n {
+			n = *s.MinItems
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = generate(doc, s.Items, depth+1)
+		}
+		return items
+	}
+
+	obj := map[string]interface{}{}
+	for name, p := range s.Properties {
+		obj[name] = generate(doc, p, depth+1)
+	}
+	if len(obj) == 0 && s.Type != "object" {
+		return nil
+	}
+	return obj
+}
+
+func generateString(s *openapi.Schema) string {
+	var v string
+	switch s.Format {
+	case "date-time":
+		v = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	case "date":
+		v = "2024-01-01"
+	case "email":
+		v = "user@example.com"
+	case "uuid":
+		v = "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+	case "uri", "url":
+		v = "https://example.com"
+	case "ipv4":
+		v = "192.0.2.1"
+	default:
+		v = "string"
+	}
+	if s.MinLength != nil && len(v) < *s.MinLength {
+		v += strings.Repeat("x", *s.MinLength-len(v))
+	}
+	if s.MaxLength != nil && len(v) > *s.MaxLength {
+		v = v[:*s.MaxLength]
+	}
+	return v
+}
+
+func register(r xserver.Router, method, path string, fn http.HandlerFunc) {
+	switch method {
+	case http.MethodGet:
+		r.Get(path, fn)
+	case http.MethodPost:
+		r.Post(path, fn)
+	case http.MethodPut:
+		r.Put(path, fn)
+	case http.MethodPatch:
+		r.Patch(path, fn)
+	case http.MethodDelete:
+		r.Delete(path, fn)
+	case http.MethodHead:
+		r.Head(path, fn)
+	default:
+		r.Mux().Method(method, path, fn)
+	}
+}
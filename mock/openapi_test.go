@@ -0,0 +1,103 @@
+package mock
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/l00p8/xserver"
+	"github.com/l00p8/xserver/openapi"
+)
+
+const petstore = `{"openapi": "3.0.0", "paths": {
+	"/pets": {
+		"get": {"responses": {
+			"200": {"content": {"application/json": {"example": [{"id": 1, "name": "rex"}]}}},
+			"404": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}}},
+		"post": {"responses": {"201": {"description": "created"}}}},
+	"/pets/{id}": {
+		"get": {"responses": {
+			"2XX": {"content": {"application/json": {"examples": {
+				"b": {"value": {"id": 2}},
+				"a": {"value": {"id": 1}}}}}}}},
+		"put": {"responses": {
+			"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}}}}}},
+	"components": {"schemas": {
+		"Error": {"type": "object", "properties": {"error": {"type": "string", "example": "not found"}}},
+		"Pet": {"allOf": [
+			{"type": "object", "properties": {
+				"id": {"type": "integer", "minimum": 3},
+				"weight": {"type": "number"},
+				"kind": {"type": "string", "enum": ["dog", "cat"]},
+				"status": {"type": "string", "default": "available"}}},
+			{"type": "object", "properties": {
+				"born": {"type": "string", "format": "date"},
+				"email": {"type": "string", "format": "email"},
+				"code": {"type": "string", "minLength": 8},
+				"short": {"type": "string", "maxLength": 3},
+				"vaccinated": {"type": "boolean"},
+				"tags": {"type": "array", "minItems": 2, "items": {"type": "string", "format": "uuid"}},
+				"owner": {"oneOf": [{"$ref": "#/components/schemas/Owner"}, {"type": "string"}]},
+				"extra": {"type": "object"},
+				"unknown": {}}}]},
+		"Owner": {"type": "object", "properties": {"home": {"type": "string", "format": "uri"}}}}}}`
+
+func TestFromOpenAPI(t *testing.T) {
+	doc, err := openapi.Parse([]byte(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := xserver.NewRouter(xserver.Config{RateLimit: 10})
+	if err := FromOpenAPI(r, doc, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		prefer string
+		status int
+		body   string
+	}{
+		{"example", http.MethodGet, "/pets", "", http.StatusOK, `[{"id":1,"name":"rex"}]`},
+		{"preferred response", http.MethodGet, "/pets", "code=404", http.StatusNotFound, `{"error":"not found"}`},
+		{"undocumented preferred response", http.MethodGet, "/pets", "return=minimal, code=500", http.StatusOK, `[{"id":1,"name":"rex"}]`},
+		{"no content", http.MethodPost, "/pets", "", http.StatusCreated, ""},
+		{"first named example", http.MethodGet, "/pets/7", "", http.StatusOK, `{"id":1}`},
+		{"generated from the schema", http.MethodPut, "/pets/7", "", http.StatusOK,
+			`{"born":"2024-01-01","code":"stringxx","email":"user@example.com","extra":{},"id":3,` +
+				`"kind":"dog","owner":{"home":"https://example.com"},"short":"str","status":"available",` +
+				`"tags":["3fa85f64-5717-4562-b3fc-2c963f66afa6","3fa85f64-5717-4562-b3fc-2c963f66afa6"],` +
+				`"unknown":null,"vaccinated":true,"weight":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+			rec := httptest.NewRecorder()
+			r.Mux().ServeHTTP(rec, req)
+
+			if rec.Code != tt.status || rec.Body.String() != tt.body {
+				t.Errorf("%d %s, want %d %s", rec.Code, rec.Body, tt.status, tt.body)
+			}
+			ct := rec.Header().Get("Content-Type")
+			if tt.body != "" && ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
+
+func TestFromOpenAPIUnresolved(t *testing.T) {
+	doc, err := openapi.Parse([]byte(`{"openapi": "3.0.0", "paths": {"/a": {"get": {"responses": {
+		"200": {"$ref": "#/components/responses/Missing"}}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := FromOpenAPI(xserver.NewRouter(xserver.Config{}), doc, Options{}); err == nil {
+		t.Error("expected an error for an unresolved response")
+	}
+}
@@ -0,0 +1,187 @@
+package mock
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/l00p8/xserver"
+)
+
+// Base64 is the encoding of the recorded bodies which are not valid UTF-8
+const Base64 = "base64"
+
+// Interaction is a recorded request and its response, stored as one json object per line.
+// The bodies hold the raw bytes, they are stored base64 encoded when they are not text.
+type Interaction struct {
+	Method              string      `json:"method"`
+	URL                 string      `json:"url"`
+	RequestHeader       http.Header `json:"request_header,omitempty"`
+	RequestBody         string      `json:"request_body,omitempty"`
+	RequestBodyEncoding string      `json:"request_body_encoding,omitempty"`
+	Status              int         `json:"status"`
+	Header              http.Header `json:"header,omitempty"`
+	Body                string      `json:"body,omitempty"`
+	BodyEncoding        string      `json:"body_encoding,omitempty"`
+}
+
+func encodeBody(body string) (string, string) {
+	if utf8.ValidString(body) {
+		return body, ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(body)), Base64
+}
+
+func decodeBody(body, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return body, nil
+	case Base64:
+		b, err := base64.StdEncoding.DecodeString(body)
+		return string(b), err
+	default:
+		return "", fmt.Errorf("unknown body encoding %q", encoding)
+	}
+}
+
+// WriteRecording encodes the interactions as a JSONL traffic recording
+func WriteRecording(w io.Writer, interactions []Interaction) error {
+	enc := json.NewEncoder(w)
+	for _, in := range interactions {
+		in.RequestBody, in.RequestBodyEncoding = encodeBody(in.RequestBody)
+		in.Body, in.BodyEncoding = encodeBody(in.Body)
+		if err := enc.Encode(in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRecording decodes a JSONL traffic recording, the bodies are decoded
+func ReadRecording(r io.Reader) ([]Interaction, error) {
+	var res []Interaction
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		var in Interaction
+		if err := json.Unmarshal([]byte(text), &in); err != nil {
+			return nil, fmt.Errorf("mock: recording line %d: %w", line, err)
+		}
+		var err error
+		if in.RequestBody, err = decodeBody(in.RequestBody, in.RequestBodyEncoding); err != nil {
+			return nil, fmt.Errorf("mock: recording line %d: request body: %w", line, err)
+		}
+		if in.Body, err = decodeBody(in.Body, in.BodyEncoding); err != nil {
+			return nil, fmt.Errorf("mock: recording line %d: body: %w", line, err)
+		}
+		in.RequestBodyEncoding, in.BodyEncoding = "", ""
+		if in.Method == "" {
+			in.Method = http.MethodGet
+		}
+		if in.Status == 0 {
+			in.Status = http.StatusOK
+		}
+		res = append(res, in)
+	}
+	return res, sc.Err()
+}
+
+// FromRecording registers the recorded interactions on the router. Requests are answered
+// with the interaction of the same method, path and query, when there is none the
+// interactions of the path are replayed in turn.
+func FromRecording(r xserver.Router, recording io.Reader, opts Options) error {
+	interactions, err := ReadRecording(recording)
+	if err != nil {
+		return err
+	}
+
+	routes := map[string]*replay{}
+	var order []string
+	for _, in := range interactions {
+		u, err := url.Parse(in.URL)
+		if err != nil {
+			return fmt.Errorf("mock: recorded url %q: %w", in.URL, err)
+		}
+		key := in.Method + " " + u.Path
+		rp, ok := routes[key]
+		if !ok {
+			rp = &replay{method: in.Method, path: u.Path}
+			routes[key] = rp
+			order = append(order, key)
+		}
+		rp.interactions = append(rp.interactions, recorded{Interaction: in, query: u.Query()})
+	}
+
+	inject := Inject(opts)
+	for _, key := range order {
+		rp := routes[key]
+		register(r, rp.method, rp.path, inject(rp).ServeHTTP)
+	}
+	return nil
+}
+
+type recorded struct {
+	Interaction
+	query url.Values
+}
+
+type replay struct {
+	method       string
+	path         string
+	interactions []recorded
+
+	mu   sync.Mutex
+	next int
+}
+
+func (rp *replay) pick(query url.Values) recorded {
+	for _, in := range rp.interactions {
+		if sameValues(in.query, query) {
+			return in
+		}
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	in := rp.interactions[rp.next%len(rp.interactions)]
+	rp.next++
+	return in
+}
+
+func (rp *replay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	in := rp.pick(r.URL.Query())
+	for k, v := range in.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Del("Content-Length")
+	w.WriteHeader(in.Status)
+	_, _ = io.WriteString(w, in.Body)
+}
+
+func sameValues(a, b url.Values) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if av[i] != bv[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
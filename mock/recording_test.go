@@ -0,0 +1,150 @@
+package mock
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/l00p8/xserver"
+)
+
+func TestRecordingRoundTrip(t *testing.T) {
+	binary := "\x89PNG\r\n\x1a\n\x00\xff"
+	in := []Interaction{
+		{Method: http.MethodPost, URL: "/upload", RequestBody: binary, Status: http.StatusCreated, Body: `{"id":1}`},
+		{Method: http.MethodGet, URL: "/image", Status: http.StatusOK, Body: binary,
+			Header: http.Header{"Content-Type": {"image/png"}}},
+	}
+	var buf bytes.Buffer
+	if err := WriteRecording(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want 2", len(lines))
+	}
+	if !strings.Contains(lines[0], `"request_body_encoding":"base64"`) || strings.Contains(lines[0], `"body_encoding"`) {
+		t.Errorf("only the binary request body is encoded: %s", lines[0])
+	}
+	if !strings.Contains(lines[1], `"body_encoding":"base64"`) {
+		t.Errorf("the binary body is not encoded: %s", lines[1])
+	}
+
+	out, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].RequestBody != binary || out[0].Body != `{"id":1}` || out[1].Body != binary {
+		t.Fatalf("read %+v", out)
+	}
+	if out[1].BodyEncoding != "" || out[0].RequestBodyEncoding != "" {
+		t.Errorf("the encodings are kept once decoded: %+v", out)
+	}
+	if in[1].Body != binary {
+		t.Error("WriteRecording modified its input")
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Interaction
+		err  string
+	}{
+		{
+			name: "defaults and blank lines",
+			data: "{\"url\": \"/a\"}\n\n  \n{\"method\": \"DELETE\", \"url\": \"/b\", \"status\": 204}\n",
+			want: []Interaction{
+				{Method: http.MethodGet, URL: "/a", Status: http.StatusOK},
+				{Method: http.MethodDelete, URL: "/b", Status: http.StatusNoContent},
+			},
+		},
+		{name: "invalid json", data: "{\"url\": \"/a\"}\n{", err: "mock: recording line 2"},
+		{name: "invalid base64", data: `{"url": "/a", "body": "%%", "body_encoding": "base64"}`, err: "mock: recording line 1: body"},
+		{name: "unknown encoding", data: `{"url": "/a", "request_body": "x", "request_body_encoding": "gzip"}`,
+			err: `mock: recording line 1: request body: unknown body encoding "gzip"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRecording(strings.NewReader(tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("%+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Method != tt.want[i].Method || got[i].URL != tt.want[i].URL || got[i].Status != tt.want[i].Status {
+					t.Errorf("interaction %d: %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFromRecording(t *testing.T) {
+	recording := `{"url": "/items?page=1", "body": "one", "header": {"Content-Type": ["text/plain"], "Content-Length": ["3"]}}
+{"url": "/items?page=2&sort=name", "body": "two"}
+{"url": "/items?page=3", "status": 500, "body": "three"}
+{"method": "POST", "url": "/items", "status": 201, "body": "created"}`
+	r := xserver.NewRouter(xserver.Config{RateLimit: 10})
+	if err := FromRecording(r, strings.NewReader(recording), Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.Mux().ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	// the query selects the interaction, whatever the order of the parameters
+	rec := serve(http.MethodGet, "/items?sort=name&page=2")
+	if rec.Code != http.StatusOK || rec.Body.String() != "two" {
+		t.Errorf("matching query: %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(http.MethodGet, "/items?page=3")
+	if rec.Code != http.StatusInternalServerError || rec.Body.String() != "three" {
+		t.Errorf("recorded status: %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(http.MethodGet, "/items?page=1")
+	if rec.Header().Get("Content-Type") != "text/plain" || rec.Header().Get("Content-Length") != "" {
+		t.Errorf("headers %v, want the recorded ones without Content-Length", rec.Header())
+	}
+
+	// the interactions of the path are replayed in turn when no query matches
+	var bodies []string
+	for i := 0; i < 4; i++ {
+		bodies = append(bodies, serve(http.MethodGet, "/items?page=9").Body.String())
+	}
+	if got := strings.Join(bodies, " "); got != "one two three one" {
+		t.Errorf("fallback replayed %q", got)
+	}
+	if got := serve(http.MethodGet, "/items?page=1&page=2").Body.String(); got != "two" {
+		t.Errorf("a repeated parameter matched an interaction, got %q", got)
+	}
+
+	rec = serve(http.MethodPost, "/items")
+	if rec.Code != http.StatusCreated || rec.Body.String() != "created" {
+		t.Errorf("method: %d %s", rec.Code, rec.Body)
+	}
+	if rec = serve(http.MethodGet, "/other"); rec.Code != http.StatusNotFound {
+		t.Errorf("unrecorded path: %d", rec.Code)
+	}
+}
+
+func TestFromRecordingInvalidURL(t *testing.T) {
+	err := FromRecording(xserver.NewRouter(xserver.Config{}), strings.NewReader(`{"url": "/%zz"}`), Options{})
+	if err == nil || !strings.HasPrefix(err.Error(), "mock: recorded url") {
+		t.Errorf("error %v", err)
+	}
+}
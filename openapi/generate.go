@@ -0,0 +1,726 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Generate renders the Go code serving the document on an xserver Router:
+// a type per schema, a Server interface with a method per operation and
+// a Register function binding and validating requests before calling it.
+func Generate(doc *Document, pkg string) ([]byte, error) {
+	g := &generator{
+		doc:     doc,
+		names:   map[string]*Schema{},
+		imports: map[string]bool{"context": true, "net/http": true},
+	}
+	return g.run(pkg)
+}
+
+type generator struct {
+	doc      *Document
+	types    bytes.Buffer
+	handlers bytes.Buffer
+	names    map[string]*Schema
+	queue    []namedSchema
+	imports  map[string]bool
+	patterns []string
+}
+
+type namedSchema struct {
+	name   string
+	schema *Schema
+	params bool
+}
+
+type genOperation struct {
+	name    string
+	method  string
+	path    string
+	summary string
+	params  []genParam
+	body    *genBody
+	status  int
+	result  string
+}
+
+type genParam struct {
+	name string
+	// key names the parameter in the Params type and the errors, it is qualified
+	// by the location when the operation has another parameter of the same name
+	key      string
+	field    string
+	in       string
+	goType   string
+	required bool
+	array    bool
+}
+
+type genBody struct {
+	goType   string
+	schema   *Schema
+	required bool
+	raw      bool
+}
+
+func (g *generator) run(pkg string) ([]byte, error) {
+	schemaNames := make([]string, 0, len(g.doc.Components.Schemas))
+	for name := range g.doc.Components.Schemas {
+		schemaNames = append(schemaNames, name)
+	}
+	sort.Strings(schemaNames)
+	for _, name := range schemaNames {
+		s := g.doc.Components.Schemas[name]
+		g.enqueue(goName(name), s)
+	}
+
+	ops, err := g.operations()
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return nil, errors.New("openapi: the document has no operations")
+	}
+
+	for len(g.queue) > 0 {
+		n := g.queue[0]
+		g.queue = g.queue[1:]
+		if err := g.emitType(n); err != nil {
+			return nil, err
+		}
+	}
+
+	g.emitServer(ops)
+	for _, op := range ops {
+		g.emitHandler(op)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by xserver openapi. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "// Package %s implements %s %s\n", pkg, g.doc.Info.Title, g.doc.Info.Version)
+	fmt.Fprintf(&out, "package %s\n\nimport (\n", pkg)
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(&out, "\t%q\n", imp)
+	}
+	fmt.Fprintf(&out, "\n\t\"github.com/l00p8/xserver\"\n\t\"github.com/l00p8/xserver/openapi\"\n)\n\n")
+	if len(g.patterns) > 0 {
+		out.WriteString("var (\n")
+		for i, p := range g.patterns {
+			fmt.Fprintf(&out, "\tpattern%d = regexp.MustCompile(%q)\n", i, p)
+		}
+		out.WriteString(")\n\n")
+	}
+	out.Write(g.types.Bytes())
+	out.Write(g.handlers.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return out.Bytes(), fmt.Errorf("openapi: format generated code: %w", err)
+	}
+	return src, nil
+}
+
+func (g *generator) operations() ([]genOperation, error) {
+	paths := make([]string, 0, len(g.doc.Paths))
+	for p := range g.doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var ops []genOperation
+	seen := map[string]bool{}
+	for _, path := range paths {
+		item := g.doc.Paths[path]
+		for _, mo := range item.Operations() {
+			op, err := g.operation(path, item, mo)
+			if err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %w", mo.Method, path, err)
+			}
+			if seen[op.name] {
+				return nil, fmt.Errorf("openapi: %s %s: duplicate operation %s", mo.Method, path, op.name)
+			}
+			seen[op.name] = true
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+func (g *generator) operation(path string, item *PathItem, mo MethodOperation) (genOperation, error) {
+	op := genOperation{method: mo.Method, path: path, summary: mo.Summary}
+	if mo.OperationID != "" {
+		op.name = goName(mo.OperationID)
+	} else {
+		op.name = goName(strings.ToLower(mo.Method) + " " + path)
+	}
+
+	params, err := g.doc.OperationParameters(item, mo.Operation)
+	if err != nil {
+		return op, err
+	}
+	if len(params) > 0 {
+		synthetic := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		named := map[string]int{}
+		for _, p := range params {
+			named[goName(p.Name)]++
+		}
+		fields := map[string]bool{}
+		for _, p := range params {
+			s := p.Schema
+			if s == nil {
+				s = &Schema{Type: "string"}
+			}
+			resolved, err := g.doc.ResolveSchema(s)
+			if err != nil {
+				return op, err
+			}
+			key := p.Name
+			if named[goName(p.Name)] > 1 {
+				key = p.In + "." + p.Name
+			}
+			gp := genParam{name: p.Name, key: key, field: goName(key), in: p.In, required: p.Required || p.In == InPath}
+			if fields[gp.field] {
+				return op, fmt.Errorf("parameter %s in %s: its Go name %s is already used", p.Name, p.In, gp.field)
+			}
+			fields[gp.field] = true
+			elem := resolved
+			if resolved.Type == "array" {
+				gp.array = true
+				if elem, err = g.doc.ResolveSchema(resolved.Items); err != nil || elem == nil {
+					return op, fmt.Errorf("parameter %s: array items are required", p.Name)
+				}
+			}
+			gp.goType = g.typeOf(elem, op.name+gp.field)
+			if parseFunc(gp.goType) == "" {
+				return op, fmt.Errorf("parameter %s: unsupported schema", p.Name)
+			}
+			synthetic.Properties[key] = s
+			if gp.required {
+				synthetic.Required = append(synthetic.Required, key)
+			}
+			op.params = append(op.params, gp)
+		}
+		name, _ := g.reserve(op.name+"Params", synthetic)
+		g.queue = append(g.queue, namedSchema{name: name, schema: synthetic, params: true})
+	}
+
+	body, err := g.doc.ResolveRequestBody(mo.RequestBody)
+	if err != nil {
+		return op, err
+	}
+	if body != nil {
+		if mt := JSONContent(body.Content); mt != nil && mt.Schema != nil {
+			op.body = &genBody{goType: g.typeOf(mt.Schema, op.name+"Request"), schema: mt.Schema, required: body.Required}
+		} else if len(body.Content) > 0 {
+			g.imports["io"] = true
+			op.body = &genBody{goType: "io.Reader", raw: true}
+		}
+	}
+
+	status, res, err := g.doc.SuccessResponse(mo.Operation)
+	if err != nil {
+		return op, err
+	}
+	op.status = status
+	if op.status == 0 {
+		op.status = 200
+	}
+	if res != nil {
+		if mt := JSONContent(res.Content); mt != nil && mt.Schema != nil {
+			op.result = g.typeOf(mt.Schema, op.name+"Response")
+		}
+	}
+	return op, nil
+}
+
+// reserve returns the type name of the schema, reporting whether it is a new one
+func (g *generator) reserve(name string, s *Schema) (string, bool) {
+	candidate := name
+	for i := 2; ; i++ {
+		existing, ok := g.names[candidate]
+		if !ok {
+			g.names[candidate] = s
+			return candidate, true
+		}
+		if existing == s {
+			return candidate, false
+		}
+		candidate = name + strconv.Itoa(i)
+	}
+}
+
+func (g *generator) enqueue(name string, s *Schema) string {
+	name, isNew := g.reserve(name, s)
+	if isNew {
+		g.queue = append(g.queue, namedSchema{name: name, schema: s})
+	}
+	return name
+}
+
+func isStruct(s *Schema) bool {
+	return s != nil && len(s.Properties) > 0 && len(s.AllOf)+len(s.OneOf)+len(s.AnyOf) == 0
+}
+
+// typeOf returns the Go type of a schema, inline objects become named types
+func (g *generator) typeOf(s *Schema, hint string) string {
+	if s == nil {
+		return "interface{}"
+	}
+	if s.Ref != "" {
+		name := goName(refName(s.Ref))
+		if target, ok := g.doc.Components.Schemas[refName(s.Ref)]; ok {
+			return g.enqueue(name, target)
+		}
+		return name
+	}
+	switch s.Type {
+	case "array":
+		return "[]" + g.typeOf(s.Items, hint+"Item")
+	case "string":
+		switch s.Format {
+		case "date-time":
+			g.imports["time"] = true
+			return "time.Time"
+		case "byte":
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		if s.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		if s.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	}
+	if len(s.AllOf)+len(s.OneOf)+len(s.AnyOf) > 0 {
+		g.imports["encoding/json"] = true
+		return "json.RawMessage"
+	}
+	if len(s.Properties) > 0 {
+		return g.enqueue(hint, s)
+	}
+	if a := s.Additional(); a != nil {
+		return "map[string]" + g.typeOf(a, hint+"Value")
+	}
+	if s.Type == "object" {
+		return "map[string]interface{}"
+	}
+	return "interface{}"
+}
+
+func (g *generator) fieldType(s *Schema, hint string, required bool) string {
+	t := g.typeOf(s, hint)
+	if required && (s == nil || !s.Nullable) {
+		return t
+	}
+	if strings.HasPrefix(t, "[]") || strings.HasPrefix(t, "map[") || t == "interface{}" || t == "json.RawMessage" {
+		return t
+	}
+	return "*" + t
+}
+
+func (g *generator) emitType(n namedSchema) error {
+	w := &g.types
+	s := n.schema
+	if s.Description != "" {
+		writeComment(w, n.name+" "+s.Description)
+	} else if n.params {
+		writeComment(w, n.name+" holds the parameters of "+strings.TrimSuffix(n.name, "Params"))
+	}
+
+	if !isStruct(s) {
+		// non object schemas are aliases, their constraints are checked where they are used
+		fmt.Fprintf(w, "type %s = %s\n\n", n.name, g.typeOf(s, n.name+"Value"))
+		return nil
+	}
+
+	props := make([]string, 0, len(s.Properties))
+	for p := range s.Properties {
+		props = append(props, p)
+	}
+	sort.Strings(props)
+
+	fmt.Fprintf(w, "type %s struct {\n", n.name)
+	for _, p := range props {
+		ps := s.Properties[p]
+		if ps.Description != "" {
+			writeComment(w, ps.Description)
+		}
+		tag := p
+		if !s.IsRequired(p) {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(w, "\t%s %s `json:%q`\n", goName(p), g.fieldType(ps, n.name+goName(p), s.IsRequired(p)), tag)
+	}
+	w.WriteString("}\n\n")
+
+	if len(s.Required) > 0 && !n.params {
+		g.imports["encoding/json"] = true
+		fmt.Fprintf(w, "func (v *%s) UnmarshalJSON(data []byte) error {\n", n.name)
+		fmt.Fprintf(w, "\tif err := openapi.CheckRequired(data")
+		for _, r := range s.Required {
+			fmt.Fprintf(w, ", %q", r)
+		}
+		w.WriteString("); err != nil {\n\t\treturn err\n\t}\n")
+		fmt.Fprintf(w, "\ttype plain %s\n\treturn json.Unmarshal(data, (*plain)(v))\n}\n\n", n.name)
+	}
+
+	fmt.Fprintf(w, "func (v *%s) Validate() error {\n\tvar errs openapi.Errors\n", n.name)
+	for _, p := range props {
+		ps := s.Properties[p]
+		if !g.needsValidation(ps, 0) {
+			continue
+		}
+		expr := "v." + goName(p)
+		if strings.HasPrefix(g.fieldType(ps, n.name+goName(p), s.IsRequired(p)), "*") {
+			fmt.Fprintf(w, "\tif %s != nil {\n", expr)
+			g.validation(w, "(*"+expr+")", strconv.Quote(p), ps, 0)
+			w.WriteString("\t}\n")
+		} else {
+			g.validation(w, expr, strconv.Quote(p), ps, 0)
+		}
+	}
+	w.WriteString("\treturn errs.Err()\n}\n\n")
+	return nil
+}
+
+// needsValidation reports whether values of the schema have constraints to check
+func (g *generator) needsValidation(s *Schema, depth int) bool {
+	if s == nil || depth > 8 {
+		return false
+	}
+	resolved, err := g.doc.ResolveSchema(s)
+	if err != nil || resolved == nil {
+		return false
+	}
+	if isStruct(resolved) {
+		return true
+	}
+	switch resolved.Type {
+	case "string":
+		return resolved.Format != "date-time" && resolved.Format != "byte" &&
+			(resolved.MinLength != nil || resolved.MaxLength != nil || resolved.Pattern != "" || len(resolved.Enum) > 0)
+	case "integer", "number":
+		return resolved.Minimum != nil || resolved.Maximum != nil
+	case "array":
+		return resolved.MinItems != nil || resolved.MaxItems != nil || g.needsValidation(resolved.Items, depth+1)
+	}
+	if a := resolved.Additional(); a != nil {
+		return g.needsValidation(a, depth+1)
+	}
+	return false
+}
+
+// validation writes the checks of the value expr, field is a Go expression of its path
+func (g *generator) validation(w *bytes.Buffer, expr, field string, s *Schema, depth int) {
+	resolved, err := g.doc.ResolveSchema(s)
+	if err != nil || resolved == nil || depth > 8 {
+		return
+	}
+	if isStruct(resolved) {
+		fmt.Fprintf(w, "\tif err := %s.Validate(); err != nil {\n\t\terrs.Merge(%s, err)\n\t}\n", expr, field)
+		return
+	}
+
+	switch resolved.Type {
+	case "string":
+		if resolved.Format == "date-time" || resolved.Format == "byte" {
+			return
+		}
+		if resolved.MinLength != nil {
+			g.imports["unicode/utf8"] = true
+			fmt.Fprintf(w, "\tif utf8.RuneCountInString(%s) < %d {\n\t\terrs.Add(%s, \"must be at least %d characters long\")\n\t}\n",
+				expr, *resolved.MinLength, field, *resolved.MinLength)
+		}
+		if resolved.MaxLength != nil {
+			g.imports["unicode/utf8"] = true
+			fmt.Fprintf(w, "\tif utf8.RuneCountInString(%s) > %d {\n\t\terrs.Add(%s, \"must be at most %d characters long\")\n\t}\n",
+				expr, *resolved.MaxLength, field, *resolved.MaxLength)
+		}
+		if resolved.Pattern != "" {
+			g.imports["regexp"] = true
+			g.patterns = append(g.patterns, resolved.Pattern)
+			fmt.Fprintf(w, "\tif !pattern%d.MatchString(%s) {\n\t\terrs.Add(%s, %q)\n\t}\n",
+				len(g.patterns)-1, expr, field, "must match "+resolved.Pattern)
+		}
+		if len(resolved.Enum) > 0 {
+			var values []string
+			for _, e := range resolved.Enum {
+				if str, ok := e.(string); ok {
+					values = append(values, strconv.Quote(str))
+				}
+			}
+			if len(values) > 0 {
+				fmt.Fprintf(w, "\tswitch %s {\n\tcase %s:\n\tdefault:\n\t\terrs.Add(%s, %q)\n\t}\n",
+					expr, strings.Join(values, ", "), field, "must be one of "+strings.Join(values, ", "))
+			}
+		}
+	case "integer", "number":
+		if resolved.Minimum != nil {
+			op, msg := "<", "must be at least "
+			if resolved.ExclusiveMinimum {
+				op, msg = "<=", "must be greater than "
+			}
+			min := strconv.FormatFloat(*resolved.Minimum, 'g', -1, 64)
+			fmt.Fprintf(w, "\tif float64(%s) %s %s {\n\t\terrs.Add(%s, %q)\n\t}\n", expr, op, min, field, msg+min)
+		}
+		if resolved.Maximum != nil {
+			op, msg := ">", "must be at most "
+			if resolved.ExclusiveMaximum {
+				op, msg = ">=", "must be less than "
+			}
+			max := strconv.FormatFloat(*resolved.Maximum, 'g', -1, 64)
+			fmt.Fprintf(w, "\tif float64(%s) %s %s {\n\t\terrs.Add(%s, %q)\n\t}\n", expr, op, max, field, msg+max)
+		}
+	case "array":
+		if resolved.MinItems != nil {
+			fmt.Fprintf(w, "\tif len(%s) < %d {\n\t\terrs.Add(%s, \"must have at least %d items\")\n\t}\n",
+				expr, *resolved.MinItems, field, *resolved.MinItems)
+		}
+		if resolved.MaxItems != nil {
+			fmt.Fprintf(w, "\tif len(%s) > %d {\n\t\terrs.Add(%s, \"must have at most %d items\")\n\t}\n",
+				expr, *resolved.MaxItems, field, *resolved.MaxItems)
+		}
+		if g.needsValidation(resolved.Items, depth+1) {
+			g.imports["strconv"] = true
+			i := "i" + strconv.Itoa(depth)
+			fmt.Fprintf(w, "\tfor %s := range %s {\n", i, expr)
+			g.validation(w, expr+"["+i+"]", field+`+"["+strconv.Itoa(`+i+`)+"]"`, resolved.Items, depth+1)
+			w.WriteString("\t}\n")
+		}
+	default:
+		if a := resolved.Additional(); a != nil && g.needsValidation(a, depth+1) {
+			k := "k" + strconv.Itoa(depth)
+			item := "item" + strconv.Itoa(depth)
+			fmt.Fprintf(w, "\tfor %s := range %s {\n\t\t%s := %s[%s]\n", k, expr, item, expr, k)
+			g.validation(w, item, field+`+"."+`+k, a, depth+1)
+			w.WriteString("\t}\n")
+		}
+	}
+}
+
+func (g *generator) emitServer(ops []genOperation) {
+	w := &g.handlers
+	w.WriteString("// Server is implemented to serve the API\ntype Server interface {\n")
+	for _, op := range ops {
+		if op.summary != "" {
+			writeComment(w, op.name+" "+lowerFirst(op.summary))
+		}
+		fmt.Fprintf(w, "\t%s(%s) ", op.name, op.signature())
+		if op.result != "" {
+			fmt.Fprintf(w, "(%s, error)\n", op.result)
+		} else {
+			w.WriteString("error\n")
+		}
+	}
+	w.WriteString("}\n\n")
+
+	w.WriteString("// Register wires the operations of s onto the router\nfunc Register(r xserver.Router, s Server) {\n")
+	for _, op := range ops {
+		h := lowerFirst(op.name) + "Handler(s)"
+		if m, ok := routerMethods[op.method]; ok {
+			fmt.Fprintf(w, "\tr.%s(%q, %s)\n", m, op.path, h)
+		} else {
+			fmt.Fprintf(w, "\tr.Mux().Method(%q, %q, %s)\n", op.method, op.path, h)
+		}
+	}
+	w.WriteString("}\n\n")
+}
+
+// routerMethods maps http methods to the registration methods of xserver.Router
+var routerMethods = map[string]string{
+	"GET": "Get", "POST": "Post", "PUT": "Put", "PATCH": "Patch", "DELETE": "Delete", "HEAD": "Head",
+}
+
+func (op genOperation) signature() string {
+	args := []string{"ctx context.Context"}
+	if len(op.params) > 0 {
+		args = append(args, "params "+op.name+"Params")
+	}
+	if op.body != nil {
+		t := op.body.goType
+		if !op.body.required && !op.body.raw && !strings.HasPrefix(t, "[]") && !strings.HasPrefix(t, "map[") {
+			t = "*" + t
+		}
+		args = append(args, "body "+t)
+	}
+	return strings.Join(args, ", ")
+}
+
+func (g *generator) emitHandler(op genOperation) {
+	w := &g.handlers
+	fmt.Fprintf(w, "func %sHandler(s Server) http.HandlerFunc {\n", lowerFirst(op.name))
+	w.WriteString("\treturn func(w http.ResponseWriter, r *http.Request) {\n")
+
+	args := []string{"r.Context()"}
+	if len(op.params) > 0 {
+		fmt.Fprintf(w, "\tvar params %sParams\n\tvar errs openapi.Errors\n", op.name)
+		for _, p := range op.params {
+			g.emitParam(w, p)
+		}
+		w.WriteString("\tif err := errs.Err(); err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t}\n")
+		w.WriteString("\tif err := params.Validate(); err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t}\n")
+		args = append(args, "params")
+	}
+
+	if b := op.body; b != nil {
+		switch {
+		case b.raw:
+			w.WriteString("\tbody := r.Body\n")
+		case b.required:
+			fmt.Fprintf(w, "\tvar body %s\n", b.goType)
+			w.WriteString("\tif ok, err := openapi.DecodeJSON(r, &body); err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t} else if !ok {\n")
+			w.WriteString("\t\topenapi.WriteError(w, openapi.NewError(http.StatusBadRequest, \"request body is required\"))\n\t\treturn\n\t}\n")
+			g.emitBodyValidation(w, "body", b)
+		case strings.HasPrefix(b.goType, "[]") || strings.HasPrefix(b.goType, "map["):
+			fmt.Fprintf(w, "\tvar body %s\n", b.goType)
+			w.WriteString("\tif _, err := openapi.DecodeJSON(r, &body); err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t}\n")
+			g.emitBodyValidation(w, "body", b)
+		default:
+			fmt.Fprintf(w, "\tvar body *%s\n\tvar decoded %s\n", b.goType, b.goType)
+			w.WriteString("\tif ok, err := openapi.DecodeJSON(r, &decoded); err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t} else if ok {\n")
+			g.emitBodyValidation(w, "decoded", b)
+			w.WriteString("\t\tbody = &decoded\n\t}\n")
+		}
+		args = append(args, "body")
+	}
+
+	call := fmt.Sprintf("s.%s(%s)", op.name, strings.Join(args, ", "))
+	if op.result != "" {
+		fmt.Fprintf(w, "\tres, err := %s\n", call)
+		w.WriteString("\tif err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t}\n")
+		fmt.Fprintf(w, "\topenapi.WriteJSON(w, %d, res)\n", op.status)
+	} else {
+		fmt.Fprintf(w, "\tif err := %s; err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t}\n", call)
+		fmt.Fprintf(w, "\tw.WriteHeader(%d)\n", op.status)
+	}
+	w.WriteString("\t}\n}\n\n")
+}
+
+// emitBodyValidation checks bodies which are not structs, DecodeJSON validates structs itself
+func (g *generator) emitBodyValidation(w *bytes.Buffer, expr string, b *genBody) {
+	resolved, err := g.doc.ResolveSchema(b.schema)
+	if err != nil || isStruct(resolved) || !g.needsValidation(b.schema, 0) {
+		return
+	}
+	w.WriteString("\t{\n\tvar errs openapi.Errors\n")
+	g.validation(w, expr, `"body"`, b.schema, 0)
+	w.WriteString("\tif err := errs.Err(); err != nil {\n\t\topenapi.WriteError(w, err)\n\t\treturn\n\t}\n\t}\n")
+}
+
+func (g *generator) emitParam(w *bytes.Buffer, p genParam) {
+	target := "params." + p.field
+	if p.array {
+		fmt.Fprintf(w, "\tif raws, ok := openapi.ParamValues(r, %q, %q); ok {\n", p.in, p.name)
+		w.WriteString("\t\tfor _, raw := range raws {\n")
+		fmt.Fprintf(w, "\t\t\tx, err := openapi.%s(raw)\n", parseFunc(p.goType))
+		fmt.Fprintf(w, "\t\t\tif err != nil {\n\t\t\t\terrs.Add(%q, err.Error())\n\t\t\t\tbreak\n\t\t\t}\n", p.key)
+		fmt.Fprintf(w, "\t\t\t%s = append(%s, x)\n\t\t}\n", target, target)
+	} else {
+		fmt.Fprintf(w, "\tif raw, ok := openapi.Param(r, %q, %q); ok {\n", p.in, p.name)
+		fmt.Fprintf(w, "\t\tx, err := openapi.%s(raw)\n", parseFunc(p.goType))
+		fmt.Fprintf(w, "\t\tif err != nil {\n\t\t\terrs.Add(%q, err.Error())\n\t\t} else {\n", p.key)
+		if p.required {
+			fmt.Fprintf(w, "\t\t\t%s = x\n", target)
+		} else {
+			fmt.Fprintf(w, "\t\t\t%s = &x\n", target)
+		}
+		w.WriteString("\t\t}\n")
+	}
+	if p.required {
+		fmt.Fprintf(w, "\t} else {\n\t\terrs.Add(%q, \"is required\")\n\t}\n", p.key)
+	} else {
+		w.WriteString("\t}\n")
+	}
+}
+
+func parseFunc(goType string) string {
+	switch goType {
+	case "string":
+		return "ParseString"
+	case "int32":
+		return "ParseInt32"
+	case "int64":
+		return "ParseInt64"
+	case "float32":
+		return "ParseFloat32"
+	case "float64":
+		return "ParseFloat64"
+	case "bool":
+		return "ParseBool"
+	case "time.Time":
+		return "ParseTime"
+	}
+	return ""
+}
+
+var initialisms = map[string]string{
+	"id": "ID", "url": "URL", "uri": "URI", "http": "HTTP", "api": "API",
+	"json": "JSON", "uuid": "UUID", "ip": "IP", "sql": "SQL", "xml": "XML",
+}
+
+// goName converts an OpenAPI name to an exported Go identifier
+func goName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, word := range words {
+		// split camelCase words
+		start := 0
+		runes := []rune(word)
+		for i := 1; i <= len(runes); i++ {
+			if i == len(runes) || (unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i-1])) {
+				part := string(runes[start:i])
+				if init, ok := initialisms[strings.ToLower(part)]; ok {
+					b.WriteString(init)
+				} else {
+					r := []rune(part)
+					r[0] = unicode.ToUpper(r[0])
+					b.WriteString(string(r))
+				}
+				start = i
+			}
+		}
+	}
+	res := b.String()
+	if res == "" {
+		return "X"
+	}
+	if unicode.IsDigit([]rune(res)[0]) {
+		res = "X" + res
+	}
+	return res
+}
+
+func lowerFirst(s string) string {
+	r := []rune(s)
+	if len(r) == 0 {
+		return s
+	}
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func writeComment(w *bytes.Buffer, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fmt.Fprintf(w, "// %s\n", strings.TrimSpace(line))
+	}
+}
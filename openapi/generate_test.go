@@ -0,0 +1,93 @@
+package openapi
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	docs, err := filepath.Glob("testdata/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range docs {
+		name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			doc, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Generate(doc, "api")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("generated code differs from %s, run go test -update to accept it:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{
+			name: "no operations",
+			doc:  `{"openapi": "3.0.0", "paths": {}}`,
+			err:  "the document has no operations",
+		},
+		{
+			name: "duplicate operation",
+			doc: `{"openapi": "3.0.0", "paths": {
+				"/a": {"get": {"operationId": "op", "responses": {}}},
+				"/b": {"get": {"operationId": "op", "responses": {}}}}}`,
+			err: "duplicate operation Op",
+		},
+		{
+			name: "clashing Go names",
+			doc: `{"openapi": "3.0.0", "paths": {"/a": {"get": {"operationId": "op", "responses": {}, "parameters": [
+				{"name": "user_id", "in": "query", "schema": {"type": "string"}},
+				{"name": "userId", "in": "query", "schema": {"type": "string"}}]}}}}`,
+			err: "its Go name QueryUserID is already used",
+		},
+		{
+			name: "unsupported parameter",
+			doc: `{"openapi": "3.0.0", "paths": {"/a": {"get": {"operationId": "op", "responses": {}, "parameters": [
+				{"name": "filter", "in": "query", "schema": {"type": "object", "properties": {"a": {"type": "string"}}}}]}}}}`,
+			err: "parameter filter: unsupported schema",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Generate(doc, "api")
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
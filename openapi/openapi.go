@@ -0,0 +1,329 @@
+// Package openapi generates xserver routers from OpenAPI 3 documents
+// and contains the helpers used by the generated code.
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document is the subset of an OpenAPI 3 document used by the generators
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type Components struct {
+	Schemas       map[string]*Schema      `json:"schemas"`
+	Parameters    map[string]*Parameter   `json:"parameters"`
+	RequestBodies map[string]*RequestBody `json:"requestBodies"`
+	Responses     map[string]*Response    `json:"responses"`
+}
+
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters"`
+	Get        *Operation   `json:"get"`
+	Put        *Operation   `json:"put"`
+	Post       *Operation   `json:"post"`
+	Delete     *Operation   `json:"delete"`
+	Options    *Operation   `json:"options"`
+	Head       *Operation   `json:"head"`
+	Patch      *Operation   `json:"patch"`
+	Trace      *Operation   `json:"trace"`
+}
+
+// Operations returns the operations of the path item by http method in a stable order
+func (p *PathItem) Operations() []MethodOperation {
+	all := []MethodOperation{
+		{"GET", p.Get}, {"POST", p.Post}, {"PUT", p.Put}, {"PATCH", p.Patch},
+		{"DELETE", p.Delete}, {"HEAD", p.Head}, {"OPTIONS", p.Options}, {"TRACE", p.Trace},
+	}
+	ops := all[:0]
+	for _, op := range all {
+		if op.Operation != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+type MethodOperation struct {
+	Method string
+	*Operation
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Description string               `json:"description"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Ref         string      `json:"$ref"`
+	Name        string      `json:"name"`
+	In          string      `json:"in"`
+	Description string      `json:"description"`
+	Required    bool        `json:"required"`
+	Schema      *Schema     `json:"schema"`
+	Example     interface{} `json:"example"`
+}
+
+type RequestBody struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Required    bool                  `json:"required"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema   *Schema             `json:"schema"`
+	Example  interface{}         `json:"example"`
+	Examples map[string]*Example `json:"examples"`
+}
+
+type Example struct {
+	Summary string      `json:"summary"`
+	Value   interface{} `json:"value"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Description          string             `json:"description"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *Schema            `json:"items"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Enum                 []interface{}      `json:"enum"`
+	Default              interface{}        `json:"default"`
+	Example              interface{}        `json:"example"`
+	Nullable             bool               `json:"nullable"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     bool               `json:"exclusiveMaximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	AllOf                []*Schema          `json:"allOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+	AnyOf                []*Schema          `json:"anyOf"`
+}
+
+// Additional returns the schema of additional properties, nil when they are not described
+func (s *Schema) Additional() *Schema {
+	if len(s.AdditionalProperties) == 0 || s.AdditionalProperties[0] != '{' {
+		return nil
+	}
+	var a Schema
+	if err := json.Unmarshal(s.AdditionalProperties, &a); err != nil {
+		return nil
+	}
+	return &a
+}
+
+// IsRequired reports whether the object schema requires the property
+func (s *Schema) IsRequired(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads an OpenAPI document in JSON or YAML format
+func Load(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes an OpenAPI document in JSON or YAML format
+func Parse(data []byte) (*Document, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(trimmed, "{") {
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		d, err := json.Marshal(jsonCompatible(v))
+		if err != nil {
+			return nil, err
+		}
+		data = d
+	}
+
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, errors.New("openapi: only OpenAPI 3 documents are supported")
+	}
+	return &doc, nil
+}
+
+// jsonCompatible converts yaml maps with non string keys to json objects
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = jsonCompatible(e)
+		}
+		return t
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range t {
+			t[i] = jsonCompatible(e)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// ResolveSchema follows a local schema reference
+func (d *Document) ResolveSchema(s *Schema) (*Schema, error) {
+	for i := 0; s != nil && s.Ref != ""; i++ {
+		if i > 32 {
+			return nil, fmt.Errorf("openapi: reference cycle at %s", s.Ref)
+		}
+		resolved, ok := d.Components.Schemas[refName(s.Ref)]
+		if !ok || !strings.HasPrefix(s.Ref, "#/components/schemas/") {
+			return nil, fmt.Errorf("openapi: unresolved reference %s", s.Ref)
+		}
+		s = resolved
+	}
+	return s, nil
+}
+
+// ResolveParameter follows a local parameter reference
+func (d *Document) ResolveParameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	resolved, ok := d.Components.Parameters[refName(p.Ref)]
+	if !ok {
+		return nil, fmt.Errorf("openapi: unresolved reference %s", p.Ref)
+	}
+	return resolved, nil
+}
+
+// ResolveRequestBody follows a local request body reference
+func (d *Document) ResolveRequestBody(b *RequestBody) (*RequestBody, error) {
+	if b == nil || b.Ref == "" {
+		return b, nil
+	}
+	resolved, ok := d.Components.RequestBodies[refName(b.Ref)]
+	if !ok {
+		return nil, fmt.Errorf("openapi: unresolved reference %s", b.Ref)
+	}
+	return resolved, nil
+}
+
+// ResolveResponse follows a local response reference
+func (d *Document) ResolveResponse(r *Response) (*Response, error) {
+	if r == nil || r.Ref == "" {
+		return r, nil
+	}
+	resolved, ok := d.Components.Responses[refName(r.Ref)]
+	if !ok {
+		return nil, fmt.Errorf("openapi: unresolved reference %s", r.Ref)
+	}
+	return resolved, nil
+}
+
+// OperationParameters merges the path item and operation parameters, the operation wins
+func (d *Document) OperationParameters(item *PathItem, op *Operation) ([]*Parameter, error) {
+	var params []*Parameter
+	index := map[string]int{}
+	for _, list := range [][]*Parameter{item.Parameters, op.Parameters} {
+		for _, p := range list {
+			p, err := d.ResolveParameter(p)
+			if err != nil {
+				return nil, err
+			}
+			key := p.In + ":" + p.Name
+			if i, ok := index[key]; ok {
+				params[i] = p
+				continue
+			}
+			index[key] = len(params)
+			params = append(params, p)
+		}
+	}
+	return params, nil
+}
+
+// JSONContent returns the json media type of a content map
+func JSONContent(content map[string]*MediaType) *MediaType {
+	if mt, ok := content["application/json"]; ok {
+		return mt
+	}
+	for name, mt := range content {
+		if strings.HasSuffix(strings.SplitN(name, ";", 2)[0], "+json") {
+			return mt
+		}
+	}
+	return nil
+}
+
+// SuccessResponse returns the lowest 2xx response of an operation with its status
+func (d *Document) SuccessResponse(op *Operation) (int, *Response, error) {
+	best := 0
+	var res *Response
+	for code, r := range op.Responses {
+		status := 0
+		if code == "2XX" || code == "2xx" {
+			status = 200
+		} else if _, err := fmt.Sscanf(code, "%d", &status); err != nil {
+			continue
+		}
+		if status < 200 || status > 299 || (best != 0 && status >= best) {
+			continue
+		}
+		resolved, err := d.ResolveResponse(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		best, res = status, resolved
+	}
+	return best, res, nil
+}
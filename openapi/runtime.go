@@ -0,0 +1,245 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Parameter locations
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+	InCookie = "cookie"
+)
+
+// Error is returned by handlers to answer with a specific status
+type Error struct {
+	Status  int      `json:"-"`
+	Message string   `json:"error"`
+	Details []string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Details) == 0 {
+		return e.Message
+	}
+	return e.Message + ": " + strings.Join(e.Details, "; ")
+}
+
+// NewError creates an error answered with the given status
+func NewError(status int, message string) *Error {
+	return &Error{Status: status, Message: message}
+}
+
+// Validator is implemented by the generated types
+type Validator interface {
+	Validate() error
+}
+
+// Errors collects validation failures
+type Errors []string
+
+// Add records a failure of a field
+func (e *Errors) Add(field, msg string) {
+	if field != "" {
+		msg = field + " " + msg
+	}
+	*e = append(*e, msg)
+}
+
+// Merge records the failures of a nested value
+func (e *Errors) Merge(field string, err error) {
+	var verr *Error
+	if !errors.As(err, &verr) || len(verr.Details) == 0 {
+		e.Add(field, err.Error())
+		return
+	}
+	for _, d := range verr.Details {
+		*e = append(*e, field+"."+d)
+	}
+}
+
+// Err returns a bad request error when failures were recorded
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return &Error{Status: http.StatusBadRequest, Message: "validation failed", Details: e}
+}
+
+// Param returns a raw parameter value and whether it was present
+func Param(r *http.Request, in, name string) (string, bool) {
+	switch in {
+	case InPath:
+		v := chi.URLParam(r, name)
+		return v, v != ""
+	case InQuery:
+		v, ok := r.URL.Query()[name]
+		if !ok || len(v) == 0 {
+			return "", false
+		}
+		return v[0], true
+	case InHeader:
+		v, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok || len(v) == 0 {
+			return "", false
+		}
+		return v[0], true
+	case InCookie:
+		c, err := r.Cookie(name)
+		if err != nil {
+			return "", false
+		}
+		return c.Value, true
+	}
+	return "", false
+}
+
+// ParamValues returns the values of an array parameter, repeated query keys
+// and comma separated values are both accepted
+func ParamValues(r *http.Request, in, name string) ([]string, bool) {
+	if in == InQuery {
+		v, ok := r.URL.Query()[name]
+		if !ok {
+			return nil, false
+		}
+		if len(v) == 1 {
+			return strings.Split(v[0], ","), true
+		}
+		return v, true
+	}
+	v, ok := Param(r, in, name)
+	if !ok {
+		return nil, false
+	}
+	return strings.Split(v, ","), true
+}
+
+func ParseString(raw string) (string, error) {
+	return raw, nil
+}
+
+func ParseInt32(raw string) (int32, error) {
+	v, err := strconv.ParseInt(raw, 10, 32)
+	if err != nil {
+		return 0, errors.New("must be a 32 bit integer")
+	}
+	return int32(v), nil
+}
+
+func ParseInt64(raw string) (int64, error) {
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, errors.New("must be an integer")
+	}
+	return v, nil
+}
+
+func ParseFloat32(raw string) (float32, error) {
+	v, err := strconv.ParseFloat(raw, 32)
+	if err != nil {
+		return 0, errors.New("must be a number")
+	}
+	return float32(v), nil
+}
+
+func ParseFloat64(raw string) (float64, error) {
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return 0, errors.New("must be a number")
+	}
+	return v, nil
+}
+
+func ParseBool(raw string) (bool, error) {
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, errors.New("must be a boolean")
+	}
+	return v, nil
+}
+
+func ParseTime(raw string) (time.Time, error) {
+	v, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, errors.New("must be a RFC 3339 date-time")
+	}
+	return v, nil
+}
+
+// DecodeJSON decodes and validates a json request body,
+// it reports false without an error when the body is empty
+func DecodeJSON(r *http.Request, v interface{}) (bool, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false, NewError(http.StatusBadRequest, "cannot read request body")
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		var verr *Error
+		if errors.As(err, &verr) {
+			return false, verr
+		}
+		return false, &Error{Status: http.StatusBadRequest, Message: "invalid request body", Details: []string{err.Error()}}
+	}
+	if val, ok := v.(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// CheckRequired reports the required keys missing from a json object
+func CheckRequired(data []byte, keys ...string) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var errs Errors
+	for _, k := range keys {
+		if _, ok := raw[k]; !ok {
+			errs.Add(k, "is required")
+		}
+	}
+	return errs.Err()
+}
+
+// WriteJSON writes v as a json response
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
+
+// WriteError writes err as a json error response, errors other than *Error are internal
+func WriteError(w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = NewError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+	status := e.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	d, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
@@ -0,0 +1,103 @@
+// Package otellog exports slog records through the OpenTelemetry logs SDK,
+// records logged with a context carrying a span are correlated with its trace.
+package otellog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	"go.opentelemetry.io/otel/log/global"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Config describes the logs pipeline
+type Config struct {
+	// Exporter is otlp to send logs to a collector over OTLP/HTTP or stdout to print them
+	Exporter string `envconfig:"otel_logs_exporter" mapstructure:"otel_logs_exporter" default:"otlp"`
+	// Endpoint host:port of the collector, the OTEL_EXPORTER_OTLP_* variables apply when empty
+	Endpoint string `envconfig:"otel_logs_endpoint" mapstructure:"otel_logs_endpoint" default:""`
+	// Insecure disables TLS towards the collector
+	Insecure bool `envconfig:"otel_logs_insecure" mapstructure:"otel_logs_insecure" default:"false"`
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string `envconfig:"otel_service_name" mapstructure:"otel_service_name" default:""`
+}
+
+// Provider owns the logger provider and its exporter,
+// it is a xserver.Lifecycle so pending logs are flushed on shutdown
+type Provider struct {
+	provider *sdklog.LoggerProvider
+}
+
+// New creates the logger provider and installs it as the global one
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p, err := newProvider(cfg, sdklog.NewBatchProcessor(exporter))
+	if err != nil {
+		return nil, err
+	}
+	global.SetLoggerProvider(p.provider)
+	return p, nil
+}
+
+// newProvider creates a logger provider exporting through the processor
+func newProvider(cfg Config, processor sdklog.Processor) (*Provider, error) {
+	res := resource.Default()
+	if cfg.ServiceName != "" {
+		var err error
+		res, err = resource.Merge(res, resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Provider{
+		provider: sdklog.NewLoggerProvider(
+			sdklog.WithResource(res),
+			sdklog.WithProcessor(processor),
+		),
+	}, nil
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdklog.Exporter, error) {
+	switch cfg.Exporter {
+	case "", "otlp":
+		var opts []otlploghttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlploghttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		return otlploghttp.New(ctx, opts...)
+	case "stdout":
+		return stdoutlog.New(stdoutlog.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("otellog: unknown exporter %q", cfg.Exporter)
+	}
+}
+
+// Handler returns a slog handler emitting to the provider under the instrumentation scope name,
+// set it as xserver.Config.LogBridge to export the server and access logs
+func (p *Provider) Handler(name string) slog.Handler {
+	return otelslog.NewHandler(name, otelslog.WithLoggerProvider(p.provider))
+}
+
+// Start does nothing, the provider is ready once created
+func (p *Provider) Start() error {
+	return nil
+}
+
+// Shutdown flushes the pending logs and stops the exporter
+func (p *Provider) Shutdown(ctx context.Context) error {
+	return p.provider.Shutdown(ctx)
+}
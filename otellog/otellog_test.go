@@ -0,0 +1,140 @@
+package otellog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/l00p8/xserver"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// memoryExporter keeps the exported records
+type memoryExporter struct {
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *memoryExporter) Export(_ context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		e.records = append(e.records, r.Clone())
+	}
+	return nil
+}
+
+func (e *memoryExporter) Shutdown(context.Context) error { return nil }
+
+func (e *memoryExporter) ForceFlush(context.Context) error { return nil }
+
+func (e *memoryExporter) take() []sdklog.Record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := e.records
+	e.records = nil
+	return res
+}
+
+func attributes(r sdklog.Record) map[string]log.Value {
+	res := map[string]log.Value{}
+	r.WalkAttributes(func(kv log.KeyValue) bool {
+		res[kv.Key] = kv.Value
+		return true
+	})
+	return res
+}
+
+func TestBridge(t *testing.T) {
+	exp := &memoryExporter{}
+	p, err := newProvider(Config{ServiceName: "orders"}, sdklog.NewSimpleProcessor(exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Shutdown(context.Background())
+	cfg := xserver.Config{LogHandler: slog.NewTextHandler(io.Discard, nil), LogBridge: p.Handler("xserver")}
+	logger := cfg.Slog().With("service", "api").WithGroup("req")
+
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanCtx)
+	logger.InfoContext(ctx, "in a span", "status", 200, slog.Group("user", "name", "bob"))
+	logger.Warn("outside of a span")
+
+	records := exp.take()
+	if len(records) != 2 {
+		t.Fatalf("%d records exported, want 2", len(records))
+	}
+	in, out := records[0], records[1]
+	if in.TraceID() != spanCtx.TraceID() || in.SpanID() != spanCtx.SpanID() || in.TraceFlags() != trace.FlagsSampled {
+		t.Errorf("record correlated with %v %v, want %v %v", in.TraceID(), in.SpanID(), spanCtx.TraceID(), spanCtx.SpanID())
+	}
+	if out.TraceID().IsValid() || out.SpanID().IsValid() {
+		t.Errorf("a record outside of a span has the trace %v", out.TraceID())
+	}
+	if in.Body().AsString() != "in a span" || in.Severity() != log.SeverityInfo || out.Severity() != log.SeverityWarn {
+		t.Errorf("records %q %v and %v", in.Body().AsString(), in.Severity(), out.Severity())
+	}
+	if name := in.InstrumentationScope().Name; name != "xserver" {
+		t.Errorf("scope %q", name)
+	}
+	res := in.Resource()
+	if v, ok := res.Set().Value("service.name"); !ok || v.AsString() != "orders" {
+		t.Errorf("service.name = %v", v.AsString())
+	}
+
+	attrs := attributes(in)
+	if attrs["service"].AsString() != "api" {
+		t.Errorf("attributes %v", attrs)
+	}
+	req := map[string]log.Value{}
+	for _, kv := range attrs["req"].AsMap() {
+		req[kv.Key] = kv.Value
+	}
+	if req["status"].AsInt64() != 200 || len(req["user"].AsMap()) != 1 || req["user"].AsMap()[0].Value.AsString() != "bob" {
+		t.Errorf("grouped attributes %v", req)
+	}
+}
+
+func TestBridgeLevels(t *testing.T) {
+	exp := &memoryExporter{}
+	p, err := newProvider(Config{}, sdklog.NewSimpleProcessor(exp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Shutdown(context.Background())
+	h := p.Handler("xserver")
+
+	tests := []struct {
+		level slog.Level
+		want  log.Severity
+	}{
+		{slog.LevelDebug - 4, log.SeverityTrace1},
+		{slog.LevelDebug, log.SeverityDebug},
+		{slog.LevelInfo, log.SeverityInfo},
+		{slog.LevelInfo + 2, log.SeverityInfo3},
+		{slog.LevelWarn, log.SeverityWarn},
+		{slog.LevelError, log.SeverityError},
+		{slog.LevelError + 4, log.SeverityFatal},
+	}
+	for _, tt := range tests {
+		slog.New(h).Log(context.Background(), tt.level, "msg")
+		records := exp.take()
+		if len(records) != 1 || records[0].Severity() != tt.want {
+			t.Errorf("%v: %d records, want the severity %v", tt.level, len(records), tt.want)
+		}
+	}
+}
+
+func TestUnknownExporter(t *testing.T) {
+	if _, err := New(context.Background(), Config{Exporter: "kafka"}); err == nil || err.Error() != `otellog: unknown exporter "kafka"` {
+		t.Errorf("error %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package xserver
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitStore keeps expiring counters for the rate limiting middlewares,
+// implement it over a shared database to limit across replicas
+type RateLimitStore interface {
+	// Incr adds one to the counter of key, a missing or expired counter starts at one and expires after ttl
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Get returns the counter of key and its expiry, zero when missing or expired
+	Get(ctx context.Context, key string) (int64, time.Time, error)
+	// Set replaces the counter of key
+	Set(ctx context.Context, key string, value int64, ttl time.Duration) error
+	// Delete removes the counter of key
+	Delete(ctx context.Context, key string) error
+}
+
+type memoryCounter struct {
+	value  int64
+	expiry time.Time
+}
+
+// MemoryRateLimitStore keeps the counters in the memory of the process
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	counters map[string]memoryCounter
+	writes   int
+	now      func() time.Time
+}
+
+// NewMemoryRateLimitStore creates an in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counters: make(map[string]memoryCounter), now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) Incr(_ context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expiry) {
+		c = memoryCounter{expiry: now.Add(ttl)}
+	}
+	c.value++
+	s.write(key, c, now)
+	return c.value, nil
+}
+
+func (s *MemoryRateLimitStore) Get(_ context.Context, key string) (int64, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok || !s.now().Before(c.expiry) {
+		return 0, time.Time{}, nil
+	}
+	return c.value, c.expiry, nil
+}
+
+func (s *MemoryRateLimitStore) Set(_ context.Context, key string, value int64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.write(key, memoryCounter{value: value, expiry: now.Add(ttl)}, now)
+	return nil
+}
+
+func (s *MemoryRateLimitStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+// write stores the counter and sweeps the expired ones from time to time, the lock must be held
+func (s *MemoryRateLimitStore) write(key string, c memoryCounter, now time.Time) {
+	s.counters[key] = c
+	s.writes++
+	if s.writes%1024 != 0 {
+		return
+	}
+	for k, c := range s.counters {
+		if !now.Before(c.expiry) {
+			delete(s.counters, k)
+		}
+	}
+}
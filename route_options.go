@@ -0,0 +1,76 @@
+package xserver
+
+import (
+	"net/http"
+)
+
+// RouteOption customizes a route at registration
+type RouteOption func(*routeOptions)
+
+type routeOptions struct {
+	buckets     *BucketProfile
+	noTimeout   bool
+	noRateLimit bool
+	hints       []string
+}
+
+func newRouteOptions(opts []RouteOption) *routeOptions {
+	o := &routeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// route is the handler registered for a route, it keeps the options to report them
+type route struct {
+	http.Handler
+	opts *routeOptions
+}
+
+func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rt.opts.noRateLimit {
+		unlimit(r.Context())
+	}
+	if rt.opts.noTimeout {
+		r = r.WithContext(untime(r.Context()))
+	}
+	rt.Handler.ServeHTTP(w, r)
+}
+
+// handler wraps fn with the behaviours selected by the options
+func (o *routeOptions) handler(cfg Config, method, pattern string, fn http.HandlerFunc) http.Handler {
+	var h http.Handler = fn
+	if o.buckets != nil {
+		hist, native := bucketHistogram(cfg.MetricsNamespace, *o.buckets, cfg.NativeHistograms)
+		if native != cfg.NativeHistograms {
+			cfg.Slog().Warn("The bucket profile is shared with routes of another native histograms setting",
+				"profile", o.buckets.Name, "native", native)
+		}
+		h = observeDuration(hist, method, pattern, h)
+	}
+	if len(o.hints) > 0 {
+		next := h
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			EarlyHints(w, r, o.hints...)
+			next.ServeHTTP(w, r)
+		})
+	}
+	return &route{Handler: h, opts: o}
+}
+
+// WithoutTimeout exempts the route from Config.Timeout, for handlers holding requests
+// open on purpose such as long polling or streaming
+func WithoutTimeout() RouteOption {
+	return func(o *routeOptions) {
+		o.noTimeout = true
+	}
+}
+
+// WithoutRateLimit releases the Config.RateLimit slot of the requests once they are routed,
+// for long-lived handlers that would otherwise hold the slots of the server
+func WithoutRateLimit() RouteOption {
+	return func(o *routeOptions) {
+		o.noRateLimit = true
+	}
+}
@@ -1,7 +1,9 @@
 package xserver
 
 import (
+	"context"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -15,17 +17,17 @@ type Muxer interface {
 type Router interface {
 	Healthers(healthers ...Healther)
 
-	Get(prefix string, fn http.HandlerFunc)
+	Get(prefix string, fn http.HandlerFunc, opts ...RouteOption)
 
-	Post(prefix string, fn http.HandlerFunc)
+	Post(prefix string, fn http.HandlerFunc, opts ...RouteOption)
 
-	Put(prefix string, fn http.HandlerFunc)
+	Put(prefix string, fn http.HandlerFunc, opts ...RouteOption)
 
-	Delete(prefix string, fn http.HandlerFunc)
+	Delete(prefix string, fn http.HandlerFunc, opts ...RouteOption)
 
-	Patch(prefix string, fn http.HandlerFunc)
+	Patch(prefix string, fn http.HandlerFunc, opts ...RouteOption)
 
-	Head(prefix string, fn http.HandlerFunc)
+	Head(prefix string, fn http.HandlerFunc, opts ...RouteOption)
 
 	Muxer
 }
@@ -33,59 +35,192 @@ type Router interface {
 type router struct {
 	mux    chi.Router
 	Config Config
+	// healthers are kept for a Composite to aggregate them
+	healthers []Healther
 }
 
 func (r *router) Healthers(healthers ...Healther) {
+	r.healthers = healthers
 	r.mux.Get("/_health", healthHandler(healthers...))
 }
 
-func (r *router) Get(prefix string, fn http.HandlerFunc) {
-	r.mux.Get(prefix, fn)
+func (r *router) Get(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.handle(http.MethodGet, prefix, fn, opts)
 }
 
-func (r *router) Post(prefix string, fn http.HandlerFunc) {
-	r.mux.Post(prefix, fn)
+func (r *router) Post(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.handle(http.MethodPost, prefix, fn, opts)
 }
 
-func (r *router) Put(prefix string, fn http.HandlerFunc) {
-	r.mux.Put(prefix, fn)
+func (r *router) Put(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.handle(http.MethodPut, prefix, fn, opts)
 }
 
-func (r *router) Patch(prefix string, fn http.HandlerFunc) {
-	r.mux.Patch(prefix, fn)
+func (r *router) Patch(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.handle(http.MethodPatch, prefix, fn, opts)
 }
 
-func (r *router) Head(prefix string, fn http.HandlerFunc) {
-	r.mux.Head(prefix, fn)
+func (r *router) Head(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.handle(http.MethodHead, prefix, fn, opts)
 }
 
-func (r *router) Delete(prefix string, fn http.HandlerFunc) {
-	r.mux.Delete(prefix, fn)
+func (r *router) Delete(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.handle(http.MethodDelete, prefix, fn, opts)
+}
+
+func (r *router) handle(method, pattern string, fn http.HandlerFunc, opts []RouteOption) {
+	o := newRouteOptions(opts)
+	r.mux.Method(method, pattern, o.handler(r.Config, method, pattern, fn))
+}
+
+// admission is the state of a request admitted by the router, the middlewares run
+// before routing so the route tells whether it is exempt from the limits
+type admission struct {
+	// parent is the request context before the timeout
+	parent  context.Context
+	release func()
+
+	mu       sync.Mutex
+	untimed  bool
+	released bool
+}
+
+type admissionKey struct{}
+
+// untimedContext keeps the values of a request context without the deadline of the timeout
+type untimedContext struct {
+	context.Context
+	parent context.Context
+}
+
+func (c untimedContext) Deadline() (time.Time, bool) { return c.parent.Deadline() }
+func (c untimedContext) Done() <-chan struct{}       { return c.parent.Done() }
+func (c untimedContext) Err() error                  { return c.parent.Err() }
+
+// untime removes the timeout of the request, it returns the untimed context
+func untime(ctx context.Context) context.Context {
+	a, ok := ctx.Value(admissionKey{}).(*admission)
+	if !ok {
+		return ctx
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.untimed = true
+	return untimedContext{Context: ctx, parent: a.parent}
+}
+
+// unlimit releases the slot of the request before it is done
+func unlimit(ctx context.Context) {
+	a, ok := ctx.Value(admissionKey{}).(*admission)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.released {
+		a.released = true
+		a.release()
+	}
+}
+
+// admit limits the requests in flight to limit, answering 429 past it, and cancels them
+// after timeout with a 504. The routes registered WithoutRateLimit and WithoutTimeout
+// are exempt from them.
+func admit(limit int, timeout time.Duration) func(http.Handler) http.Handler {
+	var slots chan struct{}
+	if limit > 0 {
+		slots = make(chan struct{}, limit)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			release := func() {}
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					release = func() { <-slots }
+				default:
+					http.Error(w, "Server capacity exceeded.", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			a := &admission{parent: req.Context(), release: release}
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer func() {
+				cancel()
+				a.mu.Lock()
+				defer a.mu.Unlock()
+				if !a.released {
+					a.released = true
+					a.release()
+				}
+				if !a.untimed && ctx.Err() == context.DeadlineExceeded {
+					w.WriteHeader(http.StatusGatewayTimeout)
+				}
+			}()
+			next.ServeHTTP(w, req.WithContext(context.WithValue(ctx, admissionKey{}, a)))
+		})
+	}
 }
 
 func (r *router) Mux() chi.Router {
 	return r.mux
 }
 
+// routerConfig returns the configuration of a router of this package, the zero Config for others
+func routerConfig(r Router) Config {
+	switch r := r.(type) {
+	case *router:
+		return r.Config
+	case *routerWithTracing:
+		return routerConfig(r.router)
+	}
+	return Config{}
+}
+
+// routerHealthers returns the health checks of a router of this package, none for others
+func routerHealthers(r Router) []Healther {
+	switch r := r.(type) {
+	case *router:
+		return r.healthers
+	case *routerWithTracing:
+		return routerHealthers(r.router)
+	}
+	return nil
+}
+
+// requestTimeout is Config.Timeout, 5s when unset
+func requestTimeout(cfg Config) time.Duration {
+	if cfg.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return cfg.Timeout
+}
+
 func NewRouter(cfg Config) Router {
 	r := &router{mux: chi.NewRouter(), Config: cfg}
+	log := cfg.Slog()
 	//lmt := tollbooth.NewLimiter(float64(cfg.RateLimit), nil)
 
-	timeout := r.Config.Timeout
-	if timeout == 0 {
-		timeout = 5 * time.Second
-	}
+	timeout := requestTimeout(cfg)
 
 	//r.mux.Use(chiMiddleware.Logger)
 	r.mux.Use(chiMiddleware.RequestID)
+	if rw := rewriteMiddleware(cfg, log); rw != nil {
+		r.mux.Use(rw)
+	}
 	r.mux.Use(chiMiddleware.StripSlashes)
-	r.mux.Use(chiMiddleware.Recoverer)
-	r.mux.Use(chiMiddleware.Throttle(int(cfg.RateLimit)))
-	r.mux.Use(chiMiddleware.Timeout(timeout))
-	r.mux.Use(prometheusMiddleware)
+	r.mux.Use(recoverer(log, cfg.DevMode))
+	r.mux.Use(admit(int(cfg.RateLimit), timeout))
+	metrics := metricsFor(cfg.MetricsNamespace, cfg.NativeHistograms)
+	if metrics.native != cfg.NativeHistograms {
+		log.Warn("The metrics namespace is shared with a router of another native histograms setting",
+			"namespace", cfg.MetricsNamespace, "native", metrics.native)
+	}
+	r.mux.Use(prometheusMiddleware(metrics))
 	//r.mux.Use(rateLimitter(lmt))
 	r.mux.Use(xRequestID)
-	r.mux.Use(WithLogging(cfg.Logger))
+	r.mux.Use(WithSlog(log))
 
 	return r
 }
@@ -0,0 +1,121 @@
+package xserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouterTimeout(t *testing.T) {
+	r := NewRouter(Config{RateLimit: 10, Timeout: 20 * time.Millisecond})
+	r.Get("/timed", func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := req.Context().Deadline(); !ok {
+			t.Error("the timed route has no deadline")
+		}
+	})
+	r.Get("/untimed/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := req.Context().Deadline(); ok {
+			t.Error("the untimed route has a deadline")
+		}
+		if req.Context().Value(logContextKey{}) == nil {
+			t.Error("the untimed context lost the values of the middlewares")
+		}
+		select {
+		case <-req.Context().Done():
+			t.Error("the untimed route was canceled")
+		case <-time.After(50 * time.Millisecond):
+			_, _ = w.Write([]byte("done"))
+		}
+	}, WithoutTimeout())
+
+	for _, path := range []string{"/timed", "/untimed/1"} {
+		rec := httptest.NewRecorder()
+		r.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d", path, rec.Code)
+		}
+	}
+}
+
+func TestRouterRateLimitExemption(t *testing.T) {
+	r := NewRouter(Config{RateLimit: 1})
+	hold, holdStream := make(chan struct{}), make(chan struct{})
+	held := make(chan struct{}, 2)
+	handler := func(hold chan struct{}) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			held <- struct{}{}
+			<-hold
+		}
+	}
+	r.Get("/poll", handler(hold), WithoutTimeout(), WithoutRateLimit())
+	r.Get("/stream", handler(holdStream), WithoutTimeout())
+	r.Get("/work", handler(hold))
+	r.Get("/fast", func(w http.ResponseWriter, req *http.Request) {})
+
+	serve := func(path string) chan int {
+		res := make(chan int, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			r.Mux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			res <- rec.Code
+		}()
+		return res
+	}
+
+	// a long poll does not hold a slot
+	poll := serve("/poll")
+	<-held
+	if code := <-serve("/fast"); code != http.StatusOK {
+		t.Fatalf("request next to a long poll: %d", code)
+	}
+
+	// a request only exempt from the timeout does
+	stream := serve("/stream")
+	<-held
+	if code := <-serve("/fast"); code != http.StatusTooManyRequests {
+		t.Fatalf("request next to an untimed request: %d, want 429", code)
+	}
+	close(holdStream)
+	if code := <-stream; code != http.StatusOK {
+		t.Errorf("untimed request: %d", code)
+	}
+
+	// and so does a regular request
+	work := serve("/work")
+	<-held
+	if code := <-serve("/fast"); code != http.StatusTooManyRequests {
+		t.Fatalf("request past the limit: %d, want 429", code)
+	}
+	close(hold)
+	if code := <-poll; code != http.StatusOK {
+		t.Errorf("long poll: %d", code)
+	}
+	if code := <-work; code != http.StatusOK {
+		t.Errorf("work: %d", code)
+	}
+	if code := <-serve("/fast"); code != http.StatusOK {
+		t.Errorf("request after the slot was released: %d", code)
+	}
+}
+
+func TestDrainingPerServer(t *testing.T) {
+	stopping, running := newServerState(slog.Default()), newServerState(slog.Default())
+	stopping.beginShutdown(nil)
+	stopping.beginShutdown(nil)
+
+	select {
+	case <-Draining(stopping.context(context.Background())):
+	default:
+		t.Error("the stopping server is not draining")
+	}
+	select {
+	case <-Draining(running.context(context.Background())):
+		t.Error("the running server is draining")
+	case <-Draining(context.Background()):
+		t.Error("a context outside of a server is draining")
+	default:
+	}
+}
@@ -1,6 +1,7 @@
 package xserver
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/go-chi/chi"
@@ -17,32 +18,51 @@ type routerWithTracing struct {
 	router Router
 }
 
+// correlated lets the access log use the span of the route
+func correlated(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setLogContext(r.Context())
+		fn(w, r)
+	}
+}
+
+type hintsWriterKey struct{}
+
+// tracedRoute wraps fn in a server span. The writer below the span is kept in the request
+// context for EarlyHints, the span takes the first status written as the final one.
+func tracedRoute(name string, fn http.HandlerFunc) http.HandlerFunc {
+	h := otelhttp.NewHandler(correlated(fn), name, otelhttp.WithTracerProvider(otel.GetTracerProvider()))
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), hintsWriterKey{}, w)))
+	}
+}
+
 func (r *routerWithTracing) Healthers(healthers ...Healther) {
 	r.router.Healthers(healthers...)
 }
 
-func (r *routerWithTracing) Get(prefix string, fn http.HandlerFunc) {
-	r.router.Get(prefix, otelhttp.NewHandler(fn, "GET "+prefix, otelhttp.WithTracerProvider(otel.GetTracerProvider())).ServeHTTP)
+func (r *routerWithTracing) Get(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.router.Get(prefix, tracedRoute("GET "+prefix, fn), opts...)
 }
 
-func (r *routerWithTracing) Post(prefix string, fn http.HandlerFunc) {
-	r.router.Post(prefix, otelhttp.NewHandler(fn, "POST "+prefix, otelhttp.WithTracerProvider(otel.GetTracerProvider())).ServeHTTP)
+func (r *routerWithTracing) Post(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.router.Post(prefix, tracedRoute("POST "+prefix, fn), opts...)
 }
 
-func (r *routerWithTracing) Put(prefix string, fn http.HandlerFunc) {
-	r.router.Put(prefix, otelhttp.NewHandler(fn, "PUT "+prefix, otelhttp.WithTracerProvider(otel.GetTracerProvider())).ServeHTTP)
+func (r *routerWithTracing) Put(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.router.Put(prefix, tracedRoute("PUT "+prefix, fn), opts...)
 }
 
-func (r *routerWithTracing) Patch(prefix string, fn http.HandlerFunc) {
-	r.router.Patch(prefix, otelhttp.NewHandler(fn, "PATCH "+prefix, otelhttp.WithTracerProvider(otel.GetTracerProvider())).ServeHTTP)
+func (r *routerWithTracing) Patch(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.router.Patch(prefix, tracedRoute("PATCH "+prefix, fn), opts...)
 }
 
-func (r *routerWithTracing) Head(prefix string, fn http.HandlerFunc) {
-	r.router.Head(prefix, otelhttp.NewHandler(fn, "HEAD "+prefix, otelhttp.WithTracerProvider(otel.GetTracerProvider())).ServeHTTP)
+func (r *routerWithTracing) Head(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.router.Head(prefix, tracedRoute("HEAD "+prefix, fn), opts...)
 }
 
-func (r *routerWithTracing) Delete(prefix string, fn http.HandlerFunc) {
-	r.router.Delete(prefix, otelhttp.NewHandler(fn, "DELETE "+prefix, otelhttp.WithTracerProvider(otel.GetTracerProvider())).ServeHTTP)
+func (r *routerWithTracing) Delete(prefix string, fn http.HandlerFunc, opts ...RouteOption) {
+	r.router.Delete(prefix, tracedRoute("DELETE "+prefix, fn), opts...)
 }
 
 func (r *routerWithTracing) Mux() chi.Router {
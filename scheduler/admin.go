@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/l00p8/xserver"
+)
+
+// RegisterAdmin mounts the job administration endpoints under prefix:
+//
+//	GET  {prefix}/jobs              status of the jobs
+//	POST {prefix}/jobs/{name}/run   run a job now
+//
+// The endpoints require the admin token as a bearer token.
+func (s *Scheduler) RegisterAdmin(r xserver.Router, prefix string) error {
+	if s.cfg.AdminToken == "" {
+		return errors.New("scheduler: admin endpoints require an admin token")
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+	r.Get(prefix+"/jobs", s.admin(s.listHandler))
+	r.Post(prefix+"/jobs/{name}/run", s.admin(s.triggerHandler))
+	return nil
+}
+
+func (s *Scheduler) admin(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if len(auth) <= 7 || !strings.EqualFold(auth[:7], "bearer ") ||
+			subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(s.cfg.AdminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "admin token is required")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func (s *Scheduler) listHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.Statuses())
+}
+
+func (s *Scheduler) triggerHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.Trigger(chi.URLParam(r, "name"))
+	switch {
+	case errors.Is(err, ErrUnknownJob):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrRunning):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrClosed):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	d, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(d)
+}
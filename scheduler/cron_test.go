@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	tests := []struct {
+		expr string
+		loc  *time.Location
+		from string
+		want string
+	}{
+		{"* * * * *", time.UTC, "2024-05-10T10:15:30Z", "2024-05-10T10:16:00Z"},
+		{"*/15 * * * *", time.UTC, "2024-05-10T10:15:00Z", "2024-05-10T10:30:00Z"},
+		{"0 9-17/4 * * *", time.UTC, "2024-05-10T13:00:00Z", "2024-05-10T17:00:00Z"},
+		{"30 2 * * mon-fri", time.UTC, "2024-05-10T03:00:00Z", "2024-05-13T02:30:00Z"},
+		{"0 0 1,15 * *", time.UTC, "2024-05-02T00:00:00Z", "2024-05-15T00:00:00Z"},
+		{"0 0 * feb *", time.UTC, "2024-05-02T00:00:00Z", "2025-02-01T00:00:00Z"},
+		{"0 0 29 2 *", time.UTC, "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 12 * * 7", time.UTC, "2024-05-10T00:00:00Z", "2024-05-12T12:00:00Z"},
+		// restricted day of month and day of week match either way
+		{"0 0 13 * fri", time.UTC, "2024-05-01T00:00:00Z", "2024-05-03T00:00:00Z"},
+		{"@daily", time.UTC, "2024-12-31T23:59:00Z", "2025-01-01T00:00:00Z"},
+		{"@hourly", time.UTC, "2024-05-10T10:00:00Z", "2024-05-10T11:00:00Z"},
+		{"0 3 * * *", paris, "2024-05-10T00:00:00Z", "2024-05-10T01:00:00Z"},
+		// 2:30 does not exist when Paris switches to summer time
+		{"30 2 * * *", paris, "2024-03-30T12:00:00Z", "2024-04-01T00:30:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr+" from "+tt.from, func(t *testing.T) {
+			s, err := CronIn(tt.expr, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, _ := time.Parse(time.RFC3339, tt.from)
+			got := s.Next(from)
+			if want, _ := time.Parse(time.RFC3339, tt.want); !got.Equal(want) {
+				t.Errorf("Next = %v, want %v", got.UTC(), want)
+			}
+		})
+	}
+}
+
+func TestCronNeverMatching(t *testing.T) {
+	s, err := Cron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("Next = %v, want zero for February 30", next)
+	}
+}
+
+func TestCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", "expected 5 fields"},
+		{"* * * * * *", "expected 5 fields"},
+		{"60 * * * *", "minute"},
+		{"* 24 * * *", "hour"},
+		{"* * 0 * *", "day of month"},
+		{"* * * 13 *", "month"},
+		{"* * * * 8", "day of week"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"10-5 * * * *", "out of range"},
+		{"a-5 * * * *", "invalid range"},
+		{"* * * foo *", "invalid value"},
+		{"@sometimes", "expected 5 fields"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Cron(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+// Package scheduler runs periodic jobs next to an xserver API. The Scheduler is a
+// xserver.Lifecycle: Listen starts it and drains the running jobs on shutdown.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/l00p8/xserver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_job_runs_total",
+		Help: "Number of job runs by status.",
+	}, []string{"job", "status"})
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "scheduler_job_duration_seconds",
+		Help: "Duration of the job runs.",
+	}, []string{"job"})
+	jobRunning = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_job_running",
+		Help: "Number of runs of the job in progress.",
+	}, []string{"job"})
+	jobLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_job_last_success_timestamp_seconds",
+		Help: "Time of the last successful run of the job.",
+	}, []string{"job"})
+)
+
+// Run statuses
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusTimeout = "timeout"
+	StatusSkipped = "skipped"
+)
+
+var (
+	// ErrRunning is returned when triggering a job already running which does not allow overlaps
+	ErrRunning = errors.New("scheduler: job is running")
+	// ErrUnknownJob is returned when triggering a job which was not added
+	ErrUnknownJob = errors.New("scheduler: unknown job")
+	// ErrClosed is returned when triggering a job after Shutdown
+	ErrClosed = errors.New("scheduler: shut down")
+)
+
+// Job is a function run on a schedule
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Func     func(ctx context.Context) error
+	// Timeout cancels the context of a run, no timeout when zero
+	Timeout time.Duration
+	// Jitter delays each run by a random duration up to it, to spread the load of replicas
+	Jitter time.Duration
+	// AllowOverlap starts a run even when the previous one is not finished,
+	// by default the run is skipped
+	AllowOverlap bool
+	// MaxFailures is the number of failed runs in a row before the job reports unhealthy,
+	// zero never reports the job unhealthy
+	MaxFailures int
+}
+
+// Status is the state of a job
+type Status struct {
+	Name    string    `json:"name"`
+	Running int       `json:"running"`
+	NextRun time.Time `json:"next_run"`
+	// LastRun is nil until the job ran once
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastStatus string     `json:"last_status,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Failures   int        `json:"consecutive_failures"`
+}
+
+type job struct {
+	Job
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Config describes the scheduler
+type Config struct {
+	// AdminToken protects the admin endpoints, they are disabled when it is empty
+	AdminToken string `envconfig:"scheduler_admin_token" mapstructure:"scheduler_admin_token" default:"" secret:"true"`
+	// Logger receives the failures of the runs, slog.Default when nil
+	Logger *slog.Logger `ignored:"true"`
+}
+
+// Scheduler runs the jobs from Start to Shutdown
+type Scheduler struct {
+	cfg Config
+	log *slog.Logger
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	started bool
+	closed  bool
+
+	// stop ends the loops, cancel the runs
+	stop   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	loops  sync.WaitGroup
+	runs   sync.WaitGroup
+}
+
+// New creates a scheduler without jobs
+func New(cfg Config) *Scheduler {
+	log := cfg.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{cfg: cfg, log: log, jobs: make(map[string]*job), stop: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+// Add registers a job, jobs added after Start are scheduled right away
+func (s *Scheduler) Add(j Job) error {
+	if j.Name == "" || j.Schedule == nil || j.Func == nil {
+		return errors.New("scheduler: a job needs a name, a schedule and a func")
+	}
+	if e, ok := j.Schedule.(every); ok && e <= 0 {
+		return fmt.Errorf("scheduler: job %q runs every %v, the interval must be positive", j.Name, time.Duration(e))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[j.Name]; ok {
+		return fmt.Errorf("scheduler: job %q already added", j.Name)
+	}
+	jb := &job{Job: j, status: Status{Name: j.Name}}
+	s.jobs[j.Name] = jb
+	if s.started && !s.closed {
+		s.schedule(jb)
+	}
+	return nil
+}
+
+// Start schedules the jobs
+func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.started = true
+	for _, jb := range s.jobs {
+		s.schedule(jb)
+	}
+	return nil
+}
+
+// schedule runs the loop of a job, the lock must be held
+func (s *Scheduler) schedule(jb *job) {
+	s.loops.Add(1)
+	go func() {
+		defer s.loops.Done()
+		planned := time.Now()
+		for {
+			planned = jb.Schedule.Next(planned)
+			if planned.IsZero() {
+				return
+			}
+			// a late loop, after a system sleep for instance, does not run the missed occurrences
+			if now := time.Now(); planned.Before(now) {
+				planned = jb.Schedule.Next(now)
+			}
+			at := planned
+			if jb.Jitter > 0 {
+				at = at.Add(time.Duration(rand.Int63n(int64(jb.Jitter))))
+			}
+			jb.mu.Lock()
+			jb.status.NextRun = at
+			jb.mu.Unlock()
+
+			timer := time.NewTimer(time.Until(at))
+			select {
+			case <-s.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			_ = s.run(jb)
+		}
+	}()
+}
+
+// run starts a run of the job unless it would overlap or the scheduler is shut down
+func (s *Scheduler) run(jb *job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	jb.mu.Lock()
+	if jb.status.Running > 0 && !jb.AllowOverlap {
+		jb.mu.Unlock()
+		jobRuns.WithLabelValues(jb.Name, StatusSkipped).Inc()
+		return ErrRunning
+	}
+	jb.status.Running++
+	jb.mu.Unlock()
+	jobRunning.WithLabelValues(jb.Name).Inc()
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+		s.execute(jb)
+	}()
+	return nil
+}
+
+func (s *Scheduler) execute(jb *job) {
+	ctx := s.ctx
+	if jb.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, jb.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := xserver.Protect(ctx, jb.Func)
+	duration := time.Since(start)
+
+	status := StatusSuccess
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status = StatusTimeout
+	case err != nil:
+		status = StatusFailure
+	}
+	jobRuns.WithLabelValues(jb.Name, status).Inc()
+	jobDuration.WithLabelValues(jb.Name).Observe(duration.Seconds())
+	jobRunning.WithLabelValues(jb.Name).Dec()
+	if err == nil {
+		jobLastSuccess.WithLabelValues(jb.Name).Set(float64(time.Now().Unix()))
+	} else {
+		s.log.Error("Scheduled job failed", "job", jb.Name, "status", status, "duration", duration, "error", err)
+	}
+
+	jb.mu.Lock()
+	defer jb.mu.Unlock()
+	jb.status.Running--
+	jb.status.LastRun = &start
+	jb.status.LastStatus = status
+	jb.status.LastError = ""
+	if err != nil {
+		jb.status.LastError = err.Error()
+		jb.status.Failures++
+	} else {
+		jb.status.Failures = 0
+	}
+}
+
+// Trigger runs a job now, outside of its schedule
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	jb, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+	return s.run(jb)
+}
+
+// Statuses returns the state of the jobs sorted by name
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, jb := range s.jobs {
+		jobs = append(jobs, jb)
+	}
+	s.mu.Unlock()
+
+	res := make([]Status, len(jobs))
+	for i, jb := range jobs {
+		jb.mu.Lock()
+		res[i] = jb.status
+		jb.mu.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Health reports the jobs which failed MaxFailures times in a row, it makes the scheduler a xserver.Healther
+func (s *Scheduler) Health() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failing []string
+	for _, jb := range s.jobs {
+		jb.mu.Lock()
+		if jb.MaxFailures > 0 && jb.status.Failures >= jb.MaxFailures {
+			failing = append(failing, fmt.Sprintf("%s failed %d times: %s", jb.Name, jb.status.Failures, jb.status.LastError))
+		}
+		jb.mu.Unlock()
+	}
+	if len(failing) == 0 {
+		return nil
+	}
+	sort.Strings(failing)
+	return fmt.Errorf("scheduler: %v", failing)
+}
+
+// Shutdown stops scheduling and waits for the running jobs, their context is canceled when ctx is done
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+	}
+	s.mu.Unlock()
+	s.loops.Wait()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.runs.Wait()
+		close(stopped)
+	}()
+	defer s.cancel()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
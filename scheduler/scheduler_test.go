@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/l00p8/xserver"
+)
+
+func TestAddRejectsNonPositiveInterval(t *testing.T) {
+	s := New(Config{})
+	for _, d := range []time.Duration{0, -time.Second} {
+		err := s.Add(Job{Name: "job", Schedule: Every(d), Func: func(context.Context) error { return nil }})
+		if err == nil {
+			t.Errorf("Every(%v) was accepted", d)
+		}
+	}
+}
+
+func TestPanicKeepsStack(t *testing.T) {
+	s := New(Config{})
+	done := make(chan struct{})
+	err := s.Add(Job{Name: "panics", Schedule: Every(time.Hour), Func: func(context.Context) error {
+		defer close(done)
+		panic("boom")
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	if err := s.Trigger("panics"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	st := s.Statuses()[0]
+	if st.LastStatus != StatusFailure || st.LastError != "panic: boom" || st.LastRun == nil {
+		t.Errorf("status = %+v", st)
+	}
+
+	perr := xserver.Protect(context.Background(), func(context.Context) error { panic("boom") })
+	var pe *xserver.PanicError
+	if !errors.As(perr, &pe) || !strings.Contains(string(pe.Stack), "TestPanicKeepsStack") {
+		t.Errorf("panic error without the stack: %v", perr)
+	}
+}
+
+func TestStatusLastRunOmitted(t *testing.T) {
+	data, err := json.Marshal(Status{Name: "job"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "last_run") {
+		t.Errorf("a job which never ran has a last_run: %s", data)
+	}
+}
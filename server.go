@@ -2,64 +2,173 @@ package xserver
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/valve"
 	logger "github.com/l00p8/log"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // Config describes server configuration
 type Config struct {
-	Addr            string        `envconfig:"addr" mapstructure:"addr" default:":8080"`
-	ShutdownTimeout time.Duration `envconfig:"shutdown_timeout" mapstructure:"shutdown_timeout" default:"20"`
-	GracefulTimeout time.Duration `envconfig:"graceful_timeout" mapstructure:"graceful_timeout" default:"21"`
-	HealthUri       string        `envconfig:"health_uri" mapstructure:"health_uri" default:"/_health"`
-	ApiVersion      string        `envconfig:"api_version" mapstructure:"api_version" default:"v1"`
-	Timeout         time.Duration `envconfig:"timeout" mapstructure:"timeout" default:"20"`
-	RateLimit       int64         `envconfig:"rate_limit" mapstructure:"rate_limit" default:"1000"`
-	CertPath        string        `envconfig:"cert_path" mapstructure:"cert_path" default:""`
-	KeyPath         string        `envconfig:"key_path" mapstructure:"key_path" default:""`
-	TLSEnabled      bool          `envconfig:"tls_enabled" mapstructure:"tls_enabled" default:""`
-	Logger          logger.Logger
+	Addr              string        `envconfig:"addr" mapstructure:"addr" default:":8080"`
+	ShutdownTimeout   time.Duration `envconfig:"shutdown_timeout" mapstructure:"shutdown_timeout" default:"20s"`
+	GracefulTimeout   time.Duration `envconfig:"graceful_timeout" mapstructure:"graceful_timeout" default:"21s"`
+	HealthUri         string        `envconfig:"health_uri" mapstructure:"health_uri" default:"/_health"`
+	ApiVersion        string        `envconfig:"api_version" mapstructure:"api_version" default:"v1"`
+	Timeout           time.Duration `envconfig:"timeout" mapstructure:"timeout" default:"20s"`
+	RateLimit         int64         `envconfig:"rate_limit" mapstructure:"rate_limit" default:"1000"`
+	CertPath          string        `envconfig:"cert_path" mapstructure:"cert_path" default:"" secret:"true"`
+	KeyPath           string        `envconfig:"key_path" mapstructure:"key_path" default:"" secret:"true"`
+	TLSEnabled        bool          `envconfig:"tls_enabled" mapstructure:"tls_enabled" default:""`
+	H2C               bool          `envconfig:"h2c" mapstructure:"h2c" default:"false"`
+	MetricsNamespace  string        `envconfig:"metrics_namespace" mapstructure:"metrics_namespace" default:""`
+	MetricsToken      string        `envconfig:"metrics_token" mapstructure:"metrics_token" default:"" secret:"true"`
+	MetricsUser       string        `envconfig:"metrics_user" mapstructure:"metrics_user" default:""`
+	MetricsPassword   string        `envconfig:"metrics_password" mapstructure:"metrics_password" default:"" secret:"true"`
+	MetricsMaxScrapes int           `envconfig:"metrics_max_scrapes" mapstructure:"metrics_max_scrapes" default:"0"`
+	MetricsTimeout    time.Duration `envconfig:"metrics_timeout" mapstructure:"metrics_timeout" default:"0"`
+	NativeHistograms  bool          `envconfig:"native_histograms" mapstructure:"native_histograms" default:"false"`
+	DevMode           bool          `envconfig:"dev_mode" mapstructure:"dev_mode" default:"false"`
+	Rewrites          []RewriteRule `ignored:"true" mapstructure:"rewrites"`
+	Logger            logger.Logger
+	// LogHandler receives the structured logs of the server, it takes precedence over Logger
+	LogHandler slog.Handler `ignored:"true"`
+	// LogBridge receives a copy of every log record, such as an OpenTelemetry logs bridge,
+	// the request and response dumps of the debug records are left out
+	LogBridge slog.Handler `ignored:"true"`
 }
 
 // Listen starts a http server on specified address and defines gateway routes
 // Server implements a graceful shutdown pattern for better handling of rolling k8s updates
-func Listen(cfg Config, router Muxer, cleanUp func()) error {
+// The components are started before the server and shut down after it
+func Listen(cfg Config, router Muxer, cleanUp func(), components ...Lifecycle) error {
+	if err := validateRewrites(cfg); err != nil {
+		return err
+	}
+	router.Mux().Handle("/_metrics", metricsHandler(cfg))
+
+	return run(cfg, []*listener{newListener(cfg, router.Mux())}, cleanUp, components)
+}
+
+// listener is a http server bound to the address of its config
+type listener struct {
+	cfg    Config
+	srv    *http.Server
+	routes http.Handler
+}
+
+func newListener(cfg Config, handler http.Handler) *listener {
+	l := &listener{
+		cfg: cfg,
+		srv: &http.Server{
+			Addr:         cfg.Addr,
+			Handler:      handler,
+			ReadTimeout:  2 * cfg.Timeout,
+			WriteTimeout: 2 * cfg.Timeout,
+		},
+		routes: handler,
+	}
+	// HTTP/2 is negotiated on TLS listeners, h2c serves it to the clients
+	// knowing the server speaks it, such as gRPC and Connect clients
+	if cfg.H2C && !cfg.TLSEnabled {
+		l.srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	return l
+}
+
+func (l *listener) serve() error {
+	log := l.cfg.Slog()
+	log.Info("Starting a new server", "addr", l.cfg.Addr, "tls", l.cfg.TLSEnabled, "h2c", l.cfg.H2C)
+
+	if l.cfg.DevMode {
+		logRoutes(log, l.routes)
+	}
+
+	if !l.cfg.TLSEnabled {
+		if err := l.srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Error("A server listener error", "addr", l.cfg.Addr, "error", err)
+			return err
+		}
+	} else if l.cfg.DevMode && l.cfg.CertPath == "" && l.cfg.KeyPath == "" {
+		cert, err := selfSignedCert()
+		if err != nil {
+			log.Error("Cannot generate a self-signed certificate", "error", err)
+			return err
+		}
+		log.Info("Serving with a self-signed certificate for localhost", "addr", l.cfg.Addr)
+		l.srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if err := l.srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			log.Error("A tls server listener error", "addr", l.cfg.Addr, "error", err)
+			return err
+		}
+	} else {
+		if err := l.srv.ListenAndServeTLS(l.cfg.CertPath, l.cfg.KeyPath); err != http.ErrServerClosed {
+			log.Error("A tls server listener error", "addr", l.cfg.Addr, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// run serves all listeners and shuts them down together on a termination signal
+// or as soon as one of them fails
+func run(cfg Config, listeners []*listener, cleanUp func(), components []Lifecycle) error {
 	valv := valve.New()
-	log := cfg.Logger
+	log := cfg.Slog()
 
-	router.Mux().Handle("/_metrics", promhttp.Handler())
+	for i, c := range components {
+		if err := c.Start(); err != nil {
+			log.Error("Error starting a component", "error", err)
+			shutdownComponents(context.Background(), log, components[:i])
+			return err
+		}
+	}
 
-	srv := http.Server{
-		Addr:         cfg.Addr,
-		Handler:      router.Mux(),
-		ReadTimeout:  2 * cfg.Timeout,
-		WriteTimeout: 2 * cfg.Timeout,
+	// the requests of the listeners carry the state of this run
+	state := newServerState(log)
+	for _, l := range listeners {
+		l.srv.BaseContext = func(net.Listener) context.Context {
+			return state.context(context.Background())
+		}
 	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, os.Interrupt)
+	failed := make(chan struct{})
+	// stopped is closed once the listeners, the detached work and the components are shut down
+	stopped := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			close(stopped)
+		})
+	}
+	// shutdownErr is written before stopped is closed
+	var shutdownErr error
 
 	go func() {
-		<-c
-		//for range c {
+		defer stop()
+		select {
+		case <-c:
+		case <-failed:
+		}
 		// sig is a ^C, handle it
 		log.Info("Shutting down a http server...")
+		state.beginShutdown(components)
 
 		shutdown := cfg.ShutdownTimeout
 
-		// first valv
-		if err := valv.Shutdown(shutdown); err != nil {
-			log.Error("Error shutting down a Valve: " + err.Error())
-			return
-		}
-
 		// create a context with timeout
 		ctx, cancel := context.WithTimeout(context.Background(), shutdown)
 		defer func() {
@@ -67,15 +176,37 @@ func Listen(cfg Config, router Muxer, cleanUp func()) error {
 			cancel()
 		}()
 
+		// every step runs even when a previous one failed, so nothing is left running
+		var errs []error
+
+		// first valv
+		if err := valv.Shutdown(shutdown); err != nil {
+			log.Error("Error shutting down a Valve", "error", err)
+			errs = append(errs, err)
+		}
+
 		// cleanUp before shutDown
 		cleanUp()
 
-		// start http server shutdown
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Error("Error shutting down a http server: " + err.Error())
-			return
+		// start http servers shutdown
+		for _, l := range listeners {
+			if err := l.srv.Shutdown(ctx); err != nil {
+				log.Error("Error shutting down a http server", "addr", l.cfg.Addr, "error", err)
+				errs = append(errs, err)
+			}
 		}
 
+		// then the work detached from requests
+		if err := state.work.drain(ctx); err != nil {
+			log.Error("Detached work did not finish in time", "error", err)
+			errs = append(errs, err)
+		}
+
+		// then the components, in reverse start order
+		errs = append(errs, shutdownComponents(ctx, log, components))
+		shutdownErr = errors.Join(errs...)
+		stop()
+
 		// verify, in worst case call cancel via defer
 		select {
 		case <-time.After(cfg.GracefulTimeout):
@@ -83,22 +214,37 @@ func Listen(cfg Config, router Muxer, cleanUp func()) error {
 		case <-ctx.Done():
 
 		}
-		//}
 	}()
 
-	log.Info("Starting a new server on address: " + cfg.Addr)
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l *listener) {
+			errs <- l.serve()
+		}(l)
+	}
 
-	if !cfg.TLSEnabled {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Error("A server listener error: " + err.Error())
-			return err
-		}
-	} else {
-		if err := srv.ListenAndServeTLS(cfg.CertPath, cfg.KeyPath); err != http.ErrServerClosed {
-			log.Error("A tls server listener error: " + err.Error())
-			return err
+	var err error
+	for range listeners {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+			close(failed)
 		}
 	}
+	<-stopped
+	if err = errors.Join(err, shutdownErr); err != nil {
+		return err
+	}
 	log.Info("Server is down")
 	return nil
 }
+
+func shutdownComponents(ctx context.Context, log *slog.Logger, components []Lifecycle) error {
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		if err := components[i].Shutdown(ctx); err != nil {
+			log.Error("Error shutting down a component", "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,45 @@
+package xserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type component struct {
+	err     error
+	drained bool
+	down    bool
+}
+
+func (c *component) Start() error { return nil }
+
+func (c *component) Drain() { c.drained = true }
+
+func (c *component) Shutdown(ctx context.Context) error {
+	c.down = true
+	return c.err
+}
+
+func TestRunShutsDownEverythingOnErrors(t *testing.T) {
+	cfg := Config{Addr: "127.0.0.1:-1", ShutdownTimeout: time.Second, GracefulTimeout: time.Millisecond}
+	failing := &component{err: errors.New("component failed")}
+	healthy := &component{}
+
+	err := run(cfg, []*listener{newListener(cfg, http.NotFoundHandler())}, func() {},
+		[]Lifecycle{healthy, failing})
+	if err == nil {
+		t.Fatal("run returned no error")
+	}
+	if !errors.Is(err, failing.err) {
+		t.Errorf("error = %v, want the component error joined", err)
+	}
+	if !failing.down || !healthy.down {
+		t.Errorf("components shut down = %v, %v, want both", healthy.down, failing.down)
+	}
+	if !failing.drained || !healthy.drained {
+		t.Errorf("components drained = %v, %v, want both", healthy.drained, failing.drained)
+	}
+}
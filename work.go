@@ -0,0 +1,164 @@
+package xserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/l00p8/xserver"
+
+// PanicError is the error of a function which panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Protect runs fn turning its panic into a PanicError carrying the stack of the panic
+func Protect(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			err = &PanicError{Value: rvr, Stack: debug.Stack()}
+		}
+	}()
+	return fn(ctx)
+}
+
+// traced runs fn in a child span of the context
+func traced(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, name)
+	defer span.End()
+	err := Protect(ctx, fn)
+	if err != nil {
+		recordError(span, err)
+	}
+	return err
+}
+
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Group runs functions concurrently within a request, like errgroup: the first error
+// cancels the context of the others and is returned by Wait. Panics are returned as PanicError
+// and each function runs in a child span of the group context.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	errOnce sync.Once
+	err     error
+}
+
+// NewGroup returns a group running at most limit functions at once, zero is unlimited.
+// The returned context is canceled when a function fails or Wait returns.
+func NewGroup(ctx context.Context, limit int) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	g := &Group{ctx: ctx, cancel: cancel}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit)
+	}
+	return g, ctx
+}
+
+func (g *Group) fail(err error) {
+	g.errOnce.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
+
+// Go runs fn in a span with the given name, it blocks while the group runs limit functions
+// and skips fn once the group context is canceled
+func (g *Group) Go(name string, fn func(ctx context.Context) error) {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		case <-g.ctx.Done():
+			g.fail(g.ctx.Err())
+			return
+		}
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+		if err := traced(g.ctx, name, fn); err != nil {
+			g.fail(err)
+		}
+	}()
+}
+
+// Wait waits for the functions and returns the first error
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// workTracker keeps count of the work detached from the requests of a server, run drains it on shutdown
+type workTracker struct {
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    *slog.Logger
+}
+
+func newWorkTracker(log *slog.Logger) *workTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workTracker{ctx: ctx, cancel: cancel, log: log}
+}
+
+// drain waits for the detached work until ctx is done, then cancels what is left
+func (t *workTracker) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.cancel()
+		return ctx.Err()
+	}
+}
+
+// Detach runs fn in the background past the end of the request. It keeps the values of ctx,
+// such as the request id and the span, but not its cancellation. Listen waits for the work
+// detached from the requests of its server on shutdown and cancels its context when the
+// shutdown timeout is reached, the work detached outside of a server is not waited for.
+// Errors and panics are logged.
+func Detach(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	t := newWorkTracker(slog.Default())
+	if s, ok := ctx.Value(serverStateKey{}).(*serverState); ok {
+		t = s.work
+	}
+	t.wg.Add(1)
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(t.ctx, cancel)
+	go func() {
+		defer t.wg.Done()
+		defer cancel()
+		defer stop()
+		if err := traced(ctx, name, fn); err != nil {
+			t.log.ErrorContext(ctx, "Detached work failed", "name", name, "error", err)
+		}
+	}()
+}
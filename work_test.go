@@ -0,0 +1,146 @@
+package xserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupLimit(t *testing.T) {
+	g, _ := NewGroup(context.Background(), 2)
+	var running, peak, ran atomic.Int32
+	for i := 0; i < 6; i++ {
+		g.Go("work", func(ctx context.Context) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			ran.Add(1)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if ran.Load() != 6 || peak.Load() > 2 {
+		t.Errorf("ran %d functions with %d at once, want 6 with at most 2", ran.Load(), peak.Load())
+	}
+}
+
+func TestGroupFirstErrorCancels(t *testing.T) {
+	errFirst := errors.New("first")
+	g, ctx := NewGroup(context.Background(), 0)
+	canceled := make(chan error, 1)
+	g.Go("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return ctx.Err()
+	})
+	g.Go("failing", func(ctx context.Context) error {
+		return errFirst
+	})
+	if err := g.Wait(); err != errFirst {
+		t.Errorf("Wait = %v, want the first error", err)
+	}
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("the other function saw %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("the group context is not canceled")
+	}
+
+}
+
+func TestProtect(t *testing.T) {
+	err := Protect(context.Background(), func(ctx context.Context) error {
+		panic("boom")
+	})
+	var perr *PanicError
+	if !errors.As(err, &perr) || perr.Value != "boom" || err.Error() != "panic: boom" {
+		t.Fatalf("err = %v", err)
+	}
+	if !bytes.Contains(perr.Stack, []byte("TestProtect")) {
+		t.Errorf("the stack does not lead to the panic:\n%s", perr.Stack)
+	}
+
+	g, _ := NewGroup(context.Background(), 0)
+	g.Go("panicking", func(ctx context.Context) error {
+		panic("in a group")
+	})
+	if err := g.Wait(); !errors.As(err, &perr) || perr.Value != "in a group" {
+		t.Errorf("group err = %v", err)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	var logs bytes.Buffer
+	state := newServerState(slog.New(slog.NewTextHandler(&logs, nil)))
+	type key struct{}
+	reqCtx, cancelReq := context.WithCancel(context.WithValue(state.context(context.Background()), key{}, "value"))
+
+	done := make(chan struct{})
+	Detach(reqCtx, "finishing", func(ctx context.Context) error {
+		defer close(done)
+		time.Sleep(20 * time.Millisecond)
+		if ctx.Err() != nil || ctx.Value(key{}) != "value" {
+			t.Errorf("detached context: err %v, value %v", ctx.Err(), ctx.Value(key{}))
+		}
+		return errors.New("failed")
+	})
+	// the end of the request does not cancel the work
+	cancelReq()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := state.work.drain(ctx); err != nil {
+		t.Fatalf("drain = %v", err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("drain returned before the work finished")
+	}
+	if !strings.Contains(logs.String(), "Detached work failed") {
+		t.Errorf("the error is not logged: %s", logs.String())
+	}
+
+	// the work left at the shutdown timeout is canceled
+	canceled := make(chan struct{})
+	Detach(state.context(context.Background()), "stuck", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := state.work.drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("drain = %v, want the deadline", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the work left was not canceled")
+	}
+
+	// the work of another server is not canceled with it
+	other := newServerState(slog.Default())
+	running := make(chan error, 1)
+	Detach(other.context(context.Background()), "other", func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		running <- ctx.Err()
+		return nil
+	})
+	if err := <-running; err != nil {
+		t.Errorf("the work of another server is canceled: %v", err)
+	}
+}